@@ -1,17 +1,29 @@
 package chain
 
 import (
+	"errors"
 	"fmt"
 	"github.com/LemoFoundationLtd/lemochain-core/chain/deputynode"
 	"github.com/LemoFoundationLtd/lemochain-core/chain/params"
 	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
 	"github.com/LemoFoundationLtd/lemochain-core/common"
 	"github.com/LemoFoundationLtd/lemochain-core/common/log"
+	"github.com/LemoFoundationLtd/lemochain-core/common/subscribe"
 	coreNet "github.com/LemoFoundationLtd/lemochain-core/network"
 	"github.com/LemoFoundationLtd/lemochain-core/store"
 	"github.com/LemoFoundationLtd/lemochain-distribution/database"
+	"github.com/LemoFoundationLtd/lemochain-distribution/metrics"
 	"sync"
 	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrParentNotExist     = errors.New("parent block is not exist")
+	ErrParentNotCanonical = errors.New("parent block is not in current chain")
+	ErrUndoNotExist       = errors.New("undo journal of block is not exist")
+	ErrOutOfUndoRetention = errors.New("the height is out of undo retention")
+	ErrNotWriter          = errors.New("the node doesn't hold the writer lease")
 )
 
 type BlockChain struct {
@@ -20,11 +32,15 @@ type BlockChain struct {
 	stableBlock  atomic.Value // latest stable block in current chain
 	genesisBlock *types.Block // genesis block
 
-	chainForksHead map[common.Hash]*types.Block // total latest header of different fork chain
-	chainForksLock sync.Mutex
-	mux            sync.Mutex
-	running        int32
-	dbEngine       database.DBEngine
+	mux           sync.Mutex
+	running       int32
+	dbEngine      database.DBEngine
+	undoRetention uint32 // how many latest blocks can be reverted
+
+	// the chain only writes database before writerDeadline if leaseOwner is set, and the lease is checked again in the
+	// sql transaction of each write
+	leaseOwner     atomic.Value // string
+	writerDeadline int64        // unix nanoseconds
 }
 
 // blockLoader BlockLoader implement for deputynode.Manager
@@ -41,11 +57,11 @@ func (b *blockLoader) GetBlockByHeight(height uint32) (*types.Block, error) {
 	}
 }
 
-func NewBlockChain(chainID uint16, deputyCount int, dbEngine database.DBEngine) (bc *BlockChain, err error) {
+func NewBlockChain(chainID uint16, deputyCount int, undoRetention uint32, dbEngine database.DBEngine) (bc *BlockChain, err error) {
 	bc = &BlockChain{
-		chainID:        chainID,
-		chainForksHead: make(map[common.Hash]*types.Block, 16),
-		dbEngine:       dbEngine,
+		chainID:       chainID,
+		dbEngine:      dbEngine,
+		undoRetention: undoRetention,
 	}
 
 	if err := bc.loadGenesis(); err != nil {
@@ -95,7 +111,7 @@ func (bc *BlockChain) loadLastState() error {
 		log.Errorf("Can't load last state: %v", err)
 		return err
 	}
-	bc.stableBlock.Store(block)
+	bc.setStableBlock(block)
 	return nil
 }
 
@@ -167,6 +183,12 @@ func (bc *BlockChain) CurrentBlock() *types.Block {
 	return bc.StableBlock()
 }
 
+// setStableBlock change the stable block of current chain
+func (bc *BlockChain) setStableBlock(block *types.Block) {
+	bc.stableBlock.Store(block)
+	metrics.StableHeight.Set(float64(block.Height()))
+}
+
 // StableBlock get latest stable block
 func (bc *BlockChain) StableBlock() *types.Block {
 	if bc.stableBlock.Load() == nil {
@@ -183,31 +205,281 @@ func (bc *BlockChain) updateDeputyNodes(block *types.Block) {
 	}
 }
 
+// SetWriterLease allow the chain to write database as the lease owner until deadline. The chain without lease is always writable
+func (bc *BlockChain) SetWriterLease(owner string, deadline time.Time) {
+	atomic.StoreInt64(&bc.writerDeadline, deadline.UnixNano())
+	bc.leaseOwner.Store(owner)
+}
+
+// LeaseOwner return the owner of writer lease which is set by SetWriterLease
+func (bc *BlockChain) LeaseOwner() string {
+	owner, _ := bc.leaseOwner.Load().(string)
+	return owner
+}
+
+// IsWriter test if the chain can write database now
+func (bc *BlockChain) IsWriter() bool {
+	return bc.LeaseOwner() == "" || time.Now().UnixNano() < atomic.LoadInt64(&bc.writerDeadline)
+}
+
+// checkLease test if the chain still holds the writer lease in the sql transaction. The lease row is locked, so no other
+// node can take over the lease before the transaction is committed
+func checkLease(txEngine *database.TxEngine, owner string) error {
+	if owner == "" {
+		return nil
+	}
+	ok, err := database.NewLeaseDao(txEngine).Hold(database.ContextKeyWriterLease, owner)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotWriter
+	}
+	return nil
+}
+
 func (bc *BlockChain) InsertBlock(block *types.Block) error {
+	return bc.insertPrepared(prepareBlock(block))
+}
+
+// insertPrepared save the prepared block as the new stable block. The event is sent after unlocking, so the subscribers
+// can't block the chain
+func (bc *BlockChain) insertPrepared(prepared *preparedBlock) error {
+	event, err := bc.savePrepared(prepared)
+	if err != nil || event == nil {
+		return err
+	}
+	subscribe.Send(subscribe.NewStableBlock, event)
+	return nil
+}
+
+// savePrepared save the prepared block and return its event, or nil if the block is saved already
+func (bc *BlockChain) savePrepared(prepared *preparedBlock) (*BlockEvent, error) {
 	bc.mux.Lock()
 	defer bc.mux.Unlock()
+	if !bc.IsWriter() {
+		return nil, ErrNotWriter
+	}
 
-	hash := block.Hash()
+	block := prepared.block
+	hash := prepared.hash
 	blockDao := database.NewBlockDao(bc.dbEngine)
 	has, err := blockDao.IsExist(hash)
 	if err != nil || has {
-		return err
+		return nil, err
 	}
 
-	reBuildEngine := NewReBuildEngine(bc.dbEngine, block)
+	// the block is not a child of current block, so the core node has switched to another fork
+	stable := bc.StableBlock()
+	if stable != nil && block.Height() > 0 && block.ParentHash() != stable.Hash() {
+		if err := bc.switchFork(block); err != nil {
+			log.Errorf("switch to fork of block[%d] failed: %v", block.Height(), err)
+			return nil, err
+		}
+	}
+
+	reBuildEngine := newReBuildEngine(bc.dbEngine, prepared)
+	reBuildEngine.leaseOwner = bc.LeaseOwner()
 	err = reBuildEngine.ReBuild()
 	if err != nil {
-		return err
+		return nil, err
 	} else {
 		bc.updateDeputyNodes(block)
-		bc.stableBlock.Store(block)
+		bc.setStableBlock(block)
 		if block.Height() == 0 {
 			bc.genesisBlock = block
 		}
+		bc.pruneUndo(block.Height())
 
 		log.Debugf("insert block success. Height:%d", block.Height())
+		return reBuildEngine.Event, nil
+	}
+}
+
+// Refresh reload the stable block which is written by another process, and load the deputy nodes of the new terms
+func (bc *BlockChain) Refresh() error {
+	events, err := bc.refresh()
+	if err != nil {
+		return err
+	}
+	// send after unlocking, so the subscribers can't block the chain
+	for _, event := range events {
+		subscribe.Send(subscribe.NewStableBlock, event)
+	}
+	return nil
+}
+
+// refresh reload the stable block and return the events of the new blocks
+func (bc *BlockChain) refresh() ([]*BlockEvent, error) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	contextDao := database.NewContextDao(bc.dbEngine)
+	block, err := contextDao.GetCurrentBlock()
+	if err == database.ErrNotExist {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	stable := bc.StableBlock()
+	if stable != nil && stable.Hash() == block.Hash() {
+		return nil, nil
+	}
+	if bc.genesisBlock == nil {
+		if err := bc.loadGenesis(); err != nil {
+			return nil, err
+		}
+	}
+
+	// load snapshots after the old stable block. Load all of them again if the chain has been reverted. The events of the
+	// blocks after the old stable block are sent in order. Only the new stable block is notified after revert
+	blockDao := database.NewBlockDao(bc.dbEngine)
+	snapshotHeight := uint32(0)
+	notifyHeight := block.Height()
+	if stable != nil {
+		canonical, err := blockDao.GetBlockByHeight(stable.Height())
+		if err == nil && canonical.Hash() == stable.Hash() {
+			snapshotHeight = (stable.Height()/params.TermDuration + 1) * params.TermDuration
+			if stable.Height() < block.Height() {
+				notifyHeight = stable.Height() + 1
+			}
+		} else if err != nil && err != database.ErrNotExist {
+			return nil, err
+		}
+	}
+	for ; snapshotHeight <= block.Height(); snapshotHeight += params.TermDuration {
+		snapshot, err := blockDao.GetBlockByHeight(snapshotHeight)
+		if err != nil {
+			return nil, err
+		}
+		bc.updateDeputyNodes(snapshot)
+	}
+	events := make([]*BlockEvent, 0, block.Height()-notifyHeight+1)
+	for height := notifyHeight; height <= block.Height(); height++ {
+		event, err := bc.loadBlockEvent(height)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	bc.setStableBlock(block)
+	log.Debugf("refresh stable block. Height:%d", block.Height())
+	return events, nil
+}
+
+// loadBlockEvent build the event of the saved block from database, like the event which is sent after ReBuild
+func (bc *BlockChain) loadBlockEvent(height uint32) (*BlockEvent, error) {
+	block, err := database.NewBlockDao(bc.dbEngine).GetBlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	txs, err := database.NewTxDao(bc.dbEngine).GetByHeightRange(height, height)
+	if err != nil {
+		return nil, err
+	}
+	return &BlockEvent{Block: block, Txs: txs, Candidates: candidateEvents(block.ChangeLogs)}, nil
+}
+
+// pruneUndo drop the undo journal which is out of retention
+func (bc *BlockChain) pruneUndo(height uint32) {
+	if height <= bc.undoRetention {
+		return
+	}
+	undoDao := database.NewUndoDao(bc.dbEngine)
+	if err := undoDao.Prune(height - bc.undoRetention); err != nil {
+		log.Errorf("prune undo journal below %d failed: %v", height-bc.undoRetention, err)
+	}
+}
+
+// RevertTo revert the database to the block at height. The height must be in the undo retention
+func (bc *BlockChain) RevertTo(height uint32) error {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	if !bc.IsWriter() {
+		return ErrNotWriter
+	}
+
+	stable := bc.StableBlock()
+	if stable == nil || stable.Height() <= height {
 		return nil
 	}
+	if stable.Height()-height > bc.undoRetention {
+		return ErrOutOfUndoRetention
+	}
+	undoDao := database.NewUndoDao(bc.dbEngine)
+	oldest, err := undoDao.OldestHeight()
+	if err == database.ErrNotExist || (err == nil && oldest > height+1) {
+		return ErrOutOfUndoRetention
+	}
+	if err != nil {
+		return err
+	}
+	return bc.revertTo(height)
+}
+
+// switchFork roll back the chain to the parent of block, then the block can be inserted
+func (bc *BlockChain) switchFork(block *types.Block) error {
+	blockDao := database.NewBlockDao(bc.dbEngine)
+	parent, err := blockDao.GetBlock(block.ParentHash())
+	if err == database.ErrNotExist {
+		return ErrParentNotExist
+	}
+	if err != nil {
+		return err
+	}
+
+	canonical, err := blockDao.GetBlockByHeight(parent.Height())
+	if err != nil {
+		return err
+	}
+	if canonical.Hash() != parent.Hash() {
+		return ErrParentNotCanonical
+	}
+
+	log.Warnf("chain fork at height %d. revert blocks from %d", parent.Height(), bc.StableBlock().Height())
+	return bc.revertTo(parent.Height())
+}
+
+// revertTo undo the blocks above height from the newest one in a single sql transaction, and set the block at height as the stable block.
+// The deputy nodes snapshot will be overwritten when the snapshot block of new fork is inserted
+func (bc *BlockChain) revertTo(height uint32) error {
+	stable := bc.StableBlock()
+	if stable == nil || stable.Height() <= height {
+		return nil
+	}
+
+	txEngine, err := database.BeginTx(bc.dbEngine)
+	if err != nil {
+		return err
+	}
+	defer txEngine.Rollback()
+	if err := checkLease(txEngine, bc.LeaseOwner()); err != nil {
+		return err
+	}
+
+	undoDao := database.NewUndoDao(txEngine)
+	for h := stable.Height(); h > height; h-- {
+		if err := undoDao.Revert(h); err != nil {
+			return err
+		}
+	}
+
+	contextDao := database.NewContextDao(txEngine)
+	block, err := contextDao.GetCurrentBlock()
+	if err == database.ErrNotExist || (err == nil && block.Height() != height) {
+		return ErrUndoNotExist
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := txEngine.Commit(); err != nil {
+		return err
+	}
+	bc.setStableBlock(block)
+	log.Infof("revert chain to block success. Height: %d", height)
+	return nil
 }
 
 // not used. just for implement interface
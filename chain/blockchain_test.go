@@ -0,0 +1,244 @@
+package chain
+
+import (
+	"github.com/LemoFoundationLtd/lemochain-core/chain/account"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/params"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-core/common/subscribe"
+	"github.com/LemoFoundationLtd/lemochain-distribution/database"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const benchTxCount = 200
+
+func newTestChain(t testing.TB) (*BlockChain, func()) {
+	dir, err := ioutil.TempDir("", "lemo-distribution")
+	assert.NoError(t, err)
+	db := database.NewSqlDB(database.DRIVER_SQLITE3, filepath.Join(dir, "lemochain.db"))
+	assert.NoError(t, database.CreateDB(db))
+
+	bc, err := NewBlockChain(1, 17, 100, db)
+	assert.NoError(t, err)
+	return bc, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// makeBlocks create count blocks after genesis, each block contains benchTxCount transactions
+func makeBlocks(count int) []*types.Block {
+	blocks := make([]*types.Block, 0, count+1)
+	parent := common.Hash{}
+	for height := 0; height <= count; height++ {
+		header := &types.Header{ParentHash: parent, Height: uint32(height), Time: uint32(1600000000 + height)}
+		var txs []*types.Transaction
+		if height > 0 {
+			for i := 0; i < benchTxCount; i++ {
+				from := common.BigToAddress(big.NewInt(int64(i + 1)))
+				to := common.BigToAddress(big.NewInt(int64(height*benchTxCount + i)))
+				txs = append(txs, types.NewTransaction(from, to, big.NewInt(1), 21000, big.NewInt(1), nil, params.OrdinaryTx, 1, uint64(1600000000+height), "", ""))
+			}
+		}
+		block := types.NewBlock(header, txs, nil)
+		if height == 0 {
+			block.SetDeputyNodes(types.DeputyNodes{{MinerAddress: common.BigToAddress(big.NewInt(1)), NodeID: make([]byte, 64), Votes: big.NewInt(1)}})
+		}
+		blocks = append(blocks, block)
+		parent = block.Hash()
+	}
+	return blocks
+}
+
+func TestBlockChain_WriterLease(t *testing.T) {
+	bc, clean := newTestChain(t)
+	defer clean()
+	blocks := makeBlocks(2)
+	leaseDao := database.NewLeaseDao(bc.dbEngine)
+
+	ok, err := leaseDao.Acquire(database.ContextKeyWriterLease, "node1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	bc.SetWriterLease("node1", time.Now().Add(time.Minute))
+	for _, block := range blocks[:2] {
+		assert.NoError(t, bc.InsertBlock(block))
+	}
+
+	// the local deadline is not expired, but another node has taken over the lease
+	assert.NoError(t, leaseDao.Release(database.ContextKeyWriterLease, "node1"))
+	ok, err = leaseDao.Acquire(database.ContextKeyWriterLease, "node2", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, ErrNotWriter, bc.InsertBlock(blocks[2]))
+	assert.Equal(t, ErrNotWriter, bc.RevertTo(0))
+	assert.Equal(t, uint32(1), bc.StableBlock().Height())
+
+	// the local deadline is expired
+	bc.SetWriterLease("node2", time.Unix(0, 0))
+	assert.Equal(t, ErrNotWriter, bc.InsertBlock(blocks[2]))
+}
+
+func TestBlockChain_RefreshEvents(t *testing.T) {
+	writer, clean := newTestChain(t)
+	defer clean()
+	blocks := makeBlocks(3)
+	assert.NoError(t, writer.InsertBlock(blocks[0]))
+	reader, err := NewBlockChain(1, 17, 100, writer.dbEngine)
+	assert.NoError(t, err)
+
+	// the votes of candidate are changed in block 2, and another candidate is cancelled in block 3
+	candidate := common.HexToAddress("0x0100")
+	cancelled := common.HexToAddress("0x0200")
+	profile := types.Profile{types.CandidateKeyIsCandidate: types.NotCandidateNode}
+	blocks[2].ChangeLogs = types.ChangeLogSlice{
+		{LogType: account.VotesLog, Address: candidate, Version: 1, NewVal: *big.NewInt(100)},
+	}
+	blocks[3].ChangeLogs = types.ChangeLogSlice{
+		{LogType: account.CandidateLog, Address: cancelled, Version: 1, NewVal: &profile},
+	}
+	for _, block := range blocks[1:] {
+		assert.NoError(t, writer.InsertBlock(block))
+	}
+
+	eventCh := make(chan *BlockEvent, 10)
+	subscribe.Sub(subscribe.NewStableBlock, eventCh)
+	defer subscribe.UnSub(subscribe.NewStableBlock, eventCh)
+	assert.NoError(t, reader.Refresh())
+	assert.Equal(t, blocks[3].Hash(), reader.StableBlock().Hash())
+
+	// the events of all the new blocks are sent in order
+	for _, block := range blocks[1:] {
+		event := <-eventCh
+		assert.Equal(t, block.Hash(), event.Block.Hash())
+		assert.Equal(t, benchTxCount, len(event.Txs))
+		assert.Equal(t, block.Height(), event.Txs[0].Height)
+	}
+	assert.Empty(t, eventCh)
+	assert.NoError(t, reader.Refresh())
+	assert.Empty(t, eventCh)
+
+	assert.Equal(t, []*CandidateEvent{{Address: candidate, Votes: big.NewInt(100)}}, candidateEvents(blocks[2].ChangeLogs))
+	assert.Equal(t, []*CandidateEvent{{Address: cancelled, Cancelled: true}}, candidateEvents(blocks[3].ChangeLogs))
+}
+
+func TestBlockChain_SendAfterUnlock(t *testing.T) {
+	bc, clean := newTestChain(t)
+	defer clean()
+	blocks := makeBlocks(1)
+	assert.NoError(t, bc.InsertBlock(blocks[0]))
+
+	// a subscriber which doesn't receive the event yet
+	ch := make(chan *BlockEvent)
+	subscribe.Sub(subscribe.NewStableBlock, ch)
+	defer subscribe.UnSub(subscribe.NewStableBlock, ch)
+	done := make(chan error)
+	go func() {
+		done <- bc.InsertBlock(blocks[1])
+	}()
+
+	// the chain is unlocked while the event is waiting for the subscriber
+	locked := make(chan struct{})
+	go func() {
+		for bc.StableBlock().Hash() != blocks[1].Hash() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		bc.mux.Lock()
+		bc.mux.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		<-ch
+		t.Fatal("chain is locked by the subscriber")
+	}
+	assert.Equal(t, blocks[1].Hash(), (<-ch).Block.Hash())
+	assert.NoError(t, <-done)
+}
+
+func TestBlockChain_SwitchFork(t *testing.T) {
+	bc, clean := newTestChain(t)
+	defer clean()
+	genesis := makeBlocks(0)[0]
+	assert.NoError(t, bc.InsertBlock(genesis))
+	addr := common.BigToAddress(big.NewInt(100))
+	newBlock := func(parent *types.Block, extra string, txs []*types.Transaction, logs types.ChangeLogSlice) *types.Block {
+		header := &types.Header{ParentHash: parent.Hash(), Height: parent.Height() + 1, Extra: extra}
+		return types.NewBlock(header, txs, logs)
+	}
+	newTx := func(amount int64) *types.Transaction {
+		return types.NewTransaction(addr, common.BigToAddress(big.NewInt(2)), big.NewInt(amount), 21000, big.NewInt(1), nil, 0, 1, 1600000000, "", "")
+	}
+
+	blockA := newBlock(genesis, "", nil, types.ChangeLogSlice{
+		{LogType: account.BalanceLog, Address: addr, Version: 1, NewVal: *big.NewInt(100)},
+	})
+	txB := newTx(1)
+	blockB := newBlock(blockA, "", []*types.Transaction{txB}, types.ChangeLogSlice{
+		{LogType: account.BalanceLog, Address: addr, Version: 2, NewVal: *big.NewInt(200)},
+		{LogType: account.AddEventLog, Address: addr, Version: 1, NewVal: &types.Event{Address: addr, Data: []byte{1}}},
+	})
+	assert.NoError(t, bc.InsertBlock(blockA))
+	assert.NoError(t, bc.InsertBlock(blockB))
+	accountDao := database.NewAccountDao(bc.dbEngine)
+	data, err := accountDao.Get(addr)
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(200), data.Balance)
+
+	// B' replaces B
+	txFork := newTx(2)
+	blockFork := newBlock(blockA, "fork", []*types.Transaction{txFork}, types.ChangeLogSlice{
+		{LogType: account.BalanceLog, Address: addr, Version: 2, NewVal: *big.NewInt(300)},
+	})
+	assert.NoError(t, bc.InsertBlock(blockFork))
+	assert.Equal(t, blockFork.Hash(), bc.StableBlock().Hash())
+
+	// the rows of B are reverted
+	blockDao := database.NewBlockDao(bc.dbEngine)
+	_, err = blockDao.GetBlock(blockB.Hash())
+	assert.Equal(t, database.ErrNotExist, err)
+	txDao := database.NewTxDao(bc.dbEngine)
+	_, err = txDao.Get(txB.Hash())
+	assert.Equal(t, database.ErrNotExist, err)
+	events, err := database.NewEventDao(bc.dbEngine).GetByFilter(&database.EventFilter{FromHeight: 0, ToHeight: 2}, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	// the state of B' is applied
+	data, err = accountDao.Get(addr)
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(300), data.Balance)
+	_, err = txDao.Get(txFork.Hash())
+	assert.NoError(t, err)
+	canonical, err := blockDao.GetBlockByHeight(2)
+	assert.NoError(t, err)
+	assert.Equal(t, blockFork.Hash(), canonical.Hash())
+	rows, _, err := blockDao.GetRowsByHeightWithTotal(2, 2, 0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, blockFork.Hash(), rows[0].Hash)
+	current, err := database.NewContextDao(bc.dbEngine).GetCurrentBlock()
+	assert.NoError(t, err)
+	assert.Equal(t, blockFork.Hash(), current.Hash())
+}
+
+func TestBlockChain_SwitchForkOutOfRetention(t *testing.T) {
+	bc, clean := newTestChain(t)
+	defer clean()
+	bc.undoRetention = 2
+	blocks := makeBlocks(5)
+	assert.NoError(t, bc.InsertBlocks(blocks))
+
+	// the undo of block 2 is pruned
+	fork := types.NewBlock(&types.Header{ParentHash: blocks[1].Hash(), Height: 2, Extra: "fork"}, nil, nil)
+	assert.Equal(t, ErrUndoNotExist, bc.InsertBlock(fork))
+	assert.Equal(t, blocks[5].Hash(), bc.StableBlock().Hash())
+	current, err := database.NewContextDao(bc.dbEngine).GetCurrentBlock()
+	assert.NoError(t, err)
+	assert.Equal(t, blocks[5].Hash(), current.Hash())
+}
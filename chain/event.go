@@ -0,0 +1,56 @@
+package chain
+
+import (
+	"github.com/LemoFoundationLtd/lemochain-core/chain/account"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-distribution/database"
+	"math/big"
+)
+
+// BlockEvent is sent by subscribe.NewStableBlock after a block is saved to database
+type BlockEvent struct {
+	Block      *types.Block
+	Txs        []*database.Tx // the saved txs, including the txs in box
+	Candidates []*CandidateEvent
+}
+
+// CandidateEvent is the candidate whose votes or profile is changed by the block
+type CandidateEvent struct {
+	Address   common.Address
+	Votes     *big.Int
+	Cancelled bool
+}
+
+// candidateEvents find the candidates whose votes are changed or who are cancelled by the change logs. It rebuilds the
+// candidates of the event of a block which is saved by another node. The candidates whose profile is changed only are
+// not included, because their votes are unknown without replaying the change logs
+func candidateEvents(logs types.ChangeLogSlice) []*CandidateEvent {
+	var events []*CandidateEvent
+	indexes := make(map[common.Address]int)
+	get := func(address common.Address) *CandidateEvent {
+		if i, ok := indexes[address]; ok {
+			return events[i]
+		}
+		indexes[address] = len(events)
+		events = append(events, &CandidateEvent{Address: address})
+		return events[len(events)-1]
+	}
+	for _, cl := range logs {
+		switch cl.LogType {
+		case account.VotesLog:
+			if votes, ok := cl.NewVal.(big.Int); ok {
+				get(cl.Address).Votes = new(big.Int).Set(&votes)
+			}
+		case account.CandidateLog:
+			if profile, ok := cl.NewVal.(*types.Profile); ok && (*profile)[types.CandidateKeyIsCandidate] == types.NotCandidateNode {
+				get(cl.Address).Cancelled = true
+			}
+		case account.CandidateStateLog:
+			if key, ok := cl.Extra.(string); ok && key == types.CandidateKeyIsCandidate && cl.NewVal == types.NotCandidateNode {
+				get(cl.Address).Cancelled = true
+			}
+		}
+	}
+	return events
+}
@@ -0,0 +1,168 @@
+package chain
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-core/common/log"
+	"github.com/LemoFoundationLtd/lemochain-core/common/rlp"
+	"github.com/LemoFoundationLtd/lemochain-distribution/database"
+	"io"
+	"os"
+	"strings"
+)
+
+const exportBatchSize = 100 // blocks whose txs are queried at once
+
+var ErrExportRange = errors.New("export range is out of the stable blocks")
+
+// ExportTx is a row of saved tx in export file. The block hash and height are in its block
+type ExportTx struct {
+	Hash        common.Hash    `json:"hash"`
+	BoxHash     common.Hash    `json:"boxHash"` // the hash of box if the tx is in a box
+	From        common.Address `json:"from"`
+	To          common.Address `json:"to"`
+	Type        uint16         `json:"type"`
+	SaveTime    uint64         `json:"saveTime"` // milliseconds
+	PackageTime uint32         `json:"packageTime"`
+	AssetCode   common.Hash    `json:"assetCode"`
+	AssetId     common.Hash    `json:"assetId"`
+}
+
+func newExportTx(tx *database.Tx) *ExportTx {
+	return &ExportTx{
+		Hash:        tx.THash,
+		BoxHash:     tx.PHash,
+		From:        tx.From,
+		To:          tx.To,
+		Type:        uint16(tx.Flag),
+		SaveTime:    uint64(tx.St),
+		PackageTime: tx.PackageTime,
+		AssetCode:   tx.AssetCode,
+		AssetId:     tx.AssetId,
+	}
+}
+
+// exportRecord is a block with its tx rows in export file
+type exportRecord struct {
+	Block *types.Block `json:"block"`
+	Txs   []*ExportTx  `json:"txs,omitempty"`
+}
+
+// BlockWriter write the exported blocks
+type BlockWriter interface {
+	// Write save the block. The txs is nil if the tx rows are not exported
+	Write(block *types.Block, txs []*ExportTx) error
+	Close() error
+}
+
+// blockFile write the blocks to a json-lines file if its name ends with ".jsonl" or ".jsonl.gz". Otherwise it writes a
+// rlp stream of blocks, or of [block, txs] lists if the txs are exported. The file is gzipped if its name ends with ".gz"
+type blockFile struct {
+	file    *os.File
+	buf     *bufio.Writer
+	gz      *gzip.Writer
+	writer  io.Writer
+	isJSON  bool
+	encoder *json.Encoder
+}
+
+// CreateBlockFile create a new file to write blocks. It doesn't overwrite existed file
+func CreateBlockFile(path string) (BlockWriter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f := &blockFile{file: file, buf: bufio.NewWriter(file)}
+	f.writer = f.buf
+	name := path
+	if strings.HasSuffix(name, ".gz") {
+		f.gz = gzip.NewWriter(f.buf)
+		f.writer = f.gz
+		name = strings.TrimSuffix(name, ".gz")
+	}
+	if strings.HasSuffix(name, ".jsonl") {
+		f.isJSON = true
+		f.encoder = json.NewEncoder(f.writer)
+	}
+	return f, nil
+}
+
+func (f *blockFile) Write(block *types.Block, txs []*ExportTx) error {
+	if f.isJSON {
+		return f.encoder.Encode(&exportRecord{Block: block, Txs: txs})
+	}
+	if txs == nil {
+		return rlp.Encode(f.writer, block)
+	}
+	return rlp.Encode(f.writer, &exportRecord{Block: block, Txs: txs})
+}
+
+func (f *blockFile) Close() error {
+	if f.gz != nil {
+		if err := f.gz.Close(); err != nil {
+			f.file.Close()
+			return err
+		}
+	}
+	if err := f.buf.Flush(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+// Export write the stable blocks from height from to height to. The tx rows of the blocks are written if withTxs is true.
+// It returns the count of exported blocks
+func (bc *BlockChain) Export(w BlockWriter, from, to uint32, withTxs bool) (int, error) {
+	stable := bc.StableBlock()
+	if stable == nil || from > to || to > stable.Height() {
+		return 0, ErrExportRange
+	}
+
+	blockDao := database.NewBlockDao(bc.dbEngine)
+	txDao := database.NewTxDao(bc.dbEngine)
+	count := 0
+	for start := uint64(from); start <= uint64(to); start += exportBatchSize {
+		end := start + exportBatchSize - 1
+		if end > uint64(to) {
+			end = uint64(to)
+		}
+		// the tx rows of a batch are queried at once
+		var txsByHeight map[uint32][]*ExportTx
+		if withTxs {
+			txes, err := txDao.GetByHeightRange(uint32(start), uint32(end))
+			if err != nil {
+				return count, err
+			}
+			txsByHeight = make(map[uint32][]*ExportTx)
+			for _, tx := range txes {
+				txsByHeight[tx.Height] = append(txsByHeight[tx.Height], newExportTx(tx))
+			}
+		}
+
+		for height := uint32(start); height <= uint32(end); height++ {
+			block, err := blockDao.GetBlockByHeight(height)
+			if err != nil {
+				log.Errorf("get block %d failed: %v", height, err)
+				return count, err
+			}
+			var txs []*ExportTx
+			if withTxs {
+				txs = txsByHeight[height]
+				if txs == nil {
+					txs = make([]*ExportTx, 0)
+				}
+			}
+			if err := w.Write(block, txs); err != nil {
+				return count, err
+			}
+			count++
+		}
+		log.Infof("exported %d blocks, height: %d", count, end)
+	}
+	return count, nil
+}
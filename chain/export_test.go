@@ -0,0 +1,72 @@
+package chain
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlockChain_Export(t *testing.T) {
+	bc, clean := newTestChain(t)
+	defer clean()
+	dir, err := ioutil.TempDir("", "lemo-export")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	blocks := makeBlocks(150)
+	assert.NoError(t, bc.InsertBlocks(blocks))
+
+	w, err := CreateBlockFile(filepath.Join(dir, "blocks.rlp.gz"))
+	assert.NoError(t, err)
+	_, err = bc.Export(w, 0, 151, false)
+	assert.Equal(t, ErrExportRange, err)
+	count, err := bc.Export(w, 0, 150, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 151, count)
+	assert.NoError(t, w.Close())
+	_, err = CreateBlockFile(filepath.Join(dir, "blocks.rlp.gz"))
+	assert.True(t, os.IsExist(err))
+
+	// the exported blocks with tx rows can be imported by another node
+	w, err = CreateBlockFile(filepath.Join(dir, "txs.rlp.gz"))
+	assert.NoError(t, err)
+	_, err = bc.Export(w, 0, 150, true)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	for _, name := range []string{"blocks.rlp.gz", "txs.rlp.gz"} {
+		other, cleanOther := newTestChain(t)
+		src, err := OpenBlockSource(filepath.Join(dir, name), 0)
+		assert.NoError(t, err)
+		count, err = other.Import(src)
+		src.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, 151, count)
+		assert.Equal(t, blocks[150].Hash(), other.StableBlock().Hash())
+		cleanOther()
+	}
+
+	// json lines
+	w, err = CreateBlockFile(filepath.Join(dir, "txs.jsonl"))
+	assert.NoError(t, err)
+	count, err = bc.Export(w, 10, 20, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 11, count)
+	assert.NoError(t, w.Close())
+	file, err := os.Open(filepath.Join(dir, "txs.jsonl"))
+	assert.NoError(t, err)
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	lines := 0
+	for ; scanner.Scan(); lines++ {
+		var record exportRecord
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		assert.Equal(t, blocks[10+lines].Hash(), record.Block.Hash())
+		assert.Equal(t, benchTxCount, len(record.Txs))
+		assert.Equal(t, blocks[10+lines].Txs[0].Hash(), record.Txs[0].Hash)
+	}
+	assert.Equal(t, 11, lines)
+}
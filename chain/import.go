@@ -0,0 +1,254 @@
+package chain
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common/log"
+	"github.com/LemoFoundationLtd/lemochain-core/common/rlp"
+	"github.com/LemoFoundationLtd/lemochain-core/store"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const importBatchSize = 128 // blocks inserted by InsertBlocks at once
+
+var (
+	ErrImportNotLinked = errors.New("imported block is not the child of previous block")
+	ErrImportGap       = errors.New("imported blocks are not continuous")
+	ErrNoCoreStable    = errors.New("no stable block in core data directory")
+)
+
+// BlockSource provide the blocks to import in height order
+type BlockSource interface {
+	// Next return the next block, or io.EOF if there is no more block
+	Next() (*types.Block, error)
+	Close() error
+}
+
+// OpenBlockSource open a lemochain-core data directory, or a file of rlp encoded blocks. The file is gzipped if its name
+// ends with ".gz". The blocks in data directory are read from height from
+func OpenBlockSource(path string, from uint32) (BlockSource, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return openCoreSource(path, from)
+	}
+	return openFileSource(path)
+}
+
+// fileSource read the blocks from a rlp stream
+type fileSource struct {
+	file   *os.File
+	gz     *gzip.Reader
+	stream *rlp.Stream
+}
+
+func openFileSource(path string) (*fileSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	src := &fileSource{file: file}
+	var reader io.Reader = bufio.NewReader(file)
+	if strings.HasSuffix(path, ".gz") {
+		if src.gz, err = gzip.NewReader(reader); err != nil {
+			file.Close()
+			return nil, err
+		}
+		reader = bufio.NewReader(&eofReader{src.gz})
+	}
+	src.stream = rlp.NewStream(reader, 0)
+	return src, nil
+}
+
+// eofReader delay io.EOF to the next Read if some bytes are read with it. rlp.Stream takes the io.EOF returned with the
+// last bytes of a big item as unexpected EOF, and gzip.Reader returns them together
+type eofReader struct {
+	r io.Reader
+}
+
+func (r *eofReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Next read a block, or a [block, txs] list written by export command with tx rows
+func (src *fileSource) Next() (*types.Block, error) {
+	raw, err := src.stream.Raw()
+	if err != nil {
+		return nil, err
+	}
+	isRecord, err := isExportRecord(raw)
+	if err != nil {
+		return nil, err
+	}
+	if isRecord {
+		var record exportRecord
+		if err := rlp.DecodeBytes(raw, &record); err != nil {
+			return nil, err
+		}
+		return record.Block, nil
+	}
+	var block types.Block
+	if err := rlp.DecodeBytes(raw, &block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// isExportRecord test if raw is a [block, txs] list. The first item of block is the header list, but the first item of
+// header is a hash
+func isExportRecord(raw []byte) (bool, error) {
+	content, _, err := rlp.SplitList(raw)
+	if err != nil {
+		return false, err
+	}
+	first, _, err := rlp.SplitList(content)
+	if err != nil {
+		return false, err
+	}
+	kind, _, _, err := rlp.Split(first)
+	if err != nil {
+		return false, err
+	}
+	return kind == rlp.List, nil
+}
+
+func (src *fileSource) Close() error {
+	if src.gz != nil {
+		src.gz.Close()
+	}
+	return src.file.Close()
+}
+
+// coreSource read the stable blocks from the chain data of a stopped lemochain-core node
+type coreSource struct {
+	db   *store.ChainDatabase
+	next uint32
+	last uint32
+}
+
+func openCoreSource(dataDir string, from uint32) (*coreSource, error) {
+	// accept the data directory of core node, or the chaindata directory in it
+	chainDataDir := filepath.Join(dataDir, "chaindata")
+	if _, err := os.Stat(chainDataDir); err != nil {
+		chainDataDir = dataDir
+	}
+	if _, err := os.Stat(filepath.Join(chainDataDir, "index")); err != nil {
+		return nil, err
+	}
+
+	db := store.NewChainDataBase(chainDataDir)
+	stable, err := db.GetStableBlock()
+	if err == store.ErrStableBlockNotExist {
+		db.Close()
+		return nil, ErrNoCoreStable
+	} else if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &coreSource{db: db, next: from, last: stable.Height()}, nil
+}
+
+func (src *coreSource) Next() (*types.Block, error) {
+	if src.next > src.last {
+		return nil, io.EOF
+	}
+	block, err := src.db.GetBlockByHeight(src.next)
+	if err != nil {
+		return nil, err
+	}
+	src.next++
+	return block, nil
+}
+
+func (src *coreSource) Close() error {
+	return src.db.Close()
+}
+
+// Import insert the blocks from src after the stable block. The lower blocks in src are skipped, so that the import can
+// resume from the last imported block. It returns the count of inserted blocks
+func (bc *BlockChain) Import(src BlockSource) (int, error) {
+	batchCh := make(chan []*types.Block, 2)
+	errCh := make(chan error, 1)
+	quit := make(chan struct{})
+	defer close(quit)
+	// read and check the blocks while the previous batch is inserting
+	go func() {
+		defer close(batchCh)
+		errCh <- bc.readImportBlocks(src, batchCh, quit)
+	}()
+
+	count := 0
+	for batch := range batchCh {
+		if err := bc.InsertBlocks(batch); err != nil {
+			return count, err
+		}
+		count += len(batch)
+		log.Infof("imported %d blocks, height: %d", count, batch[len(batch)-1].Height())
+	}
+	return count, <-errCh
+}
+
+// readImportBlocks send the blocks after the stable block to batchCh. The blocks must be linked one by one
+func (bc *BlockChain) readImportBlocks(src BlockSource, batchCh chan<- []*types.Block, quit <-chan struct{}) error {
+	parent := bc.StableBlock()
+	next := uint32(0)
+	if parent != nil {
+		next = parent.Height() + 1
+	}
+	batch := make([]*types.Block, 0, importBatchSize)
+	send := func() bool {
+		select {
+		case batchCh <- batch:
+			batch = make([]*types.Block, 0, importBatchSize)
+			return true
+		case <-quit:
+			return false
+		}
+	}
+	for {
+		block, err := src.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if block.Height() < next {
+			continue
+		}
+		if block.Height() != next {
+			log.Errorf("expect block %d, but got block %d", next, block.Height())
+			err = ErrImportGap
+		} else if parent != nil && block.ParentHash() != parent.Hash() {
+			log.Errorf("block %d is not linked to its parent", block.Height())
+			err = ErrImportNotLinked
+		}
+		if err != nil {
+			// the checked blocks are still imported
+			if len(batch) > 0 {
+				send()
+			}
+			return err
+		}
+		batch = append(batch, block)
+		parent = block
+		next++
+		if len(batch) >= importBatchSize && !send() {
+			return nil
+		}
+	}
+	if len(batch) > 0 {
+		send()
+	}
+	return nil
+}
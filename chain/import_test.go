@@ -0,0 +1,158 @@
+package chain
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common/rlp"
+	"github.com/LemoFoundationLtd/lemochain-core/store"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeBlockFile(t *testing.T, path string, blocks []*types.Block) {
+	file, err := os.Create(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	var writer io.Writer = file
+	if strings.HasSuffix(path, ".gz") {
+		gz := gzip.NewWriter(file)
+		defer gz.Close()
+		writer = gz
+	}
+	for _, block := range blocks {
+		assert.NoError(t, rlp.Encode(writer, block))
+	}
+}
+
+// writeStoredGzip write data as a gzip file of stored deflate blocks. The last block is marked final like the files of
+// gzip command, so gzip.Reader returns the last bytes together with io.EOF. gzip.Writer ends with an empty final block
+func writeStoredGzip(t *testing.T, path string, data []byte) {
+	var buf, trailer bytes.Buffer
+	buf.Write([]byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 0xff})
+	binary.Write(&trailer, binary.LittleEndian, crc32.ChecksumIEEE(data))
+	binary.Write(&trailer, binary.LittleEndian, uint32(len(data)))
+	for len(data) > 0 {
+		size := len(data)
+		if size > 0xffff {
+			size = 0xffff
+		}
+		final := byte(0)
+		if size == len(data) {
+			final = 1
+		}
+		buf.WriteByte(final)
+		binary.Write(&buf, binary.LittleEndian, uint16(size))
+		binary.Write(&buf, binary.LittleEndian, ^uint16(size))
+		buf.Write(data[:size])
+		data = data[size:]
+	}
+	buf.Write(trailer.Bytes())
+	assert.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0644))
+}
+
+func TestBlockChain_Import(t *testing.T) {
+	bc, clean := newTestChain(t)
+	defer clean()
+	dir, err := ioutil.TempDir("", "lemo-import")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	blocks := makeBlocks(300)
+
+	// import a part of the blocks
+	path := filepath.Join(dir, "blocks.rlp.gz")
+	writeBlockFile(t, path, blocks[:200])
+	src, err := OpenBlockSource(path, 0)
+	assert.NoError(t, err)
+	count, err := bc.Import(src)
+	src.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, 200, count)
+	assert.Equal(t, blocks[199].Hash(), bc.StableBlock().Hash())
+
+	// resume from the stable block
+	path = filepath.Join(dir, "blocks.rlp")
+	writeBlockFile(t, path, blocks)
+	src, err = OpenBlockSource(path, 0)
+	assert.NoError(t, err)
+	count, err = bc.Import(src)
+	src.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, 101, count)
+	assert.Equal(t, blocks[300].Hash(), bc.StableBlock().Hash())
+	assert.Equal(t, blocks[150].Hash(), bc.GetBlockByHeight(150).Hash())
+
+	// the blocks of another chain are not linked
+	others := makeBlocks(310)
+	others[301].Header.Extra = "fork"
+	writeBlockFile(t, path, others)
+	src, err = OpenBlockSource(path, 0)
+	assert.NoError(t, err)
+	count, err = bc.Import(src)
+	src.Close()
+	assert.Equal(t, ErrImportNotLinked, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, others[301].Hash(), bc.StableBlock().Hash())
+}
+
+func TestBlockChain_ImportGzipBigBlock(t *testing.T) {
+	bc, clean := newTestChain(t)
+	defer clean()
+	dir, err := ioutil.TempDir("", "lemo-import")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	blocks := makeBlocks(3)
+
+	var data []byte
+	for _, block := range blocks {
+		raw, err := rlp.EncodeToBytes(block)
+		assert.NoError(t, err)
+		data = append(data, raw...)
+	}
+	// the last block is bigger than the buffer of rlp.Stream
+	last, _ := rlp.EncodeToBytes(blocks[3])
+	assert.True(t, len(last) > 4096)
+	path := filepath.Join(dir, "blocks.rlp.gz")
+	writeStoredGzip(t, path, data)
+
+	src, err := OpenBlockSource(path, 0)
+	assert.NoError(t, err)
+	defer src.Close()
+	count, err := bc.Import(src)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+	assert.Equal(t, blocks[3].Hash(), bc.StableBlock().Hash())
+}
+
+func TestBlockChain_ImportCore(t *testing.T) {
+	bc, clean := newTestChain(t)
+	defer clean()
+	dir, err := ioutil.TempDir("", "lemo-import")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a stopped core node
+	blocks := makeBlocks(20)
+	coreDB := store.NewChainDataBase(filepath.Join(dir, "chaindata"))
+	for _, block := range blocks {
+		assert.NoError(t, coreDB.SetBlock(block.Hash(), block))
+		_, err := coreDB.SetStableBlock(block.Hash())
+		assert.NoError(t, err)
+	}
+	coreDB.Close()
+
+	src, err := OpenBlockSource(dir, 0)
+	assert.NoError(t, err)
+	defer src.Close()
+	count, err := bc.Import(src)
+	assert.NoError(t, err)
+	assert.Equal(t, 21, count)
+	assert.Equal(t, blocks[20].Hash(), bc.StableBlock().Hash())
+}
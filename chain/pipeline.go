@@ -0,0 +1,212 @@
+package chain
+
+import (
+	"github.com/LemoFoundationLtd/lemochain-core/chain/params"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-core/common/log"
+	"github.com/LemoFoundationLtd/lemochain-core/common/rlp"
+	"github.com/LemoFoundationLtd/lemochain-distribution/database"
+	"runtime"
+	"time"
+)
+
+// preparedBlock is the data of block which doesn't depend on database. It can be computed while the parent block is
+// committing. The nil fields are computed again when saving
+type preparedBlock struct {
+	block   *types.Block
+	hash    common.Hash
+	encoded []byte                            // rlp of block
+	txRaws  map[common.Hash][]byte            // rlp of the txs and the sub txs in boxes
+	boxes   map[common.Hash]*types.Box        // decoded box txs
+	txs     []*preparedTx                     // the tx rows in saving order
+	issues  map[common.Hash]*types.IssueAsset // decoded issue asset txs in block
+	events  []*database.Event                 // the events logged by contracts
+}
+
+// preparedTx is a tx row to save. The asset of issue and transfer txs can only be found in database when saving
+type preparedTx struct {
+	row      *database.Tx
+	issue    *types.IssueAsset    // the row of issue tx lacks the asset id, which depends on the asset category
+	transfer *types.TransferAsset // the row of transfer tx lacks the asset code, which is the code of the token
+	err      error                // the tx data can't be decoded
+}
+
+// prepareBlock hash and encode the block and its txs, and build the rows of txs and events
+func prepareBlock(block *types.Block) *preparedBlock {
+	prepared := &preparedBlock{
+		block:  block,
+		hash:   block.Hash(),
+		txRaws: make(map[common.Hash][]byte),
+		boxes:  make(map[common.Hash]*types.Box),
+		issues: make(map[common.Hash]*types.IssueAsset),
+	}
+	if encoded, err := rlp.EncodeToBytes(block); err == nil {
+		prepared.encoded = encoded
+	}
+	for _, tx := range block.Txs {
+		prepared.prepareTx(tx)
+		if tx.Type() == params.IssueAssetTx {
+			if issue, err := types.GetIssueAsset(tx.Data()); err == nil {
+				prepared.issues[tx.Hash()] = issue
+			}
+		}
+		if tx.Type() != params.BoxTx {
+			continue
+		}
+		box, err := types.GetBox(tx.Data())
+		if err != nil {
+			// the error is returned when saving
+			continue
+		}
+		prepared.boxes[tx.Hash()] = box
+		for _, subTx := range box.SubTxList {
+			prepared.prepareTx(subTx)
+		}
+	}
+	for _, tx := range block.Txs {
+		prepared.prepareRows(tx)
+	}
+	prepared.events = database.NewBlockEvents(prepared.hash, block)
+	return prepared
+}
+
+func (prepared *preparedBlock) prepareTx(tx *types.Transaction) {
+	// the hash is cached in tx
+	hash := tx.Hash()
+	if raw, err := rlp.EncodeToBytes(tx); err == nil {
+		prepared.txRaws[hash] = raw
+	}
+}
+
+// prepareRows build the rows of tx. The box tx is saved after its sub txs
+func (prepared *preparedBlock) prepareRows(tx *types.Transaction) {
+	if prepared.prepareAssetRow(common.Hash{}, tx) {
+		return
+	}
+	if tx.Type() == params.BoxTx {
+		box, ok := prepared.boxes[tx.Hash()]
+		if !ok {
+			_, err := types.GetBox(tx.Data())
+			prepared.txs = append(prepared.txs, &preparedTx{err: err})
+			return
+		}
+		for _, subTx := range box.SubTxList {
+			if !prepared.prepareAssetRow(tx.Hash(), subTx) {
+				prepared.addRow(prepared.sealDbTx(tx.Hash(), common.Hash{}, common.Hash{}, tx))
+			}
+		}
+	}
+	prepared.addRow(prepared.sealDbTx(common.Hash{}, common.Hash{}, common.Hash{}, tx))
+}
+
+// prepareAssetRow build the row of asset tx. It returns false if the tx is not an asset tx
+// 对于参数PHash,如果是BoxTx中的子交易，则PHash为BoxTx的hash,除此之外PHash == common.Hash{}
+func (prepared *preparedBlock) prepareAssetRow(PHash common.Hash, tx *types.Transaction) bool {
+	switch tx.Type() {
+	case params.CreateAssetTx:
+		prepared.addRow(prepared.sealDbTx(PHash, tx.Hash(), common.Hash{}, tx))
+	case params.IssueAssetTx:
+		issue, err := types.GetIssueAsset(tx.Data())
+		if err != nil {
+			prepared.txs = append(prepared.txs, &preparedTx{err: err})
+			break
+		}
+		prepared.txs = append(prepared.txs, &preparedTx{row: prepared.sealDbTx(PHash, issue.AssetCode, common.Hash{}, tx), issue: issue})
+	case params.ReplenishAssetTx:
+		repl, err := types.GetReplenishAsset(tx.Data())
+		if err != nil {
+			prepared.txs = append(prepared.txs, &preparedTx{err: err})
+			break
+		}
+		prepared.addRow(prepared.sealDbTx(PHash, repl.AssetCode, repl.AssetId, tx))
+	case params.ModifyAssetTx:
+		modifyInfo, err := types.GetModifyAssetInfo(tx.Data())
+		if err != nil {
+			prepared.txs = append(prepared.txs, &preparedTx{err: err})
+			break
+		}
+		prepared.addRow(prepared.sealDbTx(PHash, modifyInfo.AssetCode, common.Hash{}, tx))
+	case params.TransferAssetTx:
+		transfer, err := types.GetTransferAsset(tx.Data())
+		if err != nil {
+			log.Errorf("Unmarshal transfer asset data err: %s", err)
+			prepared.txs = append(prepared.txs, &preparedTx{err: err})
+			break
+		}
+		prepared.txs = append(prepared.txs, &preparedTx{row: prepared.sealDbTx(PHash, common.Hash{}, transfer.AssetId, tx), transfer: transfer})
+	default:
+		return false
+	}
+	return true
+}
+
+func (prepared *preparedBlock) addRow(row *database.Tx) {
+	prepared.txs = append(prepared.txs, &preparedTx{row: row})
+}
+
+// sealDbTx 组装需要存储到db中的Tx
+func (prepared *preparedBlock) sealDbTx(PHash, assetCode, assetId common.Hash, tx *types.Transaction) *database.Tx {
+	to := common.Address{}
+	if tx.To() != nil {
+		to = *tx.To()
+	}
+	return &database.Tx{
+		BHash:       prepared.hash,
+		Height:      prepared.block.Height(),
+		PHash:       PHash,
+		THash:       tx.Hash(),
+		From:        tx.From(),
+		To:          to,
+		Tx:          tx,
+		Flag:        int(tx.Type()),
+		St:          time.Now().UnixNano() / 1000000,
+		PackageTime: prepared.block.Time(),
+		AssetCode:   assetCode,
+		AssetId:     assetId,
+		Raw:         prepared.txRaws[tx.Hash()],
+	}
+}
+
+// InsertBlocks insert the continuous blocks. The blocks are prepared concurrently before their parents are committed,
+// but they are committed strictly in height order. It stops at the first error
+func (bc *BlockChain) InsertBlocks(blocks []*types.Block) error {
+	return bc.insertBlocks(blocks, runtime.NumCPU())
+}
+
+// insertBlocks insert the blocks with at most ahead blocks prepared before committing
+func (bc *BlockChain) insertBlocks(blocks []*types.Block, ahead int) error {
+	if ahead < 1 {
+		ahead = 1
+	}
+	futures := make([]chan *preparedBlock, len(blocks))
+	for i := range futures {
+		futures[i] = make(chan *preparedBlock, 1)
+	}
+	// slots limit the count of the prepared blocks which are waiting for commit
+	slots := make(chan struct{}, ahead)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		for i, block := range blocks {
+			select {
+			case slots <- struct{}{}:
+			case <-quit:
+				return
+			}
+			go func(i int, block *types.Block) {
+				futures[i] <- prepareBlock(block)
+			}(i, block)
+		}
+	}()
+
+	for i := range blocks {
+		prepared := <-futures[i]
+		<-slots
+		if err := bc.insertPrepared(prepared); err != nil {
+			log.Errorf("insert block [%d] failed: %v", prepared.block.Height(), err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package chain
+
+import (
+	"github.com/LemoFoundationLtd/lemochain-core/chain/params"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func BenchmarkInsertBlock(b *testing.B) {
+	bc, clean := newTestChain(b)
+	defer clean()
+	blocks := makeBlocks(b.N)
+	assert.NoError(b, bc.InsertBlock(blocks[0]))
+
+	b.ResetTimer()
+	for _, block := range blocks[1:] {
+		if err := bc.InsertBlock(block); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInsertBlocks(b *testing.B) {
+	bc, clean := newTestChain(b)
+	defer clean()
+	blocks := makeBlocks(b.N)
+	assert.NoError(b, bc.InsertBlock(blocks[0]))
+
+	b.ResetTimer()
+	if err := bc.InsertBlocks(blocks[1:]); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func TestPrepareBlock(t *testing.T) {
+	from := common.BigToAddress(big.NewInt(1))
+	to := common.BigToAddress(big.NewInt(2))
+	assetCode := common.HexToHash("0x0100")
+	assetId := common.HexToHash("0x0200")
+	newTx := func(txType uint16, data string) *types.Transaction {
+		return types.NewTransaction(from, to, big.NewInt(1), 21000, big.NewInt(1), []byte(data), txType, 1, 1600000000, "", "")
+	}
+	ordinary := newTx(params.OrdinaryTx, "")
+	create := newTx(params.CreateAssetTx, "")
+	issue := newTx(params.IssueAssetTx, `{"assetCode":"`+assetCode.Hex()+`","metaData":"","supplyAmount":"1"}`)
+	transfer := newTx(params.TransferAssetTx, `{"assetId":"`+assetId.Hex()+`","transferAmount":"1"}`)
+	badIssue := newTx(params.IssueAssetTx, "bad")
+	block := types.NewBlock(&types.Header{Height: 1, Time: 1600000000}, []*types.Transaction{ordinary, create, issue, transfer, badIssue}, nil)
+
+	prepared := prepareBlock(block)
+	assert.Equal(t, block.Hash(), prepared.hash)
+	assert.Equal(t, 5, len(prepared.txs))
+	for i, tx := range prepared.txs[:4] {
+		assert.NoError(t, tx.err)
+		assert.Equal(t, block.Txs[i].Hash(), tx.row.THash)
+		assert.Equal(t, block.Hash(), tx.row.BHash)
+		assert.Equal(t, prepared.txRaws[tx.row.THash], tx.row.Raw)
+		assert.NotEmpty(t, tx.row.Raw)
+	}
+	assert.Equal(t, create.Hash(), prepared.txs[1].row.AssetCode)
+	// the asset fields which depend on database are filled when saving
+	assert.Equal(t, assetCode, prepared.txs[2].issue.AssetCode)
+	assert.Equal(t, assetCode, prepared.txs[2].row.AssetCode)
+	assert.Equal(t, common.Hash{}, prepared.txs[2].row.AssetId)
+	assert.Equal(t, assetCode, prepared.issues[issue.Hash()].AssetCode)
+	assert.Equal(t, assetId, prepared.txs[3].transfer.AssetId)
+	assert.Equal(t, assetId, prepared.txs[3].row.AssetId)
+	assert.Error(t, prepared.txs[4].err)
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/LemoFoundationLtd/lemochain-core/common"
 	"github.com/LemoFoundationLtd/lemochain-core/common/log"
 	"github.com/LemoFoundationLtd/lemochain-distribution/database"
+	"github.com/LemoFoundationLtd/lemochain-distribution/metrics"
 	"time"
 )
 
@@ -16,13 +17,23 @@ type ReBuildEngine struct {
 	Store                database.DBEngine
 	Block                *types.Block
 	ReBuildAccountsCache map[common.Address]*ReBuildAccount
+	Event                *BlockEvent // the changes to notify after the block is saved
+
+	prepared   *preparedBlock
+	leaseOwner string // the writer lease which must be held in the sql transaction. Empty means no lease
 }
 
 func NewReBuildEngine(store database.DBEngine, block *types.Block) *ReBuildEngine {
+	return newReBuildEngine(store, prepareBlock(block))
+}
+
+func newReBuildEngine(store database.DBEngine, prepared *preparedBlock) *ReBuildEngine {
 	return &ReBuildEngine{
 		Store:                store,
-		Block:                block,
+		Block:                prepared.block,
 		ReBuildAccountsCache: make(map[common.Address]*ReBuildAccount),
+		Event:                &BlockEvent{Block: prepared.block},
+		prepared:             prepared,
 	}
 }
 
@@ -51,7 +62,36 @@ func (engine *ReBuildEngine) Close() {
 	//
 }
 
+// ReBuild apply the block to database in a single sql transaction, so that a block is saved all or nothing
 func (engine *ReBuildEngine) ReBuild() error {
+	start := time.Now()
+	store := engine.Store
+	txEngine, err := database.BeginTx(store)
+	if err != nil {
+		return err
+	}
+	engine.Store = txEngine
+	defer func() {
+		engine.Store = store
+		if err := txEngine.Rollback(); err != nil { // do nothing if it has been committed
+			log.Errorf("rollback block[%d] err: %v", engine.Block.Height(), err)
+		}
+	}()
+
+	if err := checkLease(txEngine, engine.leaseOwner); err != nil {
+		return err
+	}
+	if err := engine.reBuild(); err != nil {
+		return err
+	}
+	if err := txEngine.Commit(); err != nil {
+		return err
+	}
+	metrics.ReBuildDuration.Observe(time.Since(start).Seconds())
+	return nil
+}
+
+func (engine *ReBuildEngine) reBuild() error {
 	logs := engine.Block.ChangeLogs
 	if len(logs) > 0 {
 		for _, cl := range logs {
@@ -80,7 +120,12 @@ func (engine *ReBuildEngine) Save() error {
 		return err
 	}
 
-	err = engine.saveTxBatch(engine.Block.Txs)
+	err = engine.saveTxBatch()
+	if err != nil {
+		return err
+	}
+
+	err = engine.saveEvents()
 	if err != nil {
 		return err
 	}
@@ -88,14 +133,35 @@ func (engine *ReBuildEngine) Save() error {
 	return engine.saveCurrentBlock(engine.Block)
 }
 
+// undoDao record the previous value of the rows which will be overwritten by the block
+func (engine *ReBuildEngine) undoDao() *database.UndoDao {
+	return database.NewUndoDao(engine.Store)
+}
+
 func (engine *ReBuildEngine) saveCurrentBlock(block *types.Block) error {
+	if err := engine.undoDao().RecordContext(block.Height(), database.ContextKeyCurrentBlock); err != nil {
+		return err
+	}
+
 	contextDao := database.NewContextDao(engine.Store)
 	return contextDao.SetCurrentBlock(block)
 }
 
 func (engine *ReBuildEngine) saveBlock(block *types.Block) error {
+	undoDao := engine.undoDao()
+	if err := undoDao.RecordBlock(block.Height()); err != nil {
+		return err
+	}
+	hash := engine.prepared.hash
+	if err := undoDao.RecordKv(block.Height(), database.GetBlockHashKey(hash)); err != nil {
+		return err
+	}
+
 	blockDao := database.NewBlockDao(engine.Store)
-	return blockDao.SetBlock(block.Hash(), block)
+	if engine.prepared.encoded != nil {
+		return blockDao.SetEncodedBlock(hash, block, engine.prepared.encoded)
+	}
+	return blockDao.SetBlock(hash, block)
 }
 
 func (engine *ReBuildEngine) saveAccountBatch(reBuildAccounts map[common.Address]*ReBuildAccount) error {
@@ -111,188 +177,96 @@ func (engine *ReBuildEngine) saveAccountBatch(reBuildAccounts map[common.Address
 }
 
 func (engine *ReBuildEngine) saveAccount(account *types.AccountData) error {
+	if err := engine.undoDao().RecordKv(engine.Block.Height(), database.GetAddressKey(account.Address)); err != nil {
+		return err
+	}
+
 	accountDao := database.NewAccountDao(engine.Store)
 	return accountDao.Set(account.Address, account)
 }
 
-func (engine *ReBuildEngine) saveTxBatch(txes []*types.Transaction) error {
-	for index := 0; index < len(txes); index++ {
-		err := engine.saveTx(txes[index])
-		if err != nil {
+// saveTxBatch save the tx rows which are built by prepareBlock. The asset fields which depend on database are filled here
+func (engine *ReBuildEngine) saveTxBatch() error {
+	for _, tx := range engine.prepared.txs {
+		if tx.err != nil {
+			return tx.err
+		}
+		if err := engine.fillAsset(tx); err != nil {
 			return err
 		}
+		engine.Event.Txs = append(engine.Event.Txs, tx.row)
 	}
 
-	return nil
-}
-
-// sealDbTx 组装需要存储到db中的Tx
-func (engine *ReBuildEngine) sealDbTx(PHash, assetCode, assetId common.Hash, tx *types.Transaction) *database.Tx {
-	to := common.Address{}
-	if tx.To() != nil {
-		to = *tx.To()
-	}
-	return &database.Tx{
-		BHash:       engine.Block.Hash(),
-		Height:      engine.Block.Height(),
-		PHash:       PHash,
-		THash:       tx.Hash(),
-		From:        tx.From(),
-		To:          to,
-		Tx:          tx,
-		Flag:        int(tx.Type()),
-		St:          time.Now().UnixNano() / 1000000,
-		PackageTime: engine.Block.Time(),
-		AssetCode:   assetCode,
-		AssetId:     assetId,
+	dbTxes := engine.Event.Txs
+	hashes := make([]common.Hash, len(dbTxes))
+	for i, tx := range dbTxes {
+		hashes[i] = tx.THash
 	}
+	if err := engine.undoDao().RecordTxs(engine.Block.Height(), hashes); err != nil {
+		return err
+	}
+	return database.NewTxDao(engine.Store).SetBatch(dbTxes)
 }
 
-// filterSaveAssetTx 过滤出资产交易并保存资产类型的交易到db,如果是资产类型的交易返回true
-// 对于参数PHash,如果过滤的是BoxTx中的子交易，则PHash为BoxTx的hash,除此之外PHash == common.Hash{}
-func (engine *ReBuildEngine) filterSaveAssetTx(PHash common.Hash, tx *types.Transaction, txDao *database.TxDao) (error, bool) {
-	switch tx.Type() {
-
-	case params.CreateAssetTx:
-		assetCode := tx.Hash()
-		dbTx := engine.sealDbTx(PHash, assetCode, common.Hash{}, tx)
-		return txDao.Set(dbTx), true
-
-	case params.IssueAssetTx:
-		// 1. 获取资产交易中的assetCode和assetId
-		issueAsset, err := types.GetIssueAsset(tx.Data())
-		if err != nil {
-			return err, true
-		}
-		assetCode := issueAsset.AssetCode
-		assetDao := database.NewAssetDao(engine.Store)
-		asset, err := assetDao.Get(assetCode)
+// fillAsset fill the asset id of issue tx and the asset code of transfer tx from database
+func (engine *ReBuildEngine) fillAsset(tx *preparedTx) error {
+	if tx.issue != nil {
+		asset, err := database.NewAssetDao(engine.Store).Get(tx.issue.AssetCode)
 		if err != nil {
-			return err, true
+			return err
 		}
 		assetType := asset.Category
-		var assetId common.Hash
 		if assetType == types.TokenAsset {
-			assetId = assetCode
+			tx.row.AssetId = tx.issue.AssetCode
 		} else if assetType == types.NonFungibleAsset || assetType == types.CommonAsset { // ERC721 or ERC721+20
-			assetId = tx.Hash()
+			tx.row.AssetId = tx.row.THash
 		} else {
 			log.Errorf("Asset's Category not exist, Category = %d ", assetType)
-			return transaction.ErrAssetCategory, true
-		}
-		// 2. 保存交易进数据库
-		dbTx := engine.sealDbTx(PHash, assetCode, assetId, tx)
-		return txDao.Set(dbTx), true
-
-	case params.ReplenishAssetTx:
-		// 1. 获取资产交易中的assetCode和assetId
-		repl, err := types.GetReplenishAsset(tx.Data())
-		if err != nil {
-			return err, true
-		}
-		// 2. 保存交易进数据库
-		dbTx := engine.sealDbTx(PHash, repl.AssetCode, repl.AssetId, tx)
-		return txDao.Set(dbTx), true
-
-	case params.ModifyAssetTx:
-		// 1. 获取资产交易中的assetCode
-		modifyInfo, err := types.GetModifyAssetInfo(tx.Data())
-		if err != nil {
-			return err, true
-		}
-		assetCode := modifyInfo.AssetCode
-		// 2. 保存交易进数据库
-		dbTx := engine.sealDbTx(PHash, assetCode, common.Hash{}, tx)
-		return txDao.Set(dbTx), true
-
-	case params.TransferAssetTx:
-		// 1. 获取资产交易中的assetId
-		tradingAsset, err := types.GetTransferAsset(tx.Data())
-		if err != nil {
-			log.Errorf("Unmarshal transfer asset data err: %s", err)
-			return err, true
-		}
-		assetId := tradingAsset.AssetId
-		assetIdDao := database.NewAssetTokenDao(engine.Store)
-		assetIdInfo, err := assetIdDao.Get(assetId)
-		if err != nil {
-			return err, true
+			return transaction.ErrAssetCategory
 		}
-		// 2. 保存交易进数据库
-		dbTx := engine.sealDbTx(PHash, assetIdInfo.Code, assetId, tx)
-		return txDao.Set(dbTx), true
-
-	default:
-		return nil, false
-	}
-}
-
-func (engine *ReBuildEngine) filterSaveBoxTx(boxTx *types.Transaction, txDao *database.TxDao) error {
-	if boxTx.Type() != params.BoxTx {
-		return errors.New("Must be box tx type ")
 	}
-	// 1 保存箱子中的子交易
-	if box, err := types.GetBox(boxTx.Data()); err == nil {
-		for _, subTx := range box.SubTxList {
-			// 过滤资产相关的交易
-			err, isExist := engine.filterSaveAssetTx(boxTx.Hash(), subTx, txDao)
-			if err != nil {
-				return err
-			}
-			if !isExist { // 不是资产类型的交易,单独执行保存操作
-				if err := txDao.Set(engine.sealDbTx(boxTx.Hash(), common.Hash{}, common.Hash{}, boxTx)); err != nil {
-					return err
-				}
-			}
+	if tx.transfer != nil {
+		assetIdInfo, err := database.NewAssetTokenDao(engine.Store).Get(tx.transfer.AssetId)
+		if err != nil {
+			return err
 		}
-	} else {
-		return err
-	}
-	// 2 保存箱子本身
-	if err := txDao.Set(engine.sealDbTx(common.Hash{}, common.Hash{}, common.Hash{}, boxTx)); err != nil {
-		return err
+		tx.row.AssetCode = assetIdInfo.Code
 	}
 	return nil
 }
 
-func (engine *ReBuildEngine) saveTx(tx *types.Transaction) error {
-	txDao := database.NewTxDao(engine.Store)
-	// 1. 过滤资产类型的交易
-	err, isExist := engine.filterSaveAssetTx(common.Hash{}, tx, txDao)
-	if err != nil {
-		return err
-	}
-	if isExist {
+// saveEvents save the events logged by the contracts in block
+func (engine *ReBuildEngine) saveEvents() error {
+	events := engine.prepared.events
+	if len(events) == 0 {
 		return nil
 	}
-
-	// 2. 过滤箱子交易
-	if tx.Type() == params.BoxTx {
-		return engine.filterSaveBoxTx(tx, txDao)
+	if err := engine.undoDao().RecordEvents(engine.Block.Height(), len(events)); err != nil {
+		return err
 	}
-
-	// 3. 其他交易
-	return txDao.Set(engine.sealDbTx(common.Hash{}, common.Hash{}, common.Hash{}, tx))
+	return database.NewEventDao(engine.Store).SetBatch(events)
 }
 
 func (engine *ReBuildEngine) saveStorageBatch(storage map[common.Hash][]byte) error {
+	keys := make([][]byte, 0, len(storage))
+	vals := make([][]byte, 0, len(storage))
 	for k, v := range storage {
-		err := engine.saveStorage(k, v)
-		if err != nil {
-			return err
-		}
+		keys = append(keys, database.GetStorageKey(k))
+		vals = append(vals, v)
+	}
+	if err := engine.undoDao().RecordKvs(engine.Block.Height(), keys); err != nil {
+		return err
 	}
 
-	return nil
-}
-
-func (engine *ReBuildEngine) saveStorage(hash common.Hash, val []byte) error {
 	kvDao := database.NewKvDao(engine.Store)
-	return kvDao.Set(database.GetStorageKey(hash), val)
+	return kvDao.SetBatch(keys, vals)
 }
 
 func (engine *ReBuildEngine) saveAssetCodeBatch(assets map[common.Hash]*types.Asset) error {
 	for _, v := range assets {
+		if v == nil { // the asset is deleted in block, nothing to save
+			continue
+		}
 		err := engine.saveAssetCode(v)
 		if err != nil {
 			return err
@@ -302,43 +276,50 @@ func (engine *ReBuildEngine) saveAssetCodeBatch(assets map[common.Hash]*types.As
 }
 
 func (engine *ReBuildEngine) saveAssetCode(asset *types.Asset) error {
+	if err := engine.undoDao().RecordAsset(engine.Block.Height(), asset.AssetCode); err != nil {
+		return err
+	}
+
 	assetCodeDao := database.NewAssetDao(engine.Store)
 	return assetCodeDao.Set(asset)
 }
 
 func (engine *ReBuildEngine) saveAssetIdBatch(address common.Address, assetIds map[common.Hash]*types.IssueAsset) error {
+	ids := make([]common.Hash, 0, len(assetIds))
+	assetTokens := make([]*database.AssetToken, 0, len(assetIds))
 	for k, v := range assetIds {
-		err := engine.saveAssetId(address, k, v)
-		if err != nil {
-			return err
-		}
+		ids = append(ids, k)
+		assetTokens = append(assetTokens, &database.AssetToken{
+			Id:       k,
+			Code:     v.AssetCode,
+			Owner:    address,
+			MetaData: v.MetaData,
+		})
+	}
+	if err := engine.undoDao().RecordAssetTokens(engine.Block.Height(), ids); err != nil {
+		return err
 	}
-	return nil
-}
 
-func (engine *ReBuildEngine) saveAssetId(address common.Address, hash common.Hash, assetId *types.IssueAsset) error {
 	assetIdDao := database.NewAssetTokenDao(engine.Store)
-	return assetIdDao.Set(&database.AssetToken{
-		Id:       hash,
-		Code:     assetId.AssetCode,
-		Owner:    address,
-		MetaData: assetId.MetaData,
-	})
+	return assetIdDao.SetBatch(assetTokens)
 }
 
 func (engine *ReBuildEngine) saveEquitiesBatch(address common.Address, equities map[common.Hash]*types.AssetEquity) error {
+	ids := make([]common.Hash, 0, len(equities))
+	assetEquities := make([]*types.AssetEquity, 0, len(equities))
 	for _, v := range equities {
-		err := engine.saveEquity(address, v)
-		if err != nil {
-			return err
+		if v == nil { // the equity is deleted in block, nothing to save
+			continue
 		}
+		ids = append(ids, v.AssetId)
+		assetEquities = append(assetEquities, v)
+	}
+	if err := engine.undoDao().RecordEquities(engine.Block.Height(), address, ids); err != nil {
+		return err
 	}
-	return nil
-}
 
-func (engine *ReBuildEngine) saveEquity(address common.Address, equity *types.AssetEquity) error {
 	equityDao := database.NewEquityDao(engine.Store)
-	return equityDao.Set(address, equity)
+	return equityDao.SetBatch(address, assetEquities)
 }
 
 func (engine *ReBuildEngine) getAssetTokens() (map[common.Hash]*types.IssueAsset, error) {
@@ -353,8 +334,12 @@ func (engine *ReBuildEngine) getAssetTokens() (map[common.Hash]*types.IssueAsset
 				panic("tx is issue asset. but data is nil.")
 			}
 
-			issueAsset := &types.IssueAsset{}
-			err := json.Unmarshal(extendData, issueAsset)
+			issueAsset, ok := engine.prepared.issues[tx.Hash()]
+			var err error
+			if !ok {
+				issueAsset = &types.IssueAsset{}
+				err = json.Unmarshal(extendData, issueAsset)
+			}
 			if err != nil {
 				return nil, err
 			} else {
@@ -391,7 +376,9 @@ func (engine *ReBuildEngine) resolve() error {
 				AssetCodeCache[ak] = av
 			}
 
-			engine.saveAssetCodeBatch(AssetCodeCache)
+			if err := engine.saveAssetCodeBatch(AssetCodeCache); err != nil {
+				return err
+			}
 		}
 
 		if len(v.MetaDatas) > 0 {
@@ -404,7 +391,9 @@ func (engine *ReBuildEngine) resolve() error {
 				}
 			}
 
-			engine.saveAssetIdBatch(v.Address, AssetIdCache)
+			if err := engine.saveAssetIdBatch(v.Address, AssetIdCache); err != nil {
+				return err
+			}
 		}
 
 		if len(v.AssetEquities) > 0 {
@@ -413,7 +402,9 @@ func (engine *ReBuildEngine) resolve() error {
 				EquityCache[ak] = av
 			}
 
-			engine.saveEquitiesBatch(v.Address, EquityCache)
+			if err := engine.saveEquitiesBatch(v.Address, EquityCache); err != nil {
+				return err
+			}
 		}
 
 		if len(v.Storage) > 0 {
@@ -422,7 +413,9 @@ func (engine *ReBuildEngine) resolve() error {
 				StorageCache[ak] = av
 			}
 
-			engine.saveStorageBatch(StorageCache)
+			if err := engine.saveStorageBatch(StorageCache); err != nil {
+				return err
+			}
 		}
 
 		isCandidate := v.isCandidate(v.Candidate.Profile)
@@ -430,6 +423,12 @@ func (engine *ReBuildEngine) resolve() error {
 			panic("not exist at the same time.")
 		}
 
+		if isCandidate || v.IsCancelCandidate {
+			if err := engine.undoDao().RecordCandidate(engine.Block.Height(), v.Address); err != nil {
+				return err
+			}
+		}
+
 		if isCandidate {
 			candidateDao := database.NewCandidateDao(engine.Store)
 			err := candidateDao.Set(&database.CandidateItem{
@@ -439,6 +438,7 @@ func (engine *ReBuildEngine) resolve() error {
 			if err != nil {
 				return err
 			}
+			engine.Event.Candidates = append(engine.Event.Candidates, &CandidateEvent{Address: v.Address, Votes: v.Candidate.Votes})
 		}
 
 		if v.IsCancelCandidate {
@@ -447,6 +447,7 @@ func (engine *ReBuildEngine) resolve() error {
 			if err != nil {
 				return err
 			}
+			engine.Event.Candidates = append(engine.Event.Candidates, &CandidateEvent{Address: v.Address, Votes: v.Candidate.Votes, Cancelled: true})
 		}
 	}
 
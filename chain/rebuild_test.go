@@ -0,0 +1,41 @@
+package chain
+
+import (
+	"github.com/LemoFoundationLtd/lemochain-core/chain/account"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-distribution/database"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func TestReBuildEngine_SaveEvents(t *testing.T) {
+	bc, clean := newTestChain(t)
+	defer clean()
+	genesis := makeBlocks(0)[0]
+	contract := common.BigToAddress(big.NewInt(100))
+	topic := common.HexToHash("0x01")
+	block := types.NewBlock(&types.Header{ParentHash: genesis.Hash(), Height: 1}, nil, types.ChangeLogSlice{
+		{LogType: account.AddEventLog, Address: contract, Version: 1, NewVal: &types.Event{Address: contract, Topics: []common.Hash{topic}, Data: []byte{1}}},
+		{LogType: account.AddEventLog, Address: contract, Version: 2, NewVal: &types.Event{Address: contract, Data: []byte{2}}},
+	})
+	assert.NoError(t, bc.InsertBlocks([]*types.Block{genesis, block}))
+
+	eventDao := database.NewEventDao(bc.dbEngine)
+	events, err := eventDao.GetByFilter(&database.EventFilter{FromHeight: 0, ToHeight: 1, Addresses: []common.Address{contract}}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, block.Hash(), events[1].BHash)
+	assert.Equal(t, uint32(1), events[1].Index)
+	assert.Equal(t, []byte{2}, events[1].Data)
+	rows, _, err := database.NewBlockDao(bc.dbEngine).GetRowsByHeightWithTotal(1, 1, 0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rows[0].EventCount)
+
+	// the events are removed with the block
+	assert.NoError(t, bc.RevertTo(0))
+	events, err = eventDao.GetByFilter(&database.EventFilter{FromHeight: 0, ToHeight: 1}, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+}
@@ -1,21 +1,422 @@
 package chain
 
 import (
+	"errors"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/transaction"
 	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-core/common/log"
 	"github.com/LemoFoundationLtd/lemochain-core/common/subscribe"
+	"github.com/LemoFoundationLtd/lemochain-distribution/database"
 	"github.com/LemoFoundationLtd/lemochain-distribution/network"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
 )
 
-// TxPool add filter in future
+const (
+	// MaxTxPoolSize is the max count of pending txs
+	MaxTxPoolSize = 10000
+	// expireInterval is the interval to evict the expired txs
+	expireInterval = 10 * time.Second
+)
+
+// TxStatus is the state of a tx sent by RPC
+type TxStatus string
+
+const (
+	TxPending   TxStatus = "pending"   // in pool, not sent to core peer yet
+	TxForwarded TxStatus = "forwarded" // in pool, sent to core peer
+	TxIncluded  TxStatus = "included"  // in a stored block
+	TxExpired   TxStatus = "expired"   // evicted from pool because it is not packed before expiration
+	TxDropped   TxStatus = "dropped"   // evicted from pool because the account can't pay for it any more
+)
+
+var (
+	ErrTxExists            = errors.New("transaction is already in the pool")
+	ErrTxPacked            = errors.New("transaction is already in a stored block")
+	ErrTxPoolFull          = errors.New("transaction pool is full")
+	ErrInsufficientBalance = errors.New("insufficient balance to pay for transaction")
+)
+
+// TxPool holds the txs sent by RPC until they appear in a stored block or expire. The txs are sent to the core peer
+// when they are added, and all of them are sent again once a core peer is connected.
+// Lemochain has no account nonce, so the replay is prevented by the tx hash and expiration
 type TxPool struct {
-	// todo
+	chainID  uint16
+	dbEngine database.DBEngine
+
+	txs     map[common.Hash]*poolTx
+	nextSeq uint64
+	removed map[common.Hash]TxStatus // the txs evicted for expiration or dropped, at most MaxTxPoolSize
+	order   []common.Hash            // the hashes in removed, in eviction order
+	lock    sync.RWMutex
+
+	blockCh   chan *BlockEvent
+	readyCh   chan struct{}
+	forwardCh chan *types.Transaction
+	quitCh    chan struct{}
+	wg        sync.WaitGroup
+}
+
+func NewTxPool(chainID uint16, dbEngine database.DBEngine) *TxPool {
+	return &TxPool{
+		chainID:   chainID,
+		dbEngine:  dbEngine,
+		txs:       make(map[common.Hash]*poolTx),
+		removed:   make(map[common.Hash]TxStatus),
+		blockCh:   make(chan *BlockEvent),
+		readyCh:   make(chan struct{}),
+		forwardCh: make(chan *types.Transaction),
+		quitCh:    make(chan struct{}),
+	}
+}
+
+type poolTx struct {
+	tx        *types.Transaction
+	seq       uint64 // the order of adding
+	forwarded bool
+}
+
+func (tp *TxPool) Start() {
+	subscribe.Sub(subscribe.NewStableBlock, tp.blockCh)
+	subscribe.Sub(network.CorePeerReady, tp.readyCh)
+	subscribe.Sub(network.TxForwarded, tp.forwardCh)
+	tp.wg.Add(1)
+	go tp.loop()
 }
 
-func NewTxPool() *TxPool {
-	return &TxPool{}
+// Stop should unsubscribe before quit the loop, or the sender will be blocked
+func (tp *TxPool) Stop() {
+	subscribe.UnSub(subscribe.NewStableBlock, tp.blockCh)
+	subscribe.UnSub(network.CorePeerReady, tp.readyCh)
+	subscribe.UnSub(network.TxForwarded, tp.forwardCh)
+	close(tp.quitCh)
+	tp.wg.Wait()
 }
 
+// AddTx validate the tx and send it to the core peer. The tx is kept in pool even if there is no core peer now
 func (tp *TxPool) AddTx(tx *types.Transaction) error {
+	if err := tx.VerifyTxBody(tp.chainID, uint64(time.Now().Unix()), false); err != nil {
+		return err
+	}
+	if err := tp.verifySigners(tx); err != nil {
+		return err
+	}
+	hash := tx.Hash()
+	if _, err := database.NewTxDao(tp.dbEngine).Get(hash); err == nil {
+		return ErrTxPacked
+	} else if err != database.ErrNotExist {
+		return err
+	}
+
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	if _, ok := tp.txs[hash]; ok {
+		return ErrTxExists
+	}
+	if len(tp.txs) >= MaxTxPoolSize {
+		return ErrTxPoolFull
+	}
+	if err := tp.checkBalance(tx); err != nil {
+		return err
+	}
+	tp.txs[hash] = &poolTx{tx: tx, seq: tp.nextSeq}
+	tp.nextSeq++
+	delete(tp.removed, hash)
 	go subscribe.Send(network.GetNewTx, tx)
 	return nil
 }
+
+// verifySigners test if the tx is signed by the sender and the gas payer in the same way as the core node
+func (tp *TxPool) verifySigners(tx *types.Transaction) error {
+	if len(tx.GasPayerSigs()) > 0 {
+		if err := tp.checkSigners(tx, tx.GasPayer(), types.MakeGasPayerSigner()); err != nil {
+			return err
+		}
+		return tp.checkSigners(tx, tx.From(), types.MakeReimbursementTxSigner())
+	}
+	if tx.GasPayer() != tx.From() {
+		return transaction.ErrGasPayer
+	}
+	return tp.checkSigners(tx, tx.From(), types.MakeSigner())
+}
+
+// checkSigners test if the signers are the account itself, or have enough weight for a multisig account
+func (tp *TxPool) checkSigners(tx *types.Transaction, addr common.Address, signer types.Signer) error {
+	signers, err := signer.GetSigners(tx)
+	if err != nil {
+		return err
+	}
+	if len(signers) == 0 {
+		return transaction.ErrTxNotSign
+	}
+	var accountSigners types.Signers
+	account, err := database.NewAccountDao(tp.dbEngine).Get(addr)
+	if err == nil {
+		accountSigners = account.Signers
+	} else if err != database.ErrNotExist {
+		return err
+	}
+
+	if len(accountSigners) == 0 {
+		if signers[0] != addr {
+			return transaction.ErrSignerAndFromUnequally
+		}
+		return nil
+	}
+	weights := accountSigners.ToSignerMap()
+	total := 0
+	for _, s := range signers {
+		total += int(weights[s])
+	}
+	if total < transaction.SignerWeightThreshold {
+		return transaction.ErrTotalWeight
+	}
+	return nil
+}
+
+// checkBalance test if the accounts can pay for the tx and the other pending txs. The gas payer pays for gas, and the
+// sender pays for amount. Only the balances of the payers of tx are loaded
+func (tp *TxPool) checkBalance(tx *types.Transaction) error {
+	sheet := newBalanceSheet(tp.dbEngine)
+	for _, addr := range payers(tx) {
+		cost := txCost(tx, addr)
+		if cost.Sign() == 0 {
+			continue
+		}
+		for _, pending := range tp.txs {
+			cost.Add(cost, txCost(pending.tx, addr))
+		}
+		remain, err := sheet.remain(addr)
+		if err != nil {
+			return err
+		}
+		if remain.Cmp(cost) < 0 {
+			log.Warnf("insufficient balance. account: %s, balance: %s, cost: %s", addr.Hex(), remain.String(), cost.String())
+			return ErrInsufficientBalance
+		}
+	}
+	return nil
+}
+
+// balanceSheet track the balances left after paying for the txs in pool. The balances are loaded from database at the
+// first use
+type balanceSheet struct {
+	accountDao *database.AccountDao
+	remains    map[common.Address]*big.Int
+}
+
+func newBalanceSheet(dbEngine database.DBEngine) *balanceSheet {
+	return &balanceSheet{accountDao: database.NewAccountDao(dbEngine), remains: make(map[common.Address]*big.Int)}
+}
+
+// payers return the accounts which pay for tx
+func payers(tx *types.Transaction) []common.Address {
+	if tx.From() == tx.GasPayer() {
+		return []common.Address{tx.From()}
+	}
+	return []common.Address{tx.From(), tx.GasPayer()}
+}
+
+func (sheet *balanceSheet) remain(addr common.Address) (*big.Int, error) {
+	if remain, ok := sheet.remains[addr]; ok {
+		return remain, nil
+	}
+	remain := new(big.Int)
+	account, err := sheet.accountDao.Get(addr)
+	if err != nil && err != database.ErrNotExist {
+		return nil, err
+	}
+	if err == nil && account.Balance != nil {
+		remain.Set(account.Balance)
+	}
+	sheet.remains[addr] = remain
+	return remain, nil
+}
+
+// charge take the cost of tx from the balances of its payers
+func (sheet *balanceSheet) charge(tx *types.Transaction) error {
+	for _, addr := range payers(tx) {
+		remain, err := sheet.remain(addr)
+		if err != nil {
+			return err
+		}
+		remain.Sub(remain, txCost(tx, addr))
+	}
+	return nil
+}
+
+// pay take the cost of tx if all the payers can pay for it, or return ErrInsufficientBalance
+func (sheet *balanceSheet) pay(tx *types.Transaction) error {
+	for _, addr := range payers(tx) {
+		cost := txCost(tx, addr)
+		if cost.Sign() == 0 {
+			continue
+		}
+		remain, err := sheet.remain(addr)
+		if err != nil {
+			return err
+		}
+		if remain.Cmp(cost) < 0 {
+			return ErrInsufficientBalance
+		}
+	}
+	return sheet.charge(tx)
+}
+
+// txCost return the value which addr pays for tx
+func txCost(tx *types.Transaction, addr common.Address) *big.Int {
+	cost := new(big.Int)
+	if tx.GasPayer() == addr {
+		cost.Mul(tx.GasPrice(), new(big.Int).SetUint64(tx.GasLimit()))
+	}
+	if tx.From() == addr {
+		cost.Add(cost, tx.Amount())
+	}
+	return cost
+}
+
+// Get return the pending tx by hash, or nil if it is not in pool
+func (tp *TxPool) Get(hash common.Hash) *types.Transaction {
+	tp.lock.RLock()
+	defer tp.lock.RUnlock()
+	if item, ok := tp.txs[hash]; ok {
+		return item.tx
+	}
+	return nil
+}
+
+// Pending return all the txs in pool, in the order of adding
+func (tp *TxPool) Pending() []*types.Transaction {
+	tp.lock.RLock()
+	defer tp.lock.RUnlock()
+	items := make([]*poolTx, 0, len(tp.txs))
+	for _, item := range tp.txs {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].seq < items[j].seq
+	})
+	result := make([]*types.Transaction, len(items))
+	for i, item := range items {
+		result[i] = item.tx
+	}
+	return result
+}
+
+// Status return the status of tx in pool or evicted from pool. The included txs are not tracked, they are in database
+func (tp *TxPool) Status(hash common.Hash) (TxStatus, bool) {
+	tp.lock.RLock()
+	defer tp.lock.RUnlock()
+	if item, ok := tp.txs[hash]; ok {
+		if item.forwarded {
+			return TxForwarded, true
+		}
+		return TxPending, true
+	}
+	status, ok := tp.removed[hash]
+	return status, ok
+}
+
+func (tp *TxPool) loop() {
+	defer tp.wg.Done()
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case event := <-tp.blockCh:
+			tp.removeStored(event)
+		case <-tp.readyCh:
+			tp.resend()
+		case tx := <-tp.forwardCh:
+			tp.setForwarded(tx.Hash())
+		case <-ticker.C:
+			tp.removeExpired(uint64(time.Now().Unix()))
+		case <-tp.quitCh:
+			return
+		}
+	}
+}
+
+func (tp *TxPool) setForwarded(hash common.Hash) {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	if item, ok := tp.txs[hash]; ok {
+		item.forwarded = true
+	}
+}
+
+// removeStored evict the txs in the stored block, then drop the txs which the accounts can't pay for any more. The costs
+// of txs are summed up like checkBalance, in expiration order, so the txs expiring later are dropped first
+func (tp *TxPool) removeStored(event *BlockEvent) {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	for _, tx := range event.Block.Txs {
+		delete(tp.txs, tx.Hash())
+	}
+	for _, tx := range event.Txs {
+		delete(tp.txs, tx.THash)
+	}
+
+	items := make([]*poolTx, 0, len(tp.txs))
+	for _, item := range tp.txs {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].tx.Expiration() != items[j].tx.Expiration() {
+			return items[i].tx.Expiration() < items[j].tx.Expiration()
+		}
+		return items[i].seq < items[j].seq
+	})
+	sheet := newBalanceSheet(tp.dbEngine)
+	for _, item := range items {
+		err := sheet.pay(item.tx)
+		if err == ErrInsufficientBalance {
+			log.Debugf("tx %s is dropped, the balance is not enough", item.tx.Hash().Hex())
+			tp.evict(item.tx.Hash(), TxDropped)
+		} else if err != nil {
+			// keep the txs if the balance is unknown
+			log.Errorf("get balance failed: %v", err)
+			return
+		}
+	}
+}
+
+// evict remove the tx from pool and remember the reason
+func (tp *TxPool) evict(hash common.Hash, status TxStatus) {
+	delete(tp.txs, hash)
+	tp.removed[hash] = status
+	tp.order = append(tp.order, hash)
+	if len(tp.order) > MaxTxPoolSize {
+		delete(tp.removed, tp.order[0])
+		tp.order = tp.order[1:]
+	}
+}
+
+// removeExpired evict the txs which can't be packed after now
+func (tp *TxPool) removeExpired(now uint64) {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	for hash, item := range tp.txs {
+		if item.tx.Expiration() < now {
+			log.Debugf("tx %s is expired", hash.Hex())
+			tp.evict(hash, TxExpired)
+		}
+	}
+}
+
+// resend send all the pending txs to the new core peer
+func (tp *TxPool) resend() {
+	txs := tp.Pending()
+	if len(txs) == 0 {
+		return
+	}
+	log.Infof("resend %d pending txs to core peer", len(txs))
+	go func() {
+		for _, tx := range txs {
+			subscribe.Send(network.GetNewTx, tx)
+		}
+	}()
+}
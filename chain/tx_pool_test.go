@@ -0,0 +1,144 @@
+package chain
+
+import (
+	"github.com/LemoFoundationLtd/lemochain-core/chain/params"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/transaction"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-core/common/crypto"
+	"github.com/LemoFoundationLtd/lemochain-distribution/database"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestTxPool_AddTx(t *testing.T) {
+	bc, clean := newTestChain(t)
+	defer clean()
+	pool := NewTxPool(1, bc.dbEngine)
+
+	private, _ := crypto.GenerateKey()
+	from := crypto.PubkeyToAddress(private.PublicKey)
+	to := common.BigToAddress(big.NewInt(2))
+	expiration := uint64(time.Now().Unix() + 60)
+	gasPrice := params.MinGasPrice
+	newTx := func(amount int64) *types.Transaction {
+		tx := types.NewTransaction(from, to, big.NewInt(amount), 21000, gasPrice, nil, params.OrdinaryTx, 1, expiration, "", "")
+		tx, _ = types.MakeSigner().SignTx(tx, private)
+		return tx
+	}
+	gas := new(big.Int).Mul(gasPrice, big.NewInt(21000))
+
+	// unknown account
+	assert.Equal(t, ErrInsufficientBalance, pool.AddTx(newTx(1)))
+
+	account := database.NewAccountData(from)
+	account.Balance = new(big.Int).Add(new(big.Int).Mul(gas, big.NewInt(2)), big.NewInt(3))
+	assert.NoError(t, database.NewAccountDao(bc.dbEngine).Set(from, account))
+
+	tx1 := newTx(1)
+	assert.NoError(t, pool.AddTx(tx1))
+	assert.Equal(t, ErrTxExists, pool.AddTx(tx1))
+	// the pending tx costs gas+1 already
+	assert.Equal(t, ErrInsufficientBalance, pool.AddTx(newTx(3)))
+	tx2 := newTx(2)
+	assert.NoError(t, pool.AddTx(tx2))
+	assert.Equal(t, 2, len(pool.Pending()))
+
+	// not signed by sender
+	assert.Equal(t, types.ErrNoSignsData, pool.AddTx(types.NewTransaction(from, to, big.NewInt(1), 21000, gasPrice, nil, params.OrdinaryTx, 1, expiration, "", "")))
+	other, _ := crypto.GenerateKey()
+	tx5, _ := types.MakeSigner().SignTx(types.NewTransaction(from, to, big.NewInt(1), 21000, gasPrice, nil, params.OrdinaryTx, 1, expiration, "", ""), other)
+	assert.Equal(t, transaction.ErrSignerAndFromUnequally, pool.AddTx(tx5))
+
+	// wrong chain id
+	tx3 := types.NewTransaction(from, to, big.NewInt(1), 21000, gasPrice, nil, params.OrdinaryTx, 2, expiration, "", "")
+	assert.Equal(t, types.ErrTxChainID, pool.AddTx(tx3))
+
+	// the tx in database
+	tx4 := newTx(0)
+	assert.NoError(t, database.NewTxDao(bc.dbEngine).Set(&database.Tx{THash: tx4.Hash(), Tx: tx4, From: from, To: to}))
+	assert.Equal(t, ErrTxPacked, pool.AddTx(tx4))
+}
+
+func TestTxPool_Remove(t *testing.T) {
+	bc, clean := newTestChain(t)
+	defer clean()
+	pool := NewTxPool(1, bc.dbEngine)
+
+	private, _ := crypto.GenerateKey()
+	from := crypto.PubkeyToAddress(private.PublicKey)
+	account := database.NewAccountData(from)
+	account.Balance = new(big.Int).Mul(params.MinGasPrice, big.NewInt(1000000))
+	assert.NoError(t, database.NewAccountDao(bc.dbEngine).Set(from, account))
+
+	now := uint64(time.Now().Unix())
+	txs := make([]*types.Transaction, 3)
+	for i := range txs {
+		tx := types.NewTransaction(from, common.BigToAddress(big.NewInt(2)), big.NewInt(1), 21000, params.MinGasPrice, nil, params.OrdinaryTx, 1, now+uint64(i+1)*60, "", "")
+		txs[i], _ = types.MakeSigner().SignTx(tx, private)
+		assert.NoError(t, pool.AddTx(txs[i]))
+	}
+
+	assert.Equal(t, txs, pool.Pending())
+	status, _ := pool.Status(txs[0].Hash())
+	assert.Equal(t, TxPending, status)
+	pool.setForwarded(txs[0].Hash())
+	status, _ = pool.Status(txs[0].Hash())
+	assert.Equal(t, TxForwarded, status)
+
+	// evict the tx in stored block
+	block := types.NewBlock(&types.Header{Height: 1}, types.Transactions{txs[0]}, nil)
+	pool.removeStored(&BlockEvent{Block: block})
+	assert.Nil(t, pool.Get(txs[0].Hash()))
+	assert.NotNil(t, pool.Get(txs[1].Hash()))
+	_, ok := pool.Status(txs[0].Hash())
+	assert.False(t, ok)
+
+	// evict the expired tx
+	pool.removeExpired(now + 150)
+	assert.Nil(t, pool.Get(txs[1].Hash()))
+	assert.NotNil(t, pool.Get(txs[2].Hash()))
+	assert.Equal(t, 1, len(pool.Pending()))
+	status, _ = pool.Status(txs[1].Hash())
+	assert.Equal(t, TxExpired, status)
+
+	// drop the tx which can't be paid after the balance is spent
+	account.Balance = big.NewInt(1)
+	assert.NoError(t, database.NewAccountDao(bc.dbEngine).Set(from, account))
+	pool.removeStored(&BlockEvent{Block: types.NewBlock(&types.Header{Height: 2}, nil, nil)})
+	assert.Equal(t, 0, len(pool.Pending()))
+	status, _ = pool.Status(txs[2].Hash())
+	assert.Equal(t, TxDropped, status)
+}
+
+func TestTxPool_DropByBalance(t *testing.T) {
+	bc, clean := newTestChain(t)
+	defer clean()
+	pool := NewTxPool(1, bc.dbEngine)
+
+	private, _ := crypto.GenerateKey()
+	from := crypto.PubkeyToAddress(private.PublicKey)
+	account := database.NewAccountData(from)
+	account.Balance = new(big.Int).Mul(params.MinGasPrice, big.NewInt(1000000))
+	assert.NoError(t, database.NewAccountDao(bc.dbEngine).Set(from, account))
+
+	// the txs added later expire earlier
+	now := uint64(time.Now().Unix())
+	txs := make([]*types.Transaction, 3)
+	for i := range txs {
+		tx := types.NewTransaction(from, common.BigToAddress(big.NewInt(2)), big.NewInt(1), 21000, params.MinGasPrice, nil, params.OrdinaryTx, 1, now+uint64(3-i)*60, "", "")
+		txs[i], _ = types.MakeSigner().SignTx(tx, private)
+		assert.NoError(t, pool.AddTx(txs[i]))
+	}
+
+	// each tx can be paid by the balance, but not all of them
+	cost := new(big.Int).Add(new(big.Int).Mul(params.MinGasPrice, big.NewInt(21000)), big.NewInt(1))
+	account.Balance = new(big.Int).Mul(cost, big.NewInt(2))
+	assert.NoError(t, database.NewAccountDao(bc.dbEngine).Set(from, account))
+	pool.removeStored(&BlockEvent{Block: types.NewBlock(&types.Header{Height: 1}, nil, nil)})
+	assert.Equal(t, []*types.Transaction{txs[1], txs[2]}, pool.Pending())
+	status, _ := pool.Status(txs[0].Hash())
+	assert.Equal(t, TxDropped, status)
+}
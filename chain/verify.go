@@ -0,0 +1,313 @@
+package chain
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/account"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-core/common/log"
+	"github.com/LemoFoundationLtd/lemochain-core/common/rlp"
+	"github.com/LemoFoundationLtd/lemochain-core/store"
+	"github.com/LemoFoundationLtd/lemochain-core/store/trie"
+	"github.com/LemoFoundationLtd/lemochain-distribution/database"
+	"math/big"
+	"sort"
+)
+
+const verifyLogInterval = 10000 // blocks between the progress logs
+
+// the kinds of Mismatch
+const (
+	MismatchMissingBlock = "missing block"
+	MismatchBlockLink    = "block link"
+	MismatchLogRoot      = "log root"
+	MismatchVersionRoot  = "version root"
+	MismatchAccount      = "account"
+	MismatchAsset        = "asset"
+	MismatchEquity       = "equity"
+	MismatchCandidate    = "candidate"
+)
+
+// Mismatch is an inconsistency found by Verify. The Height of the mismatches in account state is the current height
+type Mismatch struct {
+	Height uint32
+	Kind   string
+	Detail string
+}
+
+func (m *Mismatch) String() string {
+	return fmt.Sprintf("height %d, %s: %s", m.Height, m.Kind, m.Detail)
+}
+
+// skippedLogs are not replayed by verifier, because their results are not verified
+var skippedLogs = map[types.ChangeLogType]bool{
+	account.StorageLog:  true,
+	account.CodeLog:     true,
+	account.AddEventLog: true,
+	account.AssetIdLog:  true,
+}
+
+// rootLogs are appended by lemochain-core after the versions are recorded, so they are not in version trie
+var rootLogs = map[types.ChangeLogType]bool{
+	account.StorageRootLog:   true,
+	account.AssetCodeRootLog: true,
+	account.AssetIdRootLog:   true,
+	account.EquityRootLog:    true,
+}
+
+// verifier replay the change logs from genesis block in memory
+type verifier struct {
+	db          database.DBEngine
+	accounts    map[common.Address]*ReBuildAccount
+	versionTrie *trie.SecureTrie
+}
+
+func newVerifier(db database.DBEngine) (*verifier, error) {
+	memDB, err := store.NewMemDatabase()
+	if err != nil {
+		return nil, err
+	}
+	versionTrie, err := trie.NewSecure(common.Hash{}, store.NewTrieDatabase(memDB), account.MaxTrieCacheGen)
+	if err != nil {
+		return nil, err
+	}
+	return &verifier{db: db, accounts: make(map[common.Address]*ReBuildAccount), versionTrie: versionTrie}, nil
+}
+
+// GetAccount implement types.ChangeLogProcessor. The accounts are kept until the end of verifying
+func (v *verifier) GetAccount(address common.Address) types.AccountAccessor {
+	acc, ok := v.accounts[address]
+	if !ok {
+		acc = NewReBuildAccount(v.db, database.NewAccountData(address))
+		v.accounts[address] = acc
+	}
+	return acc
+}
+
+// versionTrieKey is the same as the key of version trie in lemochain-core
+func versionTrieKey(address common.Address, logType types.ChangeLogType) []byte {
+	return append(address.Bytes(), big.NewInt(int64(logType)).Bytes()...)
+}
+
+// apply redo the logs of a block, and record their versions in version trie
+func (v *verifier) apply(logs types.ChangeLogSlice) error {
+	for _, cl := range logs {
+		if !rootLogs[cl.LogType] {
+			if err := v.versionTrie.TryUpdate(versionTrieKey(cl.Address, cl.LogType), big.NewInt(int64(cl.Version)).Bytes()); err != nil {
+				return err
+			}
+		}
+		if skippedLogs[cl.LogType] {
+			continue
+		}
+		if err := cl.Redo(v); err != nil {
+			log.Errorf("redo change log %s failed: %v", cl, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *verifier) versionRoot() common.Hash {
+	return v.versionTrie.Hash()
+}
+
+// sortedAddresses return the replayed accounts in a stable order, so that the reports are in the same order every time
+func (v *verifier) sortedAddresses() []common.Address {
+	addresses := make([]common.Address, 0, len(v.accounts))
+	for address := range v.accounts {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return bytes.Compare(addresses[i][:], addresses[j][:]) < 0
+	})
+	return addresses
+}
+
+func rlpEqual(a, b interface{}) bool {
+	aBytes, err := rlp.EncodeToBytes(a)
+	if err != nil {
+		return false
+	}
+	bBytes, err := rlp.EncodeToBytes(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(aBytes, bBytes)
+}
+
+// diffAccount describe the first different field between the replayed account and the saved account
+func diffAccount(expected, saved *types.AccountData) string {
+	switch {
+	case expected.Balance.Cmp(saved.Balance) != 0:
+		return fmt.Sprintf("balance is %s, expect %s", saved.Balance, expected.Balance)
+	case expected.Candidate.Votes.Cmp(saved.Candidate.Votes) != 0:
+		return fmt.Sprintf("votes is %s, expect %s", saved.Candidate.Votes, expected.Candidate.Votes)
+	case expected.VoteFor != saved.VoteFor:
+		return fmt.Sprintf("voteFor is %s, expect %s", saved.VoteFor.String(), expected.VoteFor.String())
+	case !rlpEqual(&expected.Candidate.Profile, &saved.Candidate.Profile):
+		return fmt.Sprintf("candidate profile is %v, expect %v", saved.Candidate.Profile, expected.Candidate.Profile)
+	case !rlpEqual(expected.Signers, saved.Signers):
+		return fmt.Sprintf("signers is %v, expect %v", saved.Signers, expected.Signers)
+	case !rlpEqual(expected, saved):
+		return "account data is different"
+	}
+	return ""
+}
+
+// compare the replayed state with the accounts, assets, equities and candidates in database
+func (v *verifier) compare(height uint32, mismatch func(height uint32, kind, format string, args ...interface{})) error {
+	accountDao := database.NewAccountDao(v.db)
+	assetDao := database.NewAssetDao(v.db)
+	equityDao := database.NewEquityDao(v.db)
+	candidates := make(map[common.Address]*big.Int)
+	for _, address := range v.sortedAddresses() {
+		acc := v.accounts[address]
+		saved, err := accountDao.Get(address)
+		if err == database.ErrNotExist {
+			mismatch(height, MismatchAccount, "%s is not saved", address.String())
+		} else if err != nil {
+			return err
+		} else if diff := diffAccount(&acc.AccountData, saved); diff != "" {
+			mismatch(height, MismatchAccount, "%s %s", address.String(), diff)
+		}
+
+		// the deleted assets and equities are not removed from database, so they are not verified
+		for code, asset := range acc.AssetCodes {
+			if asset == nil {
+				continue
+			}
+			savedAsset, err := assetDao.Get(code)
+			if err != nil {
+				return err
+			}
+			if savedAsset == nil {
+				mismatch(height, MismatchAsset, "%s is not saved", code.Hex())
+			} else if !rlpEqual(asset, savedAsset) {
+				mismatch(height, MismatchAsset, "%s is different", code.Hex())
+			}
+		}
+		for id, equity := range acc.AssetEquities {
+			if equity == nil {
+				continue
+			}
+			savedEquity, err := equityDao.Get(address, id)
+			if err == database.ErrNotExist {
+				mismatch(height, MismatchEquity, "%s of %s is not saved", id.Hex(), address.String())
+			} else if err != nil {
+				return err
+			} else if !rlpEqual(equity, savedEquity) {
+				mismatch(height, MismatchEquity, "%s of %s is %v, expect %v", id.Hex(), address.String(), savedEquity, equity)
+			}
+		}
+
+		if acc.isCandidate(acc.Candidate.Profile) {
+			candidates[address] = acc.Candidate.Votes
+		}
+	}
+	return compareCandidates(v.db, height, candidates, mismatch)
+}
+
+// compareCandidates compare the candidates in database with the candidates in replayed accounts
+func compareCandidates(db database.DBEngine, height uint32, candidates map[common.Address]*big.Int, mismatch func(height uint32, kind, format string, args ...interface{})) error {
+	candidateDao := database.NewCandidateDao(db)
+	_, total, err := candidateDao.GetPageWithTotal(0, 1)
+	if err != nil {
+		return err
+	}
+	saved := make([]*database.CandidateItem, 0)
+	if total > 0 {
+		if saved, err = candidateDao.GetPage(0, total); err != nil {
+			return err
+		}
+	}
+	savedSet := make(map[common.Address]bool)
+	for _, item := range saved {
+		savedSet[item.User] = true
+		votes, ok := candidates[item.User]
+		if !ok {
+			mismatch(height, MismatchCandidate, "%s is not a candidate", item.User.String())
+		} else if votes.Int64() != item.Votes.Int64() {
+			mismatch(height, MismatchCandidate, "votes of %s is %s, expect %s", item.User.String(), item.Votes, votes)
+		}
+	}
+	addresses := make([]common.Address, 0)
+	for address := range candidates {
+		if !savedSet[address] {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return bytes.Compare(addresses[i][:], addresses[j][:]) < 0
+	})
+	for _, address := range addresses {
+		mismatch(height, MismatchCandidate, "%s is not saved", address.String())
+	}
+	return nil
+}
+
+// Verify walk the blocks from genesis to current block, check their links, LogRoot and VersionRoot, and replay their
+// change logs to check the accounts, assets, equities and candidates in database. The mismatches are passed to report.
+// It returns the count of mismatches
+func (bc *BlockChain) Verify(report func(*Mismatch)) (int, error) {
+	current := bc.StableBlock()
+	if current == nil {
+		return 0, nil
+	}
+	v, err := newVerifier(bc.dbEngine)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	mismatch := func(height uint32, kind, format string, args ...interface{}) {
+		count++
+		report(&Mismatch{Height: height, Kind: kind, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	blockDao := database.NewBlockDao(bc.dbEngine)
+	var parent *types.Block
+	// the state can't be replayed after a missing block
+	replaying := true
+	for height := uint32(0); height <= current.Height(); height++ {
+		block, err := blockDao.GetBlockByHeight(height)
+		if err == database.ErrNotExist {
+			mismatch(height, MismatchMissingBlock, "the block is not in canonical index")
+			parent = nil
+			replaying = false
+			continue
+		} else if err != nil {
+			return count, err
+		}
+
+		if block.Height() != height {
+			mismatch(height, MismatchBlockLink, "canonical index points to block %d", block.Height())
+		} else if parent != nil && block.ParentHash() != parent.Hash() {
+			mismatch(height, MismatchBlockLink, "parent hash is %s, expect %s", block.ParentHash().Hex(), parent.Hash().Hex())
+		}
+		if root := block.ChangeLogs.MerkleRootSha(); root != block.LogRoot() {
+			mismatch(height, MismatchLogRoot, "change logs root is %s, but header is %s", root.Hex(), block.LogRoot().Hex())
+		}
+		if replaying {
+			if err := v.apply(block.ChangeLogs); err != nil {
+				return count, err
+			}
+			if root := v.versionRoot(); root != block.VersionRoot() {
+				mismatch(height, MismatchVersionRoot, "replayed root is %s, but header is %s", root.Hex(), block.VersionRoot().Hex())
+			}
+		}
+		parent = block
+		if height%verifyLogInterval == 0 {
+			log.Infof("verified %d blocks, %d mismatches", height+1, count)
+		}
+	}
+
+	if !replaying {
+		log.Warnf("the account state is not verified because of the missing blocks")
+		return count, nil
+	}
+	if err := v.compare(current.Height(), mismatch); err != nil {
+		return count, err
+	}
+	return count, nil
+}
@@ -0,0 +1,125 @@
+package chain
+
+import (
+	"github.com/LemoFoundationLtd/lemochain-core/chain/account"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-distribution/database"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+// makeVerifyBlocks create 3 blocks with change logs and the right LogRoot and VersionRoot
+func makeVerifyBlocks(t *testing.T) []*types.Block {
+	addr1 := common.BigToAddress(big.NewInt(1))
+	addr2 := common.BigToAddress(big.NewInt(2))
+	addr3 := common.BigToAddress(big.NewInt(3))
+	equity := &types.AssetEquity{AssetCode: common.HexToHash("0x11"), AssetId: common.HexToHash("0x22"), Equity: big.NewInt(50)}
+	logsList := []types.ChangeLogSlice{
+		{
+			{LogType: account.BalanceLog, Address: addr1, Version: 1, NewVal: *big.NewInt(1000)},
+			{LogType: account.CandidateLog, Address: addr2, Version: 1, NewVal: &types.Profile{types.CandidateKeyIsCandidate: types.IsCandidateNode}},
+			{LogType: account.VotesLog, Address: addr2, Version: 1, NewVal: *big.NewInt(500)},
+		},
+		{
+			{LogType: account.BalanceLog, Address: addr1, Version: 2, NewVal: *big.NewInt(900)},
+			{LogType: account.BalanceLog, Address: addr3, Version: 1, NewVal: *big.NewInt(100)},
+			{LogType: account.EquityLog, Address: addr3, Version: 1, NewVal: equity, Extra: equity.AssetId},
+			{LogType: account.EquityRootLog, Address: addr3, Version: 1, NewVal: common.HexToHash("0x33")},
+		},
+		{
+			{LogType: account.VotesLog, Address: addr2, Version: 2, NewVal: *big.NewInt(600)},
+		},
+	}
+
+	v, err := newVerifier(nil)
+	assert.NoError(t, err)
+	blocks := make([]*types.Block, 0, len(logsList))
+	parent := common.Hash{}
+	for height, logs := range logsList {
+		assert.NoError(t, v.apply(logs))
+		header := &types.Header{ParentHash: parent, Height: uint32(height), Time: uint32(1600000000 + height), VersionRoot: v.versionRoot(), LogRoot: logs.MerkleRootSha()}
+		block := types.NewBlock(header, nil, logs)
+		if height == 0 {
+			block.SetDeputyNodes(types.DeputyNodes{{MinerAddress: addr1, NodeID: make([]byte, 64), Votes: big.NewInt(1)}})
+		}
+		blocks = append(blocks, block)
+		parent = block.Hash()
+	}
+	return blocks
+}
+
+func TestVerifier_VersionRoot(t *testing.T) {
+	addr := common.BigToAddress(big.NewInt(1))
+	balanceLog := &types.ChangeLog{LogType: account.BalanceLog, Address: addr, Version: 1, NewVal: *big.NewInt(1)}
+	rootLog := &types.ChangeLog{LogType: account.StorageRootLog, Address: addr, Version: 1, NewVal: common.HexToHash("0x01")}
+	v1, err := newVerifier(nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v1.apply(types.ChangeLogSlice{balanceLog}))
+	v2, err := newVerifier(nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v2.apply(types.ChangeLogSlice{balanceLog, rootLog}))
+	// the root logs are not recorded in version trie
+	assert.Equal(t, v1.versionRoot(), v2.versionRoot())
+	assert.NotEqual(t, common.Hash{}, v1.versionRoot())
+	assert.Equal(t, common.HexToHash("0x01"), v2.accounts[addr].StorageRoot)
+}
+
+func verifyMismatches(t *testing.T, bc *BlockChain) []*Mismatch {
+	mismatches := make([]*Mismatch, 0)
+	count, err := bc.Verify(func(m *Mismatch) {
+		mismatches = append(mismatches, m)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, len(mismatches), count)
+	return mismatches
+}
+
+func TestBlockChain_Verify(t *testing.T) {
+	bc, clean := newTestChain(t)
+	defer clean()
+	blocks := makeVerifyBlocks(t)
+	assert.NoError(t, bc.InsertBlocks(blocks))
+	assert.Empty(t, verifyMismatches(t, bc))
+
+	// drift the state
+	addr1 := common.BigToAddress(big.NewInt(1))
+	accountData := database.NewAccountData(addr1)
+	accountData.Balance = big.NewInt(1)
+	assert.NoError(t, database.NewAccountDao(bc.dbEngine).Set(addr1, accountData))
+	addr2 := common.BigToAddress(big.NewInt(2))
+	assert.NoError(t, database.NewCandidateDao(bc.dbEngine).Set(&database.CandidateItem{User: addr2, Votes: big.NewInt(1)}))
+	addr3 := common.BigToAddress(big.NewInt(3))
+	equity := &types.AssetEquity{AssetCode: common.HexToHash("0x11"), AssetId: common.HexToHash("0x22"), Equity: big.NewInt(49)}
+	assert.NoError(t, database.NewEquityDao(bc.dbEngine).Set(addr3, equity))
+	mismatches := verifyMismatches(t, bc)
+	assert.Equal(t, 3, len(mismatches))
+	assert.Equal(t, MismatchAccount, mismatches[0].Kind)
+	assert.Equal(t, uint32(2), mismatches[0].Height)
+	assert.Equal(t, MismatchEquity, mismatches[1].Kind)
+	assert.Equal(t, MismatchCandidate, mismatches[2].Kind)
+}
+
+func TestBlockChain_VerifyBlocks(t *testing.T) {
+	bc, clean := newTestChain(t)
+	defer clean()
+	blocks := makeVerifyBlocks(t)
+	// the header doesn't match the change logs
+	blocks[2].Header.VersionRoot = common.HexToHash("0x01")
+	blocks[2].Header.LogRoot = common.HexToHash("0x02")
+	assert.NoError(t, bc.InsertBlocks(blocks))
+	mismatches := verifyMismatches(t, bc)
+	assert.Equal(t, 2, len(mismatches))
+	assert.Equal(t, MismatchLogRoot, mismatches[0].Kind)
+	assert.Equal(t, MismatchVersionRoot, mismatches[1].Kind)
+
+	// lose a height in canonical index
+	_, err := bc.dbEngine.GetDB().Exec("DELETE FROM t_block WHERE height = ?", 1)
+	assert.NoError(t, err)
+	mismatches = verifyMismatches(t, bc)
+	assert.Equal(t, MismatchMissingBlock, mismatches[0].Kind)
+	assert.Equal(t, uint32(1), mismatches[0].Height)
+	assert.Equal(t, MismatchLogRoot, mismatches[1].Kind)
+	assert.Equal(t, 2, len(mismatches))
+}
@@ -32,15 +32,15 @@ func NewAccountData(address common.Address) *types.AccountData {
 }
 
 type AccountDao struct{
-	engine *sql.DB
+	db DBEngine
 }
 
 func NewAccountDao(db DBEngine) (*AccountDao) {
-	return &AccountDao{engine:db.GetDB()}
+	return &AccountDao{db:db}
 }
 
 func (dao *AccountDao) GetDB() (*sql.DB) {
-	return dao.engine
+	return dao.db.GetDB()
 }
 
 func (dao *AccountDao) Get(addr common.Address) (*types.AccountData, error) {
@@ -49,7 +49,7 @@ func (dao *AccountDao) Get(addr common.Address) (*types.AccountData, error) {
 		return nil, ErrArgInvalid
 	}
 
-	kvDao := NewKvDao(dao)
+	kvDao := NewKvDao(dao.db)
 	val, err := kvDao.Get(GetAddressKey(addr))
 	if err != nil {
 		log.Errorf("get account.addr: " + addr.Hex() + ".err: " + err.Error())
@@ -80,6 +80,6 @@ func (dao *AccountDao) Set(addr common.Address, account *types.AccountData) (err
 		return err
 	}
 
-	kvDao := NewKvDao(dao)
+	kvDao := NewKvDao(dao.db)
 	return kvDao.Set(GetAddressKey(addr), val)
 }
\ No newline at end of file
@@ -11,11 +11,11 @@ import (
 )
 
 type AssetDao struct {
-	engine *sql.DB
+	engine Executor
 }
 
 func NewAssetDao(db DBEngine) *AssetDao {
-	return &AssetDao{engine: db.GetDB()}
+	return &AssetDao{engine: GetExecutor(db)}
 }
 
 func (dao *AssetDao) Set(asset *types.Asset) error {
@@ -88,13 +88,13 @@ func (dao *AssetDao) GetPage(addr common.Address, start, limit int) ([]*types.As
 		return nil, ErrArgInvalid
 	}
 
-	sql := "SELECT attrs, utc_st FROM t_asset WHERE addr = ? ORDER BY utc_st LIMIT ?, ?"
+	sql := "SELECT attrs, utc_st FROM t_asset WHERE addr = ? ORDER BY utc_st LIMIT ? OFFSET ?"
 	stmt, err := dao.engine.Prepare(sql)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := stmt.Query(addr.Hex(), start, start+limit)
+	rows, err := stmt.Query(addr.Hex(), start+limit, start)
 	if err != nil {
 		return nil, err
 	}
@@ -18,11 +18,12 @@ type AssetToken struct {
 }
 
 type AssetTokenDao struct {
-	engine *sql.DB
+	engine  Executor
+	dialect Dialect
 }
 
 func NewAssetTokenDao(db DBEngine) *AssetTokenDao {
-	return &AssetTokenDao{engine: db.GetDB()}
+	return &AssetTokenDao{engine: GetExecutor(db), dialect: db.GetDialect()}
 }
 
 func (dao *AssetTokenDao) Set(assetToken *AssetToken) error {
@@ -43,6 +44,39 @@ func (dao *AssetTokenDao) Set(assetToken *AssetToken) error {
 	}
 }
 
+// SetBatch save the meta data with multi-row statements. The version of the existed rows increases like Set.
+// If some meta data have the same id, the last one is saved
+func (dao *AssetTokenDao) SetBatch(assetTokens []*AssetToken) error {
+	ids := make([]common.Hash, 0, len(assetTokens))
+	latest := make(map[common.Hash]*AssetToken)
+	for _, assetToken := range assetTokens {
+		if assetToken == nil {
+			log.Errorf("set meta data batch.meta data is nil.")
+			return ErrArgInvalid
+		}
+		if _, ok := latest[assetToken.Id]; !ok {
+			ids = append(ids, assetToken.Id)
+		}
+		latest[assetToken.Id] = assetToken
+	}
+
+	cols := []string{"code", "id", "addr", "attrs", "version", "utc_st"}
+	sets := []string{"attrs = " + dao.dialect.Excluded("attrs"), "version = t_meta_data.version + 1"}
+	return forEachBatch(len(ids), len(cols), func(start, end int) error {
+		args := make([]interface{}, 0, (end-start)*len(cols))
+		for _, id := range ids[start:end] {
+			assetToken := latest[id]
+			val, err := rlp.EncodeToBytes(assetToken.MetaData)
+			if err != nil {
+				return err
+			}
+			args = append(args, assetToken.Code.Hex(), id.Hex(), assetToken.Owner.Hex(), val, 1, time.Now().UnixNano()/1000000)
+		}
+		_, err := dao.engine.Exec(dao.dialect.Upsert("t_meta_data", cols, []string{"id"}, sets, end-start), args...)
+		return err
+	})
+}
+
 func (dao *AssetTokenDao) Get(id common.Hash) (*AssetToken, error) {
 	if id == (common.Hash{}) {
 		log.Errorf("get meta data.id is common.hash{}")
@@ -107,13 +141,13 @@ func (dao *AssetTokenDao) GetPage(addr common.Address, start, limit int) ([]*Ass
 		return nil, ErrArgInvalid
 	}
 
-	sql := "SELECT id, code, addr, attrs, utc_st FROM t_meta_data WHERE addr = ? ORDER BY utc_st LIMIT ?, ?"
+	sql := "SELECT id, code, addr, attrs, utc_st FROM t_meta_data WHERE addr = ? ORDER BY utc_st LIMIT ? OFFSET ?"
 	stmt, err := dao.engine.Prepare(sql)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := stmt.Query(addr.Hex(), start, start+limit)
+	rows, err := stmt.Query(addr.Hex(), start+limit, start)
 	if err != nil {
 		return nil, err
 	}
@@ -149,13 +183,13 @@ func (dao *AssetTokenDao) GetPageByCode(code common.Hash, start, limit int) ([]*
 		return nil, ErrArgInvalid
 	}
 
-	sql := "SELECT  id, code, addr, attrs, utc_st FROM t_meta_data WHERE code = ? ORDER BY utc_st LIMIT ?, ?"
+	sql := "SELECT  id, code, addr, attrs, utc_st FROM t_meta_data WHERE code = ? ORDER BY utc_st LIMIT ? OFFSET ?"
 	stmt, err := dao.engine.Prepare(sql)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := stmt.Query(code.Hex(), start, start+limit)
+	rows, err := stmt.Query(code.Hex(), start+limit, start)
 	if err != nil {
 		return nil, err
 	}
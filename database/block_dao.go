@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/account"
 	"github.com/LemoFoundationLtd/lemochain-core/common"
 	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
 	"github.com/LemoFoundationLtd/lemochain-core/common/rlp"
@@ -10,15 +11,15 @@ import (
 )
 
 type BlockDao struct{
-	engine *sql.DB
+	db DBEngine
 }
 
 func NewBlockDao(db DBEngine) (*BlockDao){
-	return &BlockDao{engine:db.GetDB()}
+	return &BlockDao{db:db}
 }
 
 func (dao *BlockDao) GetDB() (*sql.DB) {
-	return dao.engine
+	return dao.db.GetDB()
 }
 
 func (dao *BlockDao) SetBlock(hash common.Hash, block *types.Block) (error) {
@@ -31,14 +32,21 @@ func (dao *BlockDao) SetBlock(hash common.Hash, block *types.Block) (error) {
 	if err != nil{
 		return err
 	}else{
-		kvDao := NewKvDao(dao)
-		err = kvDao.Set(GetCanonicalKey(block.Height()), hash.Bytes())
-		if err != nil{
-			return err
-		}
+		return dao.SetEncodedBlock(hash, block, val)
+	}
+}
+
+// SetEncodedBlock save the block which has been encoded by rlp, and index it by height in t_block
+func (dao *BlockDao) SetEncodedBlock(hash common.Hash, block *types.Block, val []byte) error {
+	if (hash == common.Hash{}) || block == nil || len(val) == 0 {
+		log.Errorf("set block.hash is common.hash{} or block is empty.")
+		return ErrArgInvalid
+	}
 
-		return kvDao.Set(GetBlockHashKey(hash), val)
+	if err := dao.SetRow(NewBlockRow(hash, block)); err != nil {
+		return err
 	}
+	return NewKvDao(dao.db).Set(GetBlockHashKey(hash), val)
 }
 
 func (dao *BlockDao) GetBlock(hash common.Hash) (*types.Block, error) {
@@ -47,7 +55,7 @@ func (dao *BlockDao) GetBlock(hash common.Hash) (*types.Block, error) {
 		return nil, ErrArgInvalid
 	}
 
-	kvDao := NewKvDao(dao)
+	kvDao := NewKvDao(dao.db)
 	val, err := kvDao.Get(GetBlockHashKey(hash))
 	if err != nil {
 		return nil, err
@@ -80,16 +88,265 @@ func (dao *BlockDao) IsExist(hash common.Hash) (bool, error){
 }
 
 func (dao *BlockDao) GetBlockByHeight(height uint32) (*types.Block, error) {
-	kvDao := NewKvDao(dao)
-	val, err := kvDao.Get(GetCanonicalKey(height))
+	hash, err := dao.GetHashByHeight(height)
+	if err == ErrNotExist {
+		log.Errorf("get block by height.is not exist.height: " + strconv.Itoa(int(height)))
+		return nil, ErrNotExist
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	if val == nil{
-		log.Errorf("get block by height.is not exist.height: " + strconv.Itoa(int(height)))
-		return nil, ErrNotExist
+	return dao.GetBlock(hash)
+}
+
+// BlockRow is the summary of a canonical block, which is saved in t_block
+type BlockRow struct {
+	Height     uint32
+	Hash       common.Hash
+	ParentHash common.Hash
+	Miner      common.Address
+	Time       uint32
+	TxCount    int
+	GasUsed    uint64
+	EventCount int
+}
+
+var blockColumns = []string{"height", "bhash", "parent_hash", "miner", "block_time", "tx_count", "gas_used", "event_count"}
+
+// blockFillBatch is the count of blocks moved into t_block in a query by migration
+const blockFillBatch = 1000
+
+func NewBlockRow(hash common.Hash, block *types.Block) *BlockRow {
+	eventCount := 0
+	for _, cl := range block.ChangeLogs {
+		if cl.LogType == account.AddEventLog {
+			eventCount++
+		}
 	}
+	return &BlockRow{
+		Height:     block.Height(),
+		Hash:       hash,
+		ParentHash: block.ParentHash(),
+		Miner:      block.MinerAddress(),
+		Time:       block.Time(),
+		TxCount:    len(block.Txs),
+		GasUsed:    block.GasUsed(),
+		EventCount: eventCount,
+	}
+}
+
+func (row *BlockRow) args() []interface{} {
+	return []interface{}{int64(row.Height), row.Hash.Hex(), row.ParentHash.Hex(), row.Miner.Hex(), int64(row.Time), row.TxCount, int64(row.GasUsed), row.EventCount}
+}
+
+// SetRow save the block summary. It replaces the block at the same height
+func (dao *BlockDao) SetRow(row *BlockRow) error {
+	if row == nil || row.Hash == (common.Hash{}) {
+		log.Errorf("set block row. row is nil or hash is common.hash{}")
+		return ErrArgInvalid
+	}
+
+	_, err := GetExecutor(dao.db).Exec(dao.db.GetDialect().Replace("t_block", blockColumns, []string{"height"}), row.args()...)
+	return err
+}
+
+// SetRows is like SetRow, but it saves the rows with multi-row statements. The rows must have different heights
+func (dao *BlockDao) SetRows(rows []*BlockRow) error {
+	return forEachBatch(len(rows), len(blockColumns), func(start, end int) error {
+		args := make([]interface{}, 0, (end-start)*len(blockColumns))
+		for _, row := range rows[start:end] {
+			args = append(args, row.args()...)
+		}
+		_, err := GetExecutor(dao.db).Exec(dao.db.GetDialect().ReplaceRows("t_block", blockColumns, []string{"height"}, end-start), args...)
+		return err
+	})
+}
+
+// GetHashByHeight return the hash of the canonical block at height
+func (dao *BlockDao) GetHashByHeight(height uint32) (common.Hash, error) {
+	row := GetExecutor(dao.db).QueryRow("SELECT bhash FROM t_block WHERE height = ?", int64(height))
+	var hash string
+	err := row.Scan(&hash)
+	if ErrIsNotExist(err) {
+		return common.Hash{}, ErrNotExist
+	}
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.HexToHash(hash), nil
+}
+
+// GetRowsByMinerWithTotal return the blocks mined by miner from the highest one, and the count of them
+func (dao *BlockDao) GetRowsByMinerWithTotal(miner common.Address, start, limit int) ([]*BlockRow, int, error) {
+	if miner == (common.Address{}) || (start < 0) || (limit <= 0) {
+		log.Errorf("get block rows by miner with total. miner is common.address{} or start < 0 or limit <= 0")
+		return nil, -1, ErrArgInvalid
+	}
+	return dao.getRowsWithTotal("miner = ?", []interface{}{miner.Hex()}, start, limit)
+}
+
+// GetRowsByTimeWithTotal return the blocks whose time is in [from, to] from the highest one, and the count of them
+func (dao *BlockDao) GetRowsByTimeWithTotal(from, to uint32, start, limit int) ([]*BlockRow, int, error) {
+	if (from > to) || (start < 0) || (limit <= 0) {
+		log.Errorf("get block rows by time with total. from > to or start < 0 or limit <= 0")
+		return nil, -1, ErrArgInvalid
+	}
+	return dao.getRowsWithTotal("block_time >= ? AND block_time <= ?", []interface{}{int64(from), int64(to)}, start, limit)
+}
 
-	return dao.GetBlock(common.BytesToHash(val))
+// GetRowsByHeightWithTotal return the blocks whose height is in [from, to] from the highest one, and the count of them
+func (dao *BlockDao) GetRowsByHeightWithTotal(from, to uint32, start, limit int) ([]*BlockRow, int, error) {
+	if (from > to) || (start < 0) || (limit <= 0) {
+		log.Errorf("get block rows by height with total. from > to or start < 0 or limit <= 0")
+		return nil, -1, ErrArgInvalid
+	}
+	return dao.getRowsWithTotal("height >= ? AND height <= ?", []interface{}{int64(from), int64(to)}, start, limit)
+}
+
+// GetRowsByMinerAndTimeWithTotal is like GetRowsByTimeWithTotal, but only the blocks mined by miner are returned. All the
+// miners are matched if it is common.Address{}
+func (dao *BlockDao) GetRowsByMinerAndTimeWithTotal(miner common.Address, from, to uint32, start, limit int) ([]*BlockRow, int, error) {
+	if miner == (common.Address{}) {
+		return dao.GetRowsByTimeWithTotal(from, to, start, limit)
+	}
+	if (from > to) || (start < 0) || (limit <= 0) {
+		log.Errorf("get block rows by miner and time with total. from > to or start < 0 or limit <= 0")
+		return nil, -1, ErrArgInvalid
+	}
+	return dao.getRowsWithTotal("miner = ? AND block_time >= ? AND block_time <= ?", []interface{}{miner.Hex(), int64(from), int64(to)}, start, limit)
+}
+
+func (dao *BlockDao) getRowsWithTotal(where string, args []interface{}, start, limit int) ([]*BlockRow, int, error) {
+	engine := GetExecutor(dao.db)
+	var total int
+	if err := engine.QueryRow("SELECT count(*) FROM t_block WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, -1, err
+	}
+
+	sqlQuery := "SELECT height, bhash, parent_hash, miner, block_time, tx_count, gas_used, event_count FROM t_block WHERE " + where + " ORDER BY height DESC LIMIT ? OFFSET ?"
+	rows, err := engine.Query(sqlQuery, append(args, limit, start)...)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer rows.Close()
+
+	result, err := scanBlockRows(rows)
+	if err != nil {
+		return nil, -1, err
+	}
+	return result, total, nil
+}
+
+func scanBlockRows(rows *sql.Rows) ([]*BlockRow, error) {
+	result := make([]*BlockRow, 0)
+	for rows.Next() {
+		var height, blockTime, gasUsed int64
+		var hash, parentHash, miner string
+		var txCount, eventCount int
+		if err := rows.Scan(&height, &hash, &parentHash, &miner, &blockTime, &txCount, &gasUsed, &eventCount); err != nil {
+			return nil, err
+		}
+		result = append(result, &BlockRow{
+			Height:     uint32(height),
+			Hash:       common.HexToHash(hash),
+			ParentHash: common.HexToHash(parentHash),
+			Miner:      common.HexToAddress(miner),
+			Time:       uint32(blockTime),
+			TxCount:    txCount,
+			GasUsed:    uint64(gasUsed),
+			EventCount: eventCount,
+		})
+	}
+	return result, rows.Err()
+}
+
+// canonicalKeyPattern matches the hex of GetCanonicalKey in t_kv: "H" + 4 bytes height + "h"
+const canonicalKeyPattern = "0x48________68"
+
+// fillBlockTable move the canonical index from t_kv to t_block. The summaries are decoded from the saved blocks
+func fillBlockTable(db DBEngine) error {
+	engine := GetExecutor(db)
+	blockDao := NewBlockDao(db)
+	last := ""
+	count := 0
+	for {
+		rows, err := engine.Query("SELECT lm_key, lm_val FROM t_kv WHERE lm_key LIKE ? AND lm_key > ? ORDER BY lm_key LIMIT ?", canonicalKeyPattern, last, blockFillBatch)
+		if err != nil {
+			return err
+		}
+		// read all the hashes before querying blocks, the connection is busy until rows is closed
+		hashes := make([]common.Hash, 0, blockFillBatch)
+		for rows.Next() {
+			var val []byte
+			if err := rows.Scan(&last, &val); err != nil {
+				rows.Close()
+				return err
+			}
+			hashes = append(hashes, common.BytesToHash(val))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(hashes) == 0 {
+			break
+		}
+
+		blockRows := make([]*BlockRow, 0, len(hashes))
+		for _, hash := range hashes {
+			block, err := blockDao.GetBlock(hash)
+			if err == ErrNotExist {
+				log.Warnf("the canonical block %s is not saved, skip it", hash.Hex())
+				continue
+			} else if err != nil {
+				return err
+			}
+			blockRows = append(blockRows, NewBlockRow(hash, block))
+		}
+		if err := blockDao.SetRows(blockRows); err != nil {
+			return err
+		}
+		count += len(blockRows)
+		log.Infof("move %d blocks into t_block", count)
+	}
+
+	_, err := engine.Exec("DELETE FROM t_kv WHERE lm_key LIKE ?", canonicalKeyPattern)
+	return err
+}
+
+// restoreCanonicalKeys move the canonical index from t_block back to t_kv
+func restoreCanonicalKeys(db DBEngine) error {
+	engine := GetExecutor(db)
+	kvDao := NewKvDao(db)
+	next := int64(0)
+	for {
+		rows, err := engine.Query("SELECT height, bhash FROM t_block WHERE height >= ? ORDER BY height LIMIT ?", next, blockFillBatch)
+		if err != nil {
+			return err
+		}
+		keys := make([][]byte, 0, blockFillBatch)
+		vals := make([][]byte, 0, blockFillBatch)
+		for rows.Next() {
+			var height int64
+			var hash string
+			if err := rows.Scan(&height, &hash); err != nil {
+				rows.Close()
+				return err
+			}
+			keys = append(keys, GetCanonicalKey(uint32(height)))
+			vals = append(vals, common.HexToHash(hash).Bytes())
+			next = height + 1
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		if err := kvDao.SetBatch(keys, vals); err != nil {
+			return err
+		}
+	}
 }
@@ -8,7 +8,8 @@ import (
 )
 
 type CandidateDao struct{
-	engine *sql.DB
+	engine  Executor
+	dialect Dialect
 }
 
 type CandidateItem struct{
@@ -17,7 +18,7 @@ type CandidateItem struct{
 }
 
 func NewCandidateDao(engine DBEngine) (*CandidateDao){
-	return &CandidateDao{engine:engine.GetDB()}
+	return &CandidateDao{engine: GetExecutor(engine), dialect: engine.GetDialect()}
 }
 
 func (dao *CandidateDao) Set(item *CandidateItem) (error) {
@@ -35,7 +36,7 @@ func (dao *CandidateDao) Set(item *CandidateItem) (error) {
 		votes = item.Votes.Int64()
 	}
 
-	result, err := dao.engine.Exec("REPLACE INTO t_candidates(addr, votes) VALUES (?,?)", item.User.Hex(), votes)
+	result, err := dao.engine.Exec(dao.dialect.Replace("t_candidates", []string{"addr", "votes"}, []string{"addr"}), item.User.Hex(), votes)
 	if err != nil {
 		return err
 	}
@@ -110,13 +111,13 @@ func (dao *CandidateDao) GetPage(start, limit int) ([]*CandidateItem, error) {
 		return nil, ErrArgInvalid
 	}
 
-	sqlQuery := "SELECT addr, votes FROM t_candidates ORDER BY votes DESC LIMIT ?, ?"
+	sqlQuery := "SELECT addr, votes FROM t_candidates ORDER BY votes DESC LIMIT ? OFFSET ?"
 	stmt, err := dao.engine.Prepare(sqlQuery)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := stmt.Query(start, start + limit)
+	rows, err := stmt.Query(start+limit, start)
 	if err != nil {
 		return nil, err
 	}
@@ -10,4 +10,6 @@ var (
 	ErrBigIntSetString = errors.New("big int setString error")
 	ErrOutOfMemory     = errors.New("out of memory")
 	ErrUnKnown         = errors.New("")
+	ErrSchemaTooNew    = errors.New("database schema is newer than this program")
+	ErrSchemaTooOld    = errors.New("database schema is older than this program")
 )
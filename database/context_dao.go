@@ -1,7 +1,6 @@
 package database
 
 import (
-	"database/sql"
 	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
 	"github.com/LemoFoundationLtd/lemochain-core/common/log"
 	"github.com/LemoFoundationLtd/lemochain-core/common/rlp"
@@ -14,15 +13,18 @@ type Context struct {
 }
 
 var (
-	ContextKeyCurrentBlock = "context.chain.current_block"
+	ContextKeyCurrentBlock  = "context.chain.current_block"
+	ContextKeySchemaVersion = "context.schema.version"
+	ContextKeyWriterLease   = "context.lease.writer"
 )
 
 type ContextDao struct {
-	engine *sql.DB
+	engine  Executor
+	dialect Dialect
 }
 
 func NewContextDao(db DBEngine) *ContextDao {
-	return &ContextDao{engine: db.GetDB()}
+	return &ContextDao{engine: GetExecutor(db), dialect: db.GetDialect()}
 }
 
 func (dao *ContextDao) GetCurrentBlock() (*types.Block, error) {
@@ -60,7 +62,7 @@ func (dao *ContextDao) SetCurrentBlock(block *types.Block) error {
 }
 
 func (dao *ContextDao) ContextSet(key string, val []byte) error {
-	result, err := dao.engine.Exec("REPLACE INTO t_context(lm_key, lm_val) VALUES (?,?)", key, val)
+	result, err := dao.engine.Exec(dao.dialect.Replace("t_context", []string{"lm_key", "lm_val"}, []string{"lm_key"}), key, val)
 	if err != nil {
 		return err
 	}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"time"
 )
 
 func ErrIsNotExist(err error) bool {
@@ -14,32 +15,121 @@ func ErrIsNotExist(err error) bool {
 
 type DBEngine interface {
 	GetDB() *sql.DB
+	GetDialect() Dialect
 }
 
-type MySqlDB struct {
-	engine *sql.DB
-	driver string
-	dbHost string
+// Executor is implemented by both *sql.DB and *sql.Tx, so that a dao can work inside a sql transaction
+type Executor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Prepare(query string) (*sql.Stmt, error)
 }
 
-func NewMySqlDB(driver string, dbHost string) *MySqlDB {
+// TxEngine is a DBEngine bound to a sql transaction. All the daos created by it run in the transaction
+type TxEngine struct {
+	DBEngine
+	tx *sql.Tx
+}
+
+// NewTxEngine wrap a caller-supplied transaction
+func NewTxEngine(db DBEngine, tx *sql.Tx) *TxEngine {
+	return &TxEngine{DBEngine: db, tx: tx}
+}
+
+// BeginTx start a new transaction on db
+func BeginTx(db DBEngine) (*TxEngine, error) {
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	return NewTxEngine(db, tx), nil
+}
+
+func (engine *TxEngine) GetTx() *sql.Tx {
+	return engine.tx
+}
+
+func (engine *TxEngine) Commit() error {
+	return engine.tx.Commit()
+}
+
+// Rollback abort the transaction. It is safe to call it after Commit
+func (engine *TxEngine) Rollback() error {
+	err := engine.tx.Rollback()
+	if err == sql.ErrTxDone {
+		return nil
+	}
+	return err
+}
+
+// GetExecutor return the transaction if db is a TxEngine, otherwise return the *sql.DB. The queries are rebound to the dialect of db
+func GetExecutor(db DBEngine) Executor {
+	var executor Executor = db.GetDB()
+	if txEngine, ok := db.(*TxEngine); ok {
+		executor = txEngine.tx
+	}
+	return &rebindExecutor{executor: executor, dialect: db.GetDialect()}
+}
+
+// PoolConfig is the connection pool config of SqlDB
+type PoolConfig struct {
+	MaxOpenConns    int           // 0 means no limit
+	MaxIdleConns    int           // 0 keeps the default of database/sql, which is 2
+	ConnMaxIdleTime time.Duration // 0 means the idle connections are never closed for idle time
+	ConnMaxLifetime time.Duration // 0 means the connections are never closed for age
+}
+
+// SqlDB is a DBEngine of mysql, postgres or sqlite3
+type SqlDB struct {
+	engine  *sql.DB
+	dialect Dialect
+	driver  string
+	dbHost  string
+}
+
+func NewSqlDB(driver string, dbHost string) *SqlDB {
+	dialect, err := NewDialect(driver)
+	if err != nil {
+		panic("open " + driver + " err: " + err.Error())
+	}
+
 	db, err := Open(driver, dbHost)
 	if err != nil {
-		panic("open mysql err: " + err.Error())
+		panic("open " + driver + " err: " + err.Error())
 	}
 
-	return &MySqlDB{
-		engine: db,
-		driver: driver,
-		dbHost: dbHost,
+	return &SqlDB{
+		engine:  db,
+		dialect: dialect,
+		driver:  driver,
+		dbHost:  dbHost,
 	}
 }
 
-func (db *MySqlDB) GetDB() *sql.DB {
+func NewMySqlDB(driver string, dbHost string) *SqlDB {
+	return NewSqlDB(driver, dbHost)
+}
+
+// SetPool config the connection pool. The SqlDB should be shared, instead of being opened for every query
+func (db *SqlDB) SetPool(pool PoolConfig) {
+	db.engine.SetMaxOpenConns(pool.MaxOpenConns)
+	if pool.MaxIdleConns > 0 {
+		db.engine.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+	db.engine.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+	db.engine.SetConnMaxLifetime(pool.ConnMaxLifetime)
+}
+
+func (db *SqlDB) GetDB() *sql.DB {
 	return db.engine
 }
 
-func (db *MySqlDB) Clear() error {
+func (db *SqlDB) GetDialect() Dialect {
+	return db.dialect
+}
+
+func (db *SqlDB) Clear() error {
 	_, err := db.engine.Exec("DELETE FROM t_kv")
 	if err != nil {
 		return err
@@ -74,9 +164,24 @@ func (db *MySqlDB) Clear() error {
 	if err != nil {
 		return err
 	}
+
+	_, err = db.engine.Exec("DELETE FROM t_undo")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.engine.Exec("DELETE FROM t_block")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.engine.Exec("DELETE FROM t_event")
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
-func (db *MySqlDB) Close() {
+func (db *SqlDB) Close() {
 	db.engine.Close()
 }
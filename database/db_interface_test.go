@@ -1,4 +1,82 @@
 package database
 
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
 
+func TestTxEngine_Commit(t *testing.T) {
+	db := NewMySqlDB(DRIVER_MYSQL, HOST_MYSQL)
+	defer db.Close()
+	defer db.Clear()
 
+	txEngine, err := BeginTx(db)
+	assert.NoError(t, err)
+
+	err = NewKvDao(txEngine).Set([]byte("key"), []byte("val"))
+	assert.NoError(t, err)
+	err = NewContextDao(txEngine).ContextSet(ContextKeyCurrentBlock, []byte("val"))
+	assert.NoError(t, err)
+
+	// read its own writes in the transaction
+	result, err := NewKvDao(txEngine).Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val"), result)
+
+	assert.NoError(t, txEngine.Commit())
+	assert.NoError(t, txEngine.Rollback())
+
+	result, err = NewKvDao(db).Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val"), result)
+
+	result, err = NewContextDao(db).ContextGet(ContextKeyCurrentBlock)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val"), result)
+}
+
+func TestTxEngine_Rollback(t *testing.T) {
+	db := NewMySqlDB(DRIVER_MYSQL, HOST_MYSQL)
+	defer db.Close()
+	defer db.Clear()
+
+	txEngine, err := BeginTx(db)
+	assert.NoError(t, err)
+
+	err = NewKvDao(txEngine).Set([]byte("key"), []byte("val"))
+	assert.NoError(t, err)
+	err = NewContextDao(txEngine).ContextSet(ContextKeyCurrentBlock, []byte("val"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, txEngine.Rollback())
+
+	result, err := NewKvDao(db).Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+
+	result, err = NewContextDao(db).ContextGet(ContextKeyCurrentBlock)
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+}
+
+func TestSqlDB_SetPool(t *testing.T) {
+	db, closeDB := newSqliteDB(t)
+	defer closeDB()
+
+	db.SetPool(PoolConfig{MaxOpenConns: 5, MaxIdleConns: 2, ConnMaxIdleTime: time.Minute, ConnMaxLifetime: time.Hour})
+	assert.Equal(t, 5, db.GetDB().Stats().MaxOpenConnections)
+
+	// the shared engine serves concurrent queries
+	done := make(chan error)
+	for i := 0; i < 20; i++ {
+		go func() {
+			_, err := NewKvDao(db).Get([]byte("key"))
+			done <- err
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, <-done)
+	}
+	assert.True(t, db.GetDB().Stats().OpenConnections <= 5)
+}
@@ -3,6 +3,8 @@ package database
 import (
 	"database/sql"
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 var (
@@ -41,7 +43,3 @@ func Del(db *sql.DB, key string) error {
 		return nil
 	}
 }
-
-func CreateDB(db *sql.DB) (sql.Result, error) {
-	return nil, nil
-}
@@ -0,0 +1,271 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/LemoFoundationLtd/lemochain-distribution/metrics"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DRIVER_POSTGRES = "postgres"
+	DRIVER_SQLITE3  = "sqlite3"
+)
+
+var ErrUnknownDriver = errors.New("unknown database driver")
+
+// Dialect generate the sql which is different between databases. The daos write sql with '?' placeholders
+type Dialect interface {
+	Name() string
+	// Rebind replace the '?' placeholders in query by the placeholders of the database
+	Rebind(query string) string
+	// Replace build a statement which inserts a row, or replaces the existed row with the same keys
+	Replace(table string, cols []string, keys []string) string
+	// ReplaceRows is like Replace, but it inserts count rows in one statement. The rows must have different keys
+	ReplaceRows(table string, cols []string, keys []string, count int) string
+	// Upsert build a statement which inserts count rows, or applies the assignments in sets to the existed rows with
+	// the same keys. The rows must have different keys
+	Upsert(table string, cols []string, keys []string, sets []string, count int) string
+	// Excluded reference the value of col in the row which is not inserted by Upsert because of conflict
+	Excluded(col string) string
+	// TableExists build a query which counts the tables named by its only argument
+	TableExists() string
+	// Now build a query which returns the unix milliseconds of the database clock
+	Now() string
+	// ForUpdate is appended to a select statement to lock the selected rows until the transaction ends
+	ForUpdate() string
+}
+
+// NewDialect return the dialect of driver. The driver is "mysql", "postgres" or "sqlite3"
+func NewDialect(driver string) (Dialect, error) {
+	switch driver {
+	case DRIVER_MYSQL:
+		return &mysqlDialect{}, nil
+	case DRIVER_POSTGRES:
+		return &postgresDialect{}, nil
+	case DRIVER_SQLITE3:
+		return &sqliteDialect{}, nil
+	default:
+		return nil, ErrUnknownDriver
+	}
+}
+
+// maxBatchArgs limit the arguments in one statement. It is the lowest limit of the databases, which is sqlite's
+const maxBatchArgs = 999
+
+// forEachBatch split count rows with cols columns into the ranges which can be written in one statement
+func forEachBatch(count int, cols int, fn func(start, end int) error) error {
+	size := maxBatchArgs / cols
+	for start := 0; start < count; start += size {
+		end := start + size
+		if end > count {
+			end = count
+		}
+		if err := fn(start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func placeholders(count int) string {
+	marks := make([]string, count)
+	for i := range marks {
+		marks[i] = "?"
+	}
+	return strings.Join(marks, ",")
+}
+
+// insertRows build the insert statement of count rows
+func insertRows(table string, cols []string, count int) string {
+	rows := make([]string, count)
+	for i := range rows {
+		rows[i] = "(" + placeholders(len(cols)) + ")"
+	}
+	return "INSERT INTO " + table + "(" + strings.Join(cols, ", ") + ") VALUES " + strings.Join(rows, ",")
+}
+
+// onConflict build the upsert statement of postgres and sqlite
+func onConflict(table string, cols []string, keys []string, sets []string, count int) string {
+	return insertRows(table, cols, count) + " ON CONFLICT (" + strings.Join(keys, ", ") + ") DO UPDATE SET " + strings.Join(sets, ", ")
+}
+
+type mysqlDialect struct{}
+
+func (d *mysqlDialect) Name() string {
+	return DRIVER_MYSQL
+}
+
+func (d *mysqlDialect) Rebind(query string) string {
+	return query
+}
+
+func (d *mysqlDialect) Replace(table string, cols []string, keys []string) string {
+	return d.ReplaceRows(table, cols, keys, 1)
+}
+
+func (d *mysqlDialect) ReplaceRows(table string, cols []string, keys []string, count int) string {
+	return "REPLACE" + strings.TrimPrefix(insertRows(table, cols, count), "INSERT")
+}
+
+func (d *mysqlDialect) Upsert(table string, cols []string, keys []string, sets []string, count int) string {
+	return insertRows(table, cols, count) + " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+}
+
+func (d *mysqlDialect) Excluded(col string) string {
+	return "VALUES(" + col + ")"
+}
+
+func (d *mysqlDialect) TableExists() string {
+	return "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+}
+
+func (d *mysqlDialect) Now() string {
+	return "SELECT CAST(UNIX_TIMESTAMP(NOW(3)) * 1000 AS UNSIGNED)"
+}
+
+func (d *mysqlDialect) ForUpdate() string {
+	return " FOR UPDATE"
+}
+
+type sqliteDialect struct{}
+
+func (d *sqliteDialect) Name() string {
+	return DRIVER_SQLITE3
+}
+
+func (d *sqliteDialect) Rebind(query string) string {
+	return query
+}
+
+func (d *sqliteDialect) Replace(table string, cols []string, keys []string) string {
+	return d.ReplaceRows(table, cols, keys, 1)
+}
+
+func (d *sqliteDialect) ReplaceRows(table string, cols []string, keys []string, count int) string {
+	return "INSERT OR REPLACE" + strings.TrimPrefix(insertRows(table, cols, count), "INSERT")
+}
+
+func (d *sqliteDialect) Upsert(table string, cols []string, keys []string, sets []string, count int) string {
+	return onConflict(table, cols, keys, sets, count)
+}
+
+func (d *sqliteDialect) Excluded(col string) string {
+	return "excluded." + col
+}
+
+func (d *sqliteDialect) TableExists() string {
+	return "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+}
+
+func (d *sqliteDialect) Now() string {
+	return "SELECT CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER)"
+}
+
+// ForUpdate is empty because sqlite has no row lock. The writes of all the transactions are serialized by database lock
+func (d *sqliteDialect) ForUpdate() string {
+	return ""
+}
+
+type postgresDialect struct{}
+
+func (d *postgresDialect) Name() string {
+	return DRIVER_POSTGRES
+}
+
+// Rebind replace '?' by $1, $2... The queries in daos have no '?' in string literals
+func (d *postgresDialect) Rebind(query string) string {
+	var builder strings.Builder
+	index := 0
+	for _, c := range query {
+		if c == '?' {
+			index++
+			builder.WriteString("$" + strconv.Itoa(index))
+		} else {
+			builder.WriteRune(c)
+		}
+	}
+	return builder.String()
+}
+
+func (d *postgresDialect) Replace(table string, cols []string, keys []string) string {
+	return d.ReplaceRows(table, cols, keys, 1)
+}
+
+func (d *postgresDialect) ReplaceRows(table string, cols []string, keys []string, count int) string {
+	updates := make([]string, 0, len(cols))
+	for _, col := range cols {
+		isKey := false
+		for _, key := range keys {
+			if col == key {
+				isKey = true
+				break
+			}
+		}
+		if !isKey {
+			updates = append(updates, col+" = "+d.Excluded(col))
+		}
+	}
+	return d.Upsert(table, cols, keys, updates, count)
+}
+
+func (d *postgresDialect) Upsert(table string, cols []string, keys []string, sets []string, count int) string {
+	return onConflict(table, cols, keys, sets, count)
+}
+
+func (d *postgresDialect) Excluded(col string) string {
+	return "EXCLUDED." + col
+}
+
+func (d *postgresDialect) TableExists() string {
+	return "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?"
+}
+
+// Now use clock_timestamp() because now() is the start time of the transaction
+func (d *postgresDialect) Now() string {
+	return "SELECT CAST(EXTRACT(EPOCH FROM clock_timestamp()) * 1000 AS BIGINT)"
+}
+
+func (d *postgresDialect) ForUpdate() string {
+	return " FOR UPDATE"
+}
+
+// tableRegexp find the table name in query for metrics
+var tableRegexp = regexp.MustCompile(`\bt_[a-z_]+`)
+
+// queryTable return the first table in query
+func queryTable(query string) string {
+	if table := tableRegexp.FindString(query); table != "" {
+		return table
+	}
+	return "unknown"
+}
+
+// rebindExecutor rebind the queries before executing them, and record their latency
+type rebindExecutor struct {
+	executor Executor
+	dialect  Dialect
+}
+
+func (e *rebindExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer metrics.ObserveQuery("exec", queryTable(query), time.Now())
+	return e.executor.Exec(e.dialect.Rebind(query), args...)
+}
+
+func (e *rebindExecutor) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer metrics.ObserveQuery("query", queryTable(query), time.Now())
+	return e.executor.Query(e.dialect.Rebind(query), args...)
+}
+
+func (e *rebindExecutor) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer metrics.ObserveQuery("queryRow", queryTable(query), time.Now())
+	return e.executor.QueryRow(e.dialect.Rebind(query), args...)
+}
+
+func (e *rebindExecutor) Prepare(query string) (*sql.Stmt, error) {
+	defer metrics.ObserveQuery("prepare", queryTable(query), time.Now())
+	return e.executor.Prepare(e.dialect.Rebind(query))
+}
@@ -0,0 +1,363 @@
+package database
+
+import (
+	"encoding/json"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/account"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newSqliteDB create a sqlite3 database with the latest schema in a temp directory
+func newSqliteDB(t *testing.T) (*SqlDB, func()) {
+	dir, err := ioutil.TempDir("", "lemo-distribution")
+	assert.NoError(t, err)
+
+	db := NewSqlDB(DRIVER_SQLITE3, filepath.Join(dir, "lemochain.db"))
+	assert.NoError(t, CreateDB(db))
+
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestNewDialect(t *testing.T) {
+	for _, driver := range []string{DRIVER_MYSQL, DRIVER_POSTGRES, DRIVER_SQLITE3} {
+		dialect, err := NewDialect(driver)
+		assert.NoError(t, err)
+		assert.Equal(t, driver, dialect.Name())
+	}
+
+	dialect, err := NewDialect("oracle")
+	assert.Equal(t, ErrUnknownDriver, err)
+	assert.Nil(t, dialect)
+}
+
+func TestDialect_Rebind(t *testing.T) {
+	query := "SELECT lm_val FROM t_kv WHERE lm_key = ? AND st > ? LIMIT ? OFFSET ?"
+
+	mysql, _ := NewDialect(DRIVER_MYSQL)
+	assert.Equal(t, query, mysql.Rebind(query))
+	sqlite, _ := NewDialect(DRIVER_SQLITE3)
+	assert.Equal(t, query, sqlite.Rebind(query))
+	postgres, _ := NewDialect(DRIVER_POSTGRES)
+	assert.Equal(t, "SELECT lm_val FROM t_kv WHERE lm_key = $1 AND st > $2 LIMIT $3 OFFSET $4", postgres.Rebind(query))
+}
+
+func TestDialect_Replace(t *testing.T) {
+	cols := []string{"id", "addr", "equity"}
+	keys := []string{"id", "addr"}
+
+	mysql, _ := NewDialect(DRIVER_MYSQL)
+	assert.Equal(t, "REPLACE INTO t_equity(id, addr, equity) VALUES (?,?,?)", mysql.Replace("t_equity", cols, keys))
+	sqlite, _ := NewDialect(DRIVER_SQLITE3)
+	assert.Equal(t, "INSERT OR REPLACE INTO t_equity(id, addr, equity) VALUES (?,?,?)", sqlite.Replace("t_equity", cols, keys))
+	postgres, _ := NewDialect(DRIVER_POSTGRES)
+	assert.Equal(t, "INSERT INTO t_equity(id, addr, equity) VALUES (?,?,?) ON CONFLICT (id, addr) DO UPDATE SET equity = EXCLUDED.equity", postgres.Replace("t_equity", cols, keys))
+}
+
+func TestDialect_ReplaceRows(t *testing.T) {
+	cols := []string{"id", "addr", "equity"}
+	keys := []string{"id", "addr"}
+
+	mysql, _ := NewDialect(DRIVER_MYSQL)
+	assert.Equal(t, "REPLACE INTO t_equity(id, addr, equity) VALUES (?,?,?),(?,?,?)", mysql.ReplaceRows("t_equity", cols, keys, 2))
+	sqlite, _ := NewDialect(DRIVER_SQLITE3)
+	assert.Equal(t, "INSERT OR REPLACE INTO t_equity(id, addr, equity) VALUES (?,?,?),(?,?,?)", sqlite.ReplaceRows("t_equity", cols, keys, 2))
+	postgres, _ := NewDialect(DRIVER_POSTGRES)
+	assert.Equal(t, "INSERT INTO t_equity(id, addr, equity) VALUES (?,?,?),(?,?,?) ON CONFLICT (id, addr) DO UPDATE SET equity = EXCLUDED.equity", postgres.ReplaceRows("t_equity", cols, keys, 2))
+}
+
+func TestDialect_Upsert(t *testing.T) {
+	cols := []string{"id", "equity", "version"}
+	keys := []string{"id"}
+
+	mysql, _ := NewDialect(DRIVER_MYSQL)
+	sets := []string{"equity = " + mysql.Excluded("equity"), "version = t_equity.version + 1"}
+	assert.Equal(t, "INSERT INTO t_equity(id, equity, version) VALUES (?,?,?) ON DUPLICATE KEY UPDATE equity = VALUES(equity), version = t_equity.version + 1", mysql.Upsert("t_equity", cols, keys, sets, 1))
+	sqlite, _ := NewDialect(DRIVER_SQLITE3)
+	sets = []string{"equity = " + sqlite.Excluded("equity")}
+	assert.Equal(t, "INSERT INTO t_equity(id, equity, version) VALUES (?,?,?) ON CONFLICT (id) DO UPDATE SET equity = excluded.equity", sqlite.Upsert("t_equity", cols, keys, sets, 1))
+	postgres, _ := NewDialect(DRIVER_POSTGRES)
+	sets = []string{"equity = " + postgres.Excluded("equity")}
+	assert.Equal(t, "INSERT INTO t_equity(id, equity, version) VALUES (?,?,?) ON CONFLICT (id) DO UPDATE SET equity = EXCLUDED.equity", postgres.Upsert("t_equity", cols, keys, sets, 1))
+}
+
+func TestQueryTable(t *testing.T) {
+	assert.Equal(t, "t_context", queryTable("SELECT lm_val FROM t_context WHERE lm_key = ?"))
+	assert.Equal(t, "t_meta_data", queryTable("INSERT INTO t_meta_data(id, code) VALUES (?, ?)"))
+	assert.Equal(t, "unknown", queryTable("SELECT 1"))
+}
+
+func TestSqlite_Dao(t *testing.T) {
+	db, closeDB := newSqliteDB(t)
+	defer closeDB()
+
+	// replace
+	kvDao := NewKvDao(db)
+	assert.NoError(t, kvDao.Set([]byte("key"), []byte("val1")))
+	assert.NoError(t, kvDao.Set([]byte("key"), []byte("val2")))
+	val, err := kvDao.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val2"), val)
+
+	// page
+	candidateDao := NewCandidateDao(db)
+	candidates := NewCandidates50()
+	for _, candidate := range candidates {
+		assert.NoError(t, candidateDao.Set(candidate))
+	}
+	result, total, err := candidateDao.GetPageWithTotal(40, 60)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, len(result))
+	assert.Equal(t, 50, total)
+	assert.Equal(t, candidates[9], result[0])
+
+	// version update and undo in transaction
+	txEngine, err := BeginTx(db)
+	assert.NoError(t, err)
+	addr := common.HexToAddress("0x01")
+	equity := NewAssetEquity(common.HexToHash("0x02"), common.HexToHash("0x03"), 100)
+	assert.NoError(t, NewEquityDao(txEngine).Set(addr, equity))
+	assert.NoError(t, NewUndoDao(txEngine).RecordEquity(1, addr, equity.AssetId))
+	equity.Equity = big.NewInt(999)
+	assert.NoError(t, NewEquityDao(txEngine).Set(addr, equity))
+	assert.NoError(t, txEngine.Commit())
+
+	assert.NoError(t, NewUndoDao(db).Revert(1))
+	equityResult, err := NewEquityDao(db).Get(addr, equity.AssetId)
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(100), equityResult.Equity)
+}
+
+func TestSqlite_SetBatch(t *testing.T) {
+	db, closeDB := newSqliteDB(t)
+	defer closeDB()
+
+	// more rows than a statement can hold, and the same key in different statements
+	txes := make([]*Tx, 0)
+	for i := 1; i <= 200; i++ {
+		txes = append(txes, NewTx(common.BigToHash(big.NewInt(int64(i)))))
+	}
+	last := NewTx(common.BigToHash(big.NewInt(1)))
+	last.Height = 100
+	txes = append(txes, last)
+	assert.NoError(t, NewTxDao(db).SetBatch(txes))
+	tx, err := NewTxDao(db).Get(last.THash)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(100), tx.Height)
+	_, total, err := NewTxDao(db).GetByAddrWithTotal(last.From, 0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, total)
+
+	keys := [][]byte{[]byte("key1"), []byte("key2"), []byte("key1")}
+	vals := [][]byte{[]byte("val1"), []byte("val2"), []byte("val3")}
+	assert.NoError(t, NewKvDao(db).SetBatch(keys, vals))
+	val, err := NewKvDao(db).Get([]byte("key1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val3"), val)
+	assert.Equal(t, ErrArgInvalid, NewKvDao(db).SetBatch(keys, vals[:1]))
+
+	// update the existed rows and revert them
+	addr := common.HexToAddress("0x01")
+	equity1 := NewAssetEquity(common.HexToHash("0x02"), common.HexToHash("0x03"), 100)
+	equity2 := NewAssetEquity(common.HexToHash("0x02"), common.HexToHash("0x04"), 200)
+	assert.NoError(t, NewEquityDao(db).Set(addr, equity1))
+	token := NewAssetToken(common.HexToHash("0x05"), common.HexToHash("0x02"), addr, false)
+	assert.NoError(t, NewAssetTokenDao(db).Set(token))
+
+	txEngine, err := BeginTx(db)
+	assert.NoError(t, err)
+	undoDao := NewUndoDao(txEngine)
+	assert.NoError(t, undoDao.RecordEquities(1, addr, []common.Hash{equity1.AssetId, equity2.AssetId}))
+	assert.NoError(t, undoDao.RecordAssetTokens(1, []common.Hash{token.Id}))
+	assert.NoError(t, NewEquityDao(txEngine).SetBatch(addr, []*types.AssetEquity{
+		NewAssetEquity(equity1.AssetCode, equity1.AssetId, 999),
+		equity2,
+	}))
+	assert.NoError(t, NewAssetTokenDao(txEngine).SetBatch([]*AssetToken{{Id: token.Id, Code: token.Code, Owner: addr, MetaData: "new"}}))
+	assert.NoError(t, txEngine.Commit())
+
+	equityDao := NewEquityDao(db)
+	result, version, err := equityDao.query(addr, equity1.AssetId)
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(999), result.Equity)
+	assert.Equal(t, 2, version)
+	result, version, err = equityDao.query(addr, equity2.AssetId)
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(200), result.Equity)
+	assert.Equal(t, 1, version)
+	tokenResult, err := NewAssetTokenDao(db).Get(token.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "new", tokenResult.MetaData)
+
+	assert.NoError(t, NewUndoDao(db).Revert(1))
+	result, err = equityDao.Get(addr, equity1.AssetId)
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(100), result.Equity)
+	_, err = equityDao.Get(addr, equity2.AssetId)
+	assert.Equal(t, ErrNotExist, err)
+	tokenResult, err = NewAssetTokenDao(db).Get(token.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "profile", tokenResult.MetaData)
+}
+
+func TestSqlite_BlockRows(t *testing.T) {
+	db, closeDB := newSqliteDB(t)
+	defer closeDB()
+
+	miner1 := common.HexToAddress("0x01")
+	miner2 := common.HexToAddress("0x02")
+	blockDao := NewBlockDao(db)
+	parent := common.Hash{}
+	for height := uint32(0); height < 10; height++ {
+		miner := miner1
+		if height%2 == 1 {
+			miner = miner2
+		}
+		block := types.NewBlock(&types.Header{ParentHash: parent, Height: height, MinerAddress: miner, Time: 1600000000 + height*3, GasUsed: 21000}, nil, types.ChangeLogSlice{
+			{LogType: account.AddEventLog, Address: miner, NewVal: &types.Event{Address: miner}},
+			{LogType: account.BalanceLog, Address: miner, NewVal: *big.NewInt(1)},
+		})
+		assert.NoError(t, blockDao.SetBlock(block.Hash(), block))
+		parent = block.Hash()
+	}
+
+	block, err := blockDao.GetBlockByHeight(3)
+	assert.NoError(t, err)
+	assert.Equal(t, miner2, block.MinerAddress())
+	_, err = blockDao.GetBlockByHeight(10)
+	assert.Equal(t, ErrNotExist, err)
+
+	rows, total, err := blockDao.GetRowsByMinerWithTotal(miner2, 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, total)
+	assert.Equal(t, 2, len(rows))
+	assert.Equal(t, uint32(7), rows[0].Height)
+	assert.Equal(t, uint32(5), rows[1].Height)
+	assert.Equal(t, 1, rows[0].EventCount)
+	assert.Equal(t, uint64(21000), rows[0].GasUsed)
+	hash, err := blockDao.GetHashByHeight(6)
+	assert.NoError(t, err)
+	assert.Equal(t, hash, rows[0].ParentHash)
+
+	// [1600000003, 1600000009] contains height 1, 2, 3
+	rows, total, err = blockDao.GetRowsByTimeWithTotal(1600000003, 1600000009, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, uint32(3), rows[0].Height)
+	assert.Equal(t, uint32(1), rows[2].Height)
+	_, _, err = blockDao.GetRowsByTimeWithTotal(2, 1, 0, 10)
+	assert.Equal(t, ErrArgInvalid, err)
+
+	rows, total, err = blockDao.GetRowsByHeightWithTotal(2, 8, 5, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, total)
+	assert.Equal(t, 2, len(rows))
+	assert.Equal(t, uint32(3), rows[0].Height)
+	assert.Equal(t, uint32(2), rows[1].Height)
+	rows, total, err = blockDao.GetRowsByMinerAndTimeWithTotal(miner1, 1600000003, 1600000012, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, uint32(4), rows[0].Height)
+	assert.Equal(t, uint32(2), rows[1].Height)
+	_, total, err = blockDao.GetRowsByMinerAndTimeWithTotal(common.Address{}, 1600000003, 1600000012, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, total)
+
+	// replace a canonical block and add a new one, then revert them
+	undoDao := NewUndoDao(db)
+	assert.NoError(t, undoDao.RecordBlock(3))
+	assert.NoError(t, undoDao.RecordBlock(10))
+	fork := types.NewBlock(&types.Header{Height: 3, MinerAddress: miner1}, nil, nil)
+	assert.NoError(t, blockDao.SetBlock(fork.Hash(), fork))
+	newBlock := types.NewBlock(&types.Header{Height: 10, MinerAddress: miner1}, nil, nil)
+	assert.NoError(t, blockDao.SetBlock(newBlock.Hash(), newBlock))
+	_, total, err = blockDao.GetRowsByMinerWithTotal(miner1, 0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, total)
+	assert.NoError(t, undoDao.Revert(3))
+	assert.NoError(t, undoDao.Revert(10))
+	block, err = blockDao.GetBlockByHeight(3)
+	assert.NoError(t, err)
+	assert.Equal(t, miner2, block.MinerAddress())
+	_, err = blockDao.GetHashByHeight(10)
+	assert.Equal(t, ErrNotExist, err)
+}
+
+func TestSqlite_Events(t *testing.T) {
+	db, closeDB := newSqliteDB(t)
+	defer closeDB()
+
+	addr1 := common.HexToAddress("0x01")
+	addr2 := common.HexToAddress("0x02")
+	topicA := common.HexToHash("0x0a")
+	topicB := common.HexToHash("0x0b")
+	tx := types.NewTransaction(addr1, addr2, big.NewInt(1), 0, big.NewInt(0), nil, 0, 100, 1000, "", "")
+	block := types.NewBlock(&types.Header{Height: 5}, []*types.Transaction{tx}, types.ChangeLogSlice{
+		{LogType: account.AddEventLog, Address: addr1, NewVal: &types.Event{Address: addr1, Topics: []common.Hash{topicA}, Data: []byte{1}}},
+		{LogType: account.BalanceLog, Address: addr1, NewVal: *big.NewInt(1)},
+		{LogType: account.AddEventLog, Address: addr1, NewVal: &types.Event{Address: addr1, Topics: []common.Hash{topicA, topicB}}},
+		{LogType: account.AddEventLog, Address: addr2, NewVal: &types.Event{Address: addr2, Topics: []common.Hash{topicB}}},
+	})
+	events := NewBlockEvents(block.Hash(), block)
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, uint32(2), events[2].Index)
+	assert.Equal(t, tx.Hash(), *events[2].THash)
+
+	undoDao := NewUndoDao(db)
+	assert.NoError(t, undoDao.RecordEvents(5, len(events)))
+	eventDao := NewEventDao(db)
+	assert.NoError(t, eventDao.SetBatch(events))
+
+	// address
+	result, err := eventDao.GetByFilter(&EventFilter{FromHeight: 0, ToHeight: 5, Addresses: []common.Address{addr1}}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(result))
+	assert.Equal(t, []byte{1}, result[0].Data)
+	assert.Equal(t, block.Hash(), result[0].BHash)
+	assert.Equal(t, tx.Hash(), *result[0].THash)
+	// topics at positions
+	result, err = eventDao.GetByFilter(&EventFilter{FromHeight: 5, ToHeight: 5, Topics: [][]common.Hash{nil, {topicB}}}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(result))
+	assert.Equal(t, []common.Hash{topicA, topicB}, result[0].Topics)
+	result, err = eventDao.GetByFilter(&EventFilter{FromHeight: 0, ToHeight: 5, Topics: [][]common.Hash{{topicA, topicB}}}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(result))
+	// height range
+	result, err = eventDao.GetByFilter(&EventFilter{FromHeight: 6, ToHeight: 10}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(result))
+	_, err = eventDao.GetByFilter(&EventFilter{FromHeight: 0, ToHeight: 5}, 2)
+	assert.Equal(t, ErrTooManyEvents, err)
+	_, err = eventDao.GetByFilter(&EventFilter{FromHeight: 5, ToHeight: 4}, 2)
+	assert.Equal(t, ErrArgInvalid, err)
+
+	// the tx is unknown in block of txs
+	block = types.NewBlock(&types.Header{Height: 6}, []*types.Transaction{tx, tx}, types.ChangeLogSlice{
+		{LogType: account.AddEventLog, Address: addr1, NewVal: &types.Event{Address: addr1, Topics: []common.Hash{topicA}}},
+	})
+	events = NewBlockEvents(block.Hash(), block)
+	assert.Nil(t, events[0].THash)
+	assert.NoError(t, undoDao.RecordEvents(6, len(events)))
+	assert.NoError(t, eventDao.SetBatch(events))
+	result, err = eventDao.GetByFilter(&EventFilter{FromHeight: 6, ToHeight: 6}, 10)
+	assert.NoError(t, err)
+	assert.Nil(t, result[0].THash)
+	encoded, err := json.Marshal(result[0])
+	assert.NoError(t, err)
+	assert.Contains(t, string(encoded), `"transactionHash":null`)
+	assert.NoError(t, undoDao.Revert(6))
+
+	assert.NoError(t, undoDao.Revert(5))
+	result, err = eventDao.GetByFilter(&EventFilter{FromHeight: 0, ToHeight: 5}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(result))
+}
@@ -1,7 +1,6 @@
 package database
 
 import (
-	"database/sql"
 	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
 	"github.com/LemoFoundationLtd/lemochain-core/common"
 	"github.com/LemoFoundationLtd/lemochain-core/common/log"
@@ -11,11 +10,12 @@ import (
 )
 
 type EquityDao struct {
-	engine *sql.DB
+	engine  Executor
+	dialect Dialect
 }
 
 func NewEquityDao(db DBEngine) *EquityDao {
-	return &EquityDao{engine: db.GetDB()}
+	return &EquityDao{engine: GetExecutor(db), dialect: db.GetDialect()}
 }
 
 func (dao *EquityDao) Set(addr common.Address, assetEquity *types.AssetEquity) error {
@@ -36,6 +36,40 @@ func (dao *EquityDao) Set(addr common.Address, assetEquity *types.AssetEquity) e
 	}
 }
 
+// SetBatch save the equities of addr with multi-row statements. The version of the existed rows increases like Set.
+// If some equities have the same asset id, the last one is saved
+func (dao *EquityDao) SetBatch(addr common.Address, assetEquities []*types.AssetEquity) error {
+	if addr == (common.Address{}) {
+		log.Errorf("set equity batch.addr is common.Address{}.")
+		return ErrArgInvalid
+	}
+
+	ids := make([]common.Hash, 0, len(assetEquities))
+	latest := make(map[common.Hash]*types.AssetEquity)
+	for _, assetEquity := range assetEquities {
+		if assetEquity == nil {
+			log.Errorf("set equity batch.equity is nil.")
+			return ErrArgInvalid
+		}
+		if _, ok := latest[assetEquity.AssetId]; !ok {
+			ids = append(ids, assetEquity.AssetId)
+		}
+		latest[assetEquity.AssetId] = assetEquity
+	}
+
+	cols := []string{"code", "id", "addr", "equity", "utc_st", "version"}
+	sets := []string{"equity = " + dao.dialect.Excluded("equity"), "version = t_equity.version + 1"}
+	return forEachBatch(len(ids), len(cols), func(start, end int) error {
+		args := make([]interface{}, 0, (end-start)*len(cols))
+		for _, id := range ids[start:end] {
+			assetEquity := latest[id]
+			args = append(args, assetEquity.AssetCode.Hex(), id.Hex(), addr.Hex(), assetEquity.Equity.String(), time.Now().UnixNano()/1000000, 1)
+		}
+		_, err := dao.engine.Exec(dao.dialect.Upsert("t_equity", cols, []string{"id", "addr"}, sets, end-start), args...)
+		return err
+	})
+}
+
 func (dao *EquityDao) Get(addr common.Address, id common.Hash) (*types.AssetEquity, error) {
 	if (addr == common.Address{}) || (id == common.Hash{}) {
 		log.Errorf("get asset equity.addr is common.address{} or id is common.hash{}")
@@ -61,13 +95,13 @@ func (dao *EquityDao) GetPage(addr common.Address, start, limit int) ([]*types.A
 		return nil, ErrArgInvalid
 	}
 
-	sql := "SELECT code, id, equity, utc_st FROM t_equity WHERE addr = ? ORDER BY utc_st LIMIT ?, ?"
+	sql := "SELECT code, id, equity, utc_st FROM t_equity WHERE addr = ? ORDER BY utc_st LIMIT ? OFFSET ?"
 	stmt, err := dao.engine.Prepare(sql)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := stmt.Query(addr.Hex(), start, start+limit)
+	rows, err := stmt.Query(addr.Hex(), start+limit, start)
 	if err != nil {
 		return nil, err
 	}
@@ -124,13 +158,13 @@ func (dao *EquityDao) GetPageByCode(addr common.Address, code common.Hash, start
 		return nil, ErrArgInvalid
 	}
 
-	sql := "SELECT code, id, equity, utc_st FROM t_equity WHERE addr = ? AND code = ? ORDER BY utc_st LIMIT ?, ?"
+	sql := "SELECT code, id, equity, utc_st FROM t_equity WHERE addr = ? AND code = ? ORDER BY utc_st LIMIT ? OFFSET ?"
 	stmt, err := dao.engine.Prepare(sql)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := stmt.Query(addr.Hex(), code.Hex(), start, start+limit)
+	rows, err := stmt.Query(addr.Hex(), code.Hex(), start+limit, start)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,247 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/account"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-core/common/hexutil"
+	"github.com/LemoFoundationLtd/lemochain-core/common/log"
+	"strings"
+)
+
+// maxEventTopics is the count of topic columns in t_event. The contracts can't log more topics
+const maxEventTopics = 4
+
+var ErrTooManyEvents = errors.New("too many events are matched, narrow the filter")
+
+//go:generate gencodec -type Event --field-override eventMarshaling -out gen_event_json.go
+type Event struct {
+	Height  uint32         `json:"blockHeight" gencodec:"required"`
+	BHash   common.Hash    `json:"blockHash" gencodec:"required"`
+	Index   uint32         `json:"logIndex" gencodec:"required"` // the position in the events of block
+	THash   *common.Hash   `json:"transactionHash"`              // nil if the tx of event is unknown
+	Address common.Address `json:"address" gencodec:"required"`
+	Topics  []common.Hash  `json:"topics" gencodec:"required"`
+	Data    []byte         `json:"data" gencodec:"required"`
+}
+type eventMarshaling struct {
+	Height hexutil.Uint32
+	Index  hexutil.Uint32
+	Data   hexutil.Bytes
+}
+
+// EventFilter selects the events in a height range. The events of all contracts are selected if Addresses is empty.
+// Topics[i] is the candidates of the i-th topic, and an empty Topics[i] matches any topic
+type EventFilter struct {
+	FromHeight uint32
+	ToHeight   uint32
+	Addresses  []common.Address
+	Topics     [][]common.Hash
+}
+
+var eventColumns = []string{"height", "log_index", "bhash", "thash", "addr", "topic0", "topic1", "topic2", "topic3", "data"}
+
+// NewBlockEvents collect the events from the change logs of block. The change logs are grouped by address and don't
+// record the tx of event, so the events are indexed in the order of change logs instead of the order they were logged.
+// The tx of an event is known only if the block has one tx, otherwise THash is nil
+func NewBlockEvents(hash common.Hash, block *types.Block) []*Event {
+	var txHash *common.Hash
+	if len(block.Txs) == 1 {
+		thash := block.Txs[0].Hash()
+		txHash = &thash
+	}
+
+	result := make([]*Event, 0)
+	for _, cl := range block.ChangeLogs {
+		if cl.LogType != account.AddEventLog {
+			continue
+		}
+		event, ok := cl.NewVal.(*types.Event)
+		if !ok || event == nil {
+			continue
+		}
+		result = append(result, &Event{
+			Height:  block.Height(),
+			BHash:   hash,
+			Index:   uint32(len(result)),
+			THash:   txHash,
+			Address: event.Address,
+			Topics:  event.Topics,
+			Data:    event.Data,
+		})
+	}
+	return result
+}
+
+type EventDao struct {
+	engine  Executor
+	dialect Dialect
+}
+
+func NewEventDao(db DBEngine) *EventDao {
+	return &EventDao{engine: GetExecutor(db), dialect: db.GetDialect()}
+}
+
+// SetBatch save the events with multi-row statements
+func (dao *EventDao) SetBatch(events []*Event) error {
+	for _, event := range events {
+		if event == nil || len(event.Topics) > maxEventTopics {
+			log.Errorf("set event batch. event is nil or it has more than %d topics.", maxEventTopics)
+			return ErrArgInvalid
+		}
+	}
+
+	return forEachBatch(len(events), len(eventColumns), func(start, end int) error {
+		args := make([]interface{}, 0, (end-start)*len(eventColumns))
+		for _, event := range events[start:end] {
+			topics := make([]interface{}, maxEventTopics)
+			for i := range topics {
+				topics[i] = ""
+				if i < len(event.Topics) {
+					topics[i] = event.Topics[i].Hex()
+				}
+			}
+			data := event.Data
+			if data == nil {
+				data = []byte{}
+			}
+			thash := ""
+			if event.THash != nil {
+				thash = event.THash.Hex()
+			}
+			args = append(args, int64(event.Height), event.Index, event.BHash.Hex(), thash, event.Address.Hex())
+			args = append(args, topics...)
+			args = append(args, data)
+		}
+		_, err := dao.engine.Exec(dao.dialect.ReplaceRows("t_event", eventColumns, []string{"height", "log_index"}, end-start), args...)
+		return err
+	})
+}
+
+// GetByFilter return the events matched by filter, ordered by height and log index. It returns ErrTooManyEvents if
+// there are more than limit events
+func (dao *EventDao) GetByFilter(filter *EventFilter, limit int) ([]*Event, error) {
+	if filter == nil || (filter.FromHeight > filter.ToHeight) || len(filter.Topics) > maxEventTopics || (limit <= 0) {
+		log.Errorf("get events by filter. filter is nil or from > to or too many topics or limit <= 0")
+		return nil, ErrArgInvalid
+	}
+
+	conditions := []string{"height >= ?", "height <= ?"}
+	args := []interface{}{int64(filter.FromHeight), int64(filter.ToHeight)}
+	if len(filter.Addresses) > 0 {
+		conditions = append(conditions, "addr IN ("+placeholders(len(filter.Addresses))+")")
+		for _, address := range filter.Addresses {
+			args = append(args, address.Hex())
+		}
+	}
+	for i, topics := range filter.Topics {
+		if len(topics) == 0 {
+			continue
+		}
+		conditions = append(conditions, eventColumns[5+i]+" IN ("+placeholders(len(topics))+")")
+		for _, topic := range topics {
+			args = append(args, topic.Hex())
+		}
+	}
+
+	sqlQuery := "SELECT height, log_index, bhash, thash, addr, topic0, topic1, topic2, topic3, data FROM t_event WHERE " + strings.Join(conditions, " AND ") + " ORDER BY height, log_index LIMIT ?"
+	rows, err := dao.engine.Query(sqlQuery, append(args, limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result, err := scanEvents(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) > limit {
+		return nil, ErrTooManyEvents
+	}
+	return result, nil
+}
+
+func scanEvents(rows *sql.Rows) ([]*Event, error) {
+	result := make([]*Event, 0)
+	for rows.Next() {
+		var height int64
+		var index uint32
+		var bhash, thash, addr string
+		topics := make([]string, maxEventTopics)
+		var data []byte
+		if err := rows.Scan(&height, &index, &bhash, &thash, &addr, &topics[0], &topics[1], &topics[2], &topics[3], &data); err != nil {
+			return nil, err
+		}
+
+		event := &Event{
+			Height:  uint32(height),
+			BHash:   common.HexToHash(bhash),
+			Index:   index,
+			Address: common.HexToAddress(addr),
+			Topics:  make([]common.Hash, 0, maxEventTopics),
+			Data:    data,
+		}
+		// the events saved before are stored with zero hash if the tx is unknown
+		if hash := common.HexToHash(thash); thash != "" && hash != (common.Hash{}) {
+			event.THash = &hash
+		}
+		for _, topic := range topics {
+			if topic == "" {
+				break
+			}
+			event.Topics = append(event.Topics, common.HexToHash(topic))
+		}
+		result = append(result, event)
+	}
+	return result, rows.Err()
+}
+
+// fillEventTable save the events of the blocks in t_block, which were saved before t_event is created
+func fillEventTable(db DBEngine) error {
+	engine := GetExecutor(db)
+	blockDao := NewBlockDao(db)
+	eventDao := NewEventDao(db)
+	next := int64(0)
+	count := 0
+	for {
+		rows, err := engine.Query("SELECT height, bhash FROM t_block WHERE height >= ? AND event_count > 0 ORDER BY height LIMIT ?", next, blockFillBatch)
+		if err != nil {
+			return err
+		}
+		// read all the hashes before querying blocks, the connection is busy until rows is closed
+		hashes := make([]common.Hash, 0, blockFillBatch)
+		for rows.Next() {
+			var height int64
+			var hash string
+			if err := rows.Scan(&height, &hash); err != nil {
+				rows.Close()
+				return err
+			}
+			hashes = append(hashes, common.HexToHash(hash))
+			next = height + 1
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(hashes) == 0 {
+			return nil
+		}
+
+		events := make([]*Event, 0)
+		for _, hash := range hashes {
+			block, err := blockDao.GetBlock(hash)
+			if err != nil {
+				return err
+			}
+			events = append(events, NewBlockEvents(hash, block)...)
+		}
+		if err := eventDao.SetBatch(events); err != nil {
+			return err
+		}
+		count += len(events)
+		log.Infof("move %d events into t_event", count)
+	}
+}
@@ -1,7 +1,6 @@
 package database
 
 import (
-	"database/sql"
 	"encoding/binary"
 	"github.com/LemoFoundationLtd/lemochain-core/common"
 	"github.com/LemoFoundationLtd/lemochain-core/common/log"
@@ -68,11 +67,12 @@ func GetStorageKey(hash common.Hash) []byte {
  * （3） address => account
  */
 type KvDao struct {
-	engine *sql.DB
+	engine  Executor
+	dialect Dialect
 }
 
 func NewKvDao(db DBEngine) *KvDao {
-	return &KvDao{engine: db.GetDB()}
+	return &KvDao{engine: GetExecutor(db), dialect: db.GetDialect()}
 }
 
 func (dao *KvDao) Get(key []byte) ([]byte, error) {
@@ -101,7 +101,7 @@ func (dao *KvDao) Set(key []byte, val []byte) error {
 		return ErrArgInvalid
 	}
 
-	result, err := dao.engine.Exec("REPLACE INTO t_kv(lm_key, lm_val) VALUES (?,?)", common.ToHex(key), val)
+	result, err := dao.engine.Exec(dao.dialect.Replace("t_kv", []string{"lm_key", "lm_val"}, []string{"lm_key"}), common.ToHex(key), val)
 	if err != nil {
 		return err
 	}
@@ -118,3 +118,35 @@ func (dao *KvDao) Set(key []byte, val []byte) error {
 
 	return nil
 }
+
+// SetBatch save the values of keys with multi-row statements. If some keys are the same, the last value is saved
+func (dao *KvDao) SetBatch(keys [][]byte, vals [][]byte) error {
+	if len(keys) != len(vals) {
+		log.Errorf("set k/v batch. the count of keys and values are different.")
+		return ErrArgInvalid
+	}
+
+	hexKeys := make([]string, 0, len(keys))
+	latest := make(map[string][]byte)
+	for i, key := range keys {
+		if len(key) <= 0 {
+			log.Errorf("set k/v batch. key is nil.")
+			return ErrArgInvalid
+		}
+		hexKey := common.ToHex(key)
+		if _, ok := latest[hexKey]; !ok {
+			hexKeys = append(hexKeys, hexKey)
+		}
+		latest[hexKey] = vals[i]
+	}
+
+	cols := []string{"lm_key", "lm_val"}
+	return forEachBatch(len(hexKeys), len(cols), func(start, end int) error {
+		args := make([]interface{}, 0, (end-start)*len(cols))
+		for _, hexKey := range hexKeys[start:end] {
+			args = append(args, hexKey, latest[hexKey])
+		}
+		_, err := dao.engine.Exec(dao.dialect.ReplaceRows("t_kv", cols, []string{"lm_key"}, end-start), args...)
+		return err
+	})
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"bytes"
+	"github.com/LemoFoundationLtd/lemochain-core/common/rlp"
+	"time"
+)
+
+// Lease is held by one node until Expire, so that only one node writes the database
+type Lease struct {
+	Owner  string
+	Expire uint64 // unix milliseconds
+}
+
+// LeaseDao store the leases in t_context. The lease is changed by compare-and-swap, so it works without row lock.
+// The expiration is compared with the database clock, so the clock skew between nodes doesn't matter
+type LeaseDao struct {
+	engine  Executor
+	dialect Dialect
+}
+
+func NewLeaseDao(db DBEngine) *LeaseDao {
+	return &LeaseDao{engine: GetExecutor(db), dialect: db.GetDialect()}
+}
+
+// Now return the unix milliseconds of the database clock
+func (dao *LeaseDao) Now() (uint64, error) {
+	var now uint64
+	err := dao.engine.QueryRow(dao.dialect.Now()).Scan(&now)
+	return now, err
+}
+
+func (dao *LeaseDao) Get(key string) (*Lease, error) {
+	val, err := dao.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if val == nil {
+		return nil, ErrNotExist
+	}
+	var lease Lease
+	if err := rlp.DecodeBytes(val, &lease); err != nil {
+		return nil, err
+	}
+	return &lease, nil
+}
+
+// Acquire take the lease until now+ttl if it is free, expired or held by owner already. It returns false if another owner holds the lease
+func (dao *LeaseDao) Acquire(key string, owner string, ttl time.Duration) (bool, error) {
+	now, err := dao.Now()
+	if err != nil {
+		return false, err
+	}
+	return dao.acquire(key, owner, now, ttl)
+}
+
+// acquire is Acquire at now in unix milliseconds
+func (dao *LeaseDao) acquire(key string, owner string, now uint64, ttl time.Duration) (bool, error) {
+	newVal, err := rlp.EncodeToBytes(&Lease{Owner: owner, Expire: now + uint64(ttl/time.Millisecond)})
+	if err != nil {
+		return false, err
+	}
+
+	oldVal, err := dao.get(key)
+	if err != nil {
+		return false, err
+	}
+	if oldVal == nil {
+		if _, err := dao.engine.Exec("INSERT INTO t_context(lm_key, lm_val) VALUES (?,?)", key, newVal); err != nil {
+			// another node inserted the lease at the same time
+			if lease, getErr := dao.Get(key); getErr == nil && lease.Owner != owner {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+
+	var lease Lease
+	if err := rlp.DecodeBytes(oldVal, &lease); err != nil {
+		return false, err
+	}
+	if lease.Owner != owner && lease.Expire > now {
+		return false, nil
+	}
+	if bytes.Equal(oldVal, newVal) {
+		return true, nil
+	}
+	return dao.swap(key, oldVal, newVal)
+}
+
+// Hold test if owner holds an unexpired lease. In a transaction, the lease row is locked until the transaction ends, so
+// that the lease can't be taken over by another node before the writes of the transaction are committed
+func (dao *LeaseDao) Hold(key string, owner string) (bool, error) {
+	row := dao.engine.QueryRow("SELECT lm_val FROM t_context WHERE lm_key = ?"+dao.dialect.ForUpdate(), key)
+	var val []byte
+	if err := row.Scan(&val); ErrIsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	var lease Lease
+	if err := rlp.DecodeBytes(val, &lease); err != nil {
+		return false, err
+	}
+	if lease.Owner != owner {
+		return false, nil
+	}
+	now, err := dao.Now()
+	if err != nil {
+		return false, err
+	}
+	return lease.Expire > now, nil
+}
+
+// Release expire the lease if it is held by owner, so that another node can take it at once
+func (dao *LeaseDao) Release(key string, owner string) error {
+	oldVal, err := dao.get(key)
+	if err != nil || oldVal == nil {
+		return err
+	}
+	var lease Lease
+	if err := rlp.DecodeBytes(oldVal, &lease); err != nil {
+		return err
+	}
+	if lease.Owner != owner {
+		return nil
+	}
+	newVal, err := rlp.EncodeToBytes(&Lease{Owner: owner, Expire: 0})
+	if err != nil {
+		return err
+	}
+	_, err = dao.swap(key, oldVal, newVal)
+	return err
+}
+
+func (dao *LeaseDao) get(key string) ([]byte, error) {
+	row := dao.engine.QueryRow("SELECT lm_val FROM t_context WHERE lm_key = ?", key)
+	var val []byte
+	err := row.Scan(&val)
+	if ErrIsNotExist(err) {
+		return nil, nil
+	}
+	return val, err
+}
+
+// swap update the value only if it is not changed by others
+func (dao *LeaseDao) swap(key string, oldVal, newVal []byte) (bool, error) {
+	result, err := dao.engine.Exec("UPDATE t_context SET lm_val = ? WHERE lm_key = ? AND lm_val = ?", newVal, key, oldVal)
+	if err != nil {
+		return false, err
+	}
+	effected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return effected == 1, nil
+}
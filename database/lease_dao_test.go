@@ -0,0 +1,92 @@
+package database
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLeaseDao_Acquire(t *testing.T) {
+	db, closeDB := newSqliteDB(t)
+	defer closeDB()
+
+	leaseDao := NewLeaseDao(db)
+	now := uint64(1000000)
+	ttl := 10 * time.Second
+	second := uint64(1000)
+
+	_, err := leaseDao.Get(ContextKeyWriterLease)
+	assert.Equal(t, ErrNotExist, err)
+
+	// free
+	ok, err := leaseDao.acquire(ContextKeyWriterLease, "node1", now, ttl)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	lease, err := leaseDao.Get(ContextKeyWriterLease)
+	assert.NoError(t, err)
+	assert.Equal(t, "node1", lease.Owner)
+
+	// held by others
+	ok, err = leaseDao.acquire(ContextKeyWriterLease, "node2", now+second, ttl)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// renew
+	ok, err = leaseDao.acquire(ContextKeyWriterLease, "node1", now+second, ttl)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// expired
+	ok, err = leaseDao.acquire(ContextKeyWriterLease, "node2", now+12*second, ttl)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = leaseDao.acquire(ContextKeyWriterLease, "node1", now+13*second, ttl)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// release
+	assert.NoError(t, leaseDao.Release(ContextKeyWriterLease, "node1"))
+	ok, err = leaseDao.acquire(ContextKeyWriterLease, "node1", now+13*second, ttl)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, leaseDao.Release(ContextKeyWriterLease, "node2"))
+	ok, err = leaseDao.acquire(ContextKeyWriterLease, "node1", now+13*second, ttl)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestLeaseDao_Hold(t *testing.T) {
+	db, closeDB := newSqliteDB(t)
+	defer closeDB()
+
+	leaseDao := NewLeaseDao(db)
+	now, err := leaseDao.Now()
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Now().UnixNano()/int64(time.Millisecond), int64(now), float64(time.Minute/time.Millisecond))
+
+	ok, err := leaseDao.Hold(ContextKeyWriterLease, "node1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// held at the database clock
+	ok, err = leaseDao.Acquire(ContextKeyWriterLease, "node1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	txEngine, err := BeginTx(db)
+	assert.NoError(t, err)
+	ok, err = NewLeaseDao(txEngine).Hold(ContextKeyWriterLease, "node1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, txEngine.Rollback())
+	ok, err = leaseDao.Hold(ContextKeyWriterLease, "node2")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// expired
+	ok, err = leaseDao.acquire(ContextKeyWriterLease, "node1", now-uint64(time.Hour/time.Millisecond), time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = leaseDao.Hold(ContextKeyWriterLease, "node1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,248 @@
+package database
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/LemoFoundationLtd/lemochain-core/common/log"
+	"github.com/LemoFoundationLtd/lemochain-core/common/rlp"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationFiles contains "migrations/<driver>/<version>_<name>.<up|down>.sql"
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+var ErrMigrationFileName = errors.New("invalid migration file name")
+
+// Migration is a schema change which can be applied and rolled back
+type Migration struct {
+	Version uint32
+	Name    string
+	Up      string
+	Down    string
+
+	afterUp    func(db DBEngine) error // move the data into the new schema
+	beforeDown func(db DBEngine) error // move the data back before the new schema is dropped
+}
+
+// migrationData convert the data which can't be converted by sql statements, such as the fields decoded from blobs
+var migrationData = map[uint32]struct{ afterUp, beforeDown func(db DBEngine) error }{
+	4: {afterUp: fillBlockTable, beforeDown: restoreCanonicalKeys},
+	5: {afterUp: fillEventTable},
+}
+
+// Migrations load the migrations of the dialect, ordered by version
+func Migrations(dialect Dialect) ([]*Migration, error) {
+	dir := path.Join("migrations", dialect.Name())
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make(map[uint32]*Migration)
+	for _, entry := range entries {
+		// 0001_init.up.sql
+		parts := strings.SplitN(strings.TrimSuffix(entry.Name(), ".sql"), ".", 2)
+		underscore := strings.Index(parts[0], "_")
+		if len(parts) != 2 || underscore <= 0 {
+			return nil, ErrMigrationFileName
+		}
+		version, err := strconv.ParseUint(parts[0][:underscore], 10, 32)
+		if err != nil || version == 0 {
+			return nil, ErrMigrationFileName
+		}
+		content, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := migrations[uint32(version)]
+		if !ok {
+			m = &Migration{Version: uint32(version), Name: parts[0][underscore+1:]}
+			if data, ok := migrationData[m.Version]; ok {
+				m.afterUp, m.beforeDown = data.afterUp, data.beforeDown
+			}
+			migrations[m.Version] = m
+		}
+		switch parts[1] {
+		case "up":
+			m.Up = string(content)
+		case "down":
+			m.Down = string(content)
+		default:
+			return nil, ErrMigrationFileName
+		}
+	}
+
+	result := make([]*Migration, 0, len(migrations))
+	for _, m := range migrations {
+		result = append(result, m)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+	for i, m := range result {
+		if m.Version != uint32(i+1) {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+	return result, nil
+}
+
+// SchemaVersion return the version of the schema in database. It is 0 if the database is empty, or the tables were created by hand
+func SchemaVersion(db DBEngine) (uint32, error) {
+	var count int
+	if err := GetExecutor(db).QueryRow(db.GetDialect().TableExists(), "t_context").Scan(&count); err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, nil
+	}
+
+	val, err := NewContextDao(db).ContextGet(ContextKeySchemaVersion)
+	if err != nil || val == nil {
+		return 0, err
+	}
+	var version uint32
+	if err := rlp.DecodeBytes(val, &version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// MigrateUp apply the migrations until the schema reaches version
+func MigrateUp(db DBEngine, version uint32) error {
+	migrations, err := Migrations(db.GetDialect())
+	if err != nil {
+		return err
+	}
+	if version > uint32(len(migrations)) {
+		return fmt.Errorf("migration %d does not exist", version)
+	}
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current || m.Version > version {
+			continue
+		}
+		if err := migrate(db, nil, m.Up, m.afterUp, m.Version); err != nil {
+			return fmt.Errorf("apply migration %d_%s failed: %v", m.Version, m.Name, err)
+		}
+		log.Infof("apply migration %d_%s", m.Version, m.Name)
+	}
+	return nil
+}
+
+// MigrateDown roll back the migrations until the schema reaches version
+func MigrateDown(db DBEngine, version uint32) error {
+	migrations, err := Migrations(db.GetDialect())
+	if err != nil {
+		return err
+	}
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current > uint32(len(migrations)) {
+		return ErrSchemaTooNew
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version > current || m.Version <= version {
+			continue
+		}
+		if err := migrate(db, m.beforeDown, m.Down, nil, m.Version-1); err != nil {
+			return fmt.Errorf("roll back migration %d_%s failed: %v", m.Version, m.Name, err)
+		}
+		log.Infof("roll back migration %d_%s", m.Version, m.Name)
+	}
+	return nil
+}
+
+// CreateDB create the tables or upgrade them to the latest schema. It refuses the schema which is newer than this program
+func CreateDB(db DBEngine) error {
+	migrations, err := Migrations(db.GetDialect())
+	if err != nil {
+		return err
+	}
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current > uint32(len(migrations)) {
+		log.Errorf("database schema version is %d, but the latest known version is %d", current, len(migrations))
+		return ErrSchemaTooNew
+	}
+	return MigrateUp(db, uint32(len(migrations)))
+}
+
+// CheckSchema test if the schema is the latest version without changing it. It is used by the node which doesn't own the database
+func CheckSchema(db DBEngine) error {
+	migrations, err := Migrations(db.GetDialect())
+	if err != nil {
+		return err
+	}
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current > uint32(len(migrations)) {
+		return ErrSchemaTooNew
+	}
+	if current < uint32(len(migrations)) {
+		return ErrSchemaTooOld
+	}
+	return nil
+}
+
+// migrate run the statements between the data functions, and set schema version in one transaction. Note that mysql
+// commits DDL implicitly
+func migrate(db DBEngine, before func(db DBEngine) error, statements string, after func(db DBEngine) error, version uint32) error {
+	txEngine, err := BeginTx(db)
+	if err != nil {
+		return err
+	}
+	defer txEngine.Rollback()
+
+	if before != nil {
+		if err := before(txEngine); err != nil {
+			return err
+		}
+	}
+
+	for _, statement := range strings.Split(statements, ";") {
+		statement = strings.TrimSpace(statement)
+		if len(statement) == 0 {
+			continue
+		}
+		if _, err := txEngine.GetTx().Exec(statement); err != nil {
+			return err
+		}
+	}
+	if after != nil {
+		if err := after(txEngine); err != nil {
+			return err
+		}
+	}
+
+	// the t_context is dropped by the first migration
+	if version > 0 {
+		val, err := rlp.EncodeToBytes(version)
+		if err != nil {
+			return err
+		}
+		if err := NewContextDao(txEngine).ContextSet(ContextKeySchemaVersion, val); err != nil {
+			return err
+		}
+	}
+	return txEngine.Commit()
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"github.com/LemoFoundationLtd/lemochain-core/chain/account"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-core/common/rlp"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	for _, driver := range []string{DRIVER_MYSQL, DRIVER_POSTGRES, DRIVER_SQLITE3} {
+		dialect, _ := NewDialect(driver)
+		migrations, err := Migrations(dialect)
+		assert.NoError(t, err)
+		assert.Equal(t, 5, len(migrations))
+		for i, m := range migrations {
+			assert.Equal(t, uint32(i+1), m.Version)
+			assert.NotEmpty(t, m.Up)
+			assert.NotEmpty(t, m.Down)
+		}
+	}
+}
+
+func TestCreateDB(t *testing.T) {
+	db, closeDB := newSqliteDB(t)
+	defer closeDB()
+
+	version, err := SchemaVersion(db)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(5), version)
+
+	// run again
+	assert.NoError(t, CreateDB(db))
+	version, err = SchemaVersion(db)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(5), version)
+
+	assert.NoError(t, CheckSchema(db))
+	assert.NoError(t, MigrateDown(db, 1))
+	assert.Equal(t, ErrSchemaTooOld, CheckSchema(db))
+	assert.NoError(t, CreateDB(db))
+
+	// newer schema
+	val, _ := rlp.EncodeToBytes(uint32(100))
+	assert.NoError(t, NewContextDao(db).ContextSet(ContextKeySchemaVersion, val))
+	assert.Equal(t, ErrSchemaTooNew, CreateDB(db))
+	assert.Equal(t, ErrSchemaTooNew, CheckSchema(db))
+}
+
+func TestMigrateDown(t *testing.T) {
+	db, closeDB := newSqliteDB(t)
+	defer closeDB()
+	assert.NoError(t, NewKvDao(db).Set([]byte("key"), []byte("val")))
+
+	assert.NoError(t, MigrateDown(db, 1))
+	version, err := SchemaVersion(db)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), version)
+	_, err = NewUndoDao(db).OldestHeight()
+	assert.Error(t, err)
+
+	assert.NoError(t, MigrateDown(db, 0))
+	version, err = SchemaVersion(db)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), version)
+
+	// create again
+	assert.NoError(t, MigrateUp(db, 5))
+	val, err := NewKvDao(db).Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+	assert.Error(t, MigrateUp(db, 6))
+}
+
+func TestMigrateBlockTable(t *testing.T) {
+	db, closeDB := newSqliteDB(t)
+	defer closeDB()
+	assert.NoError(t, MigrateDown(db, 3))
+
+	// the blocks indexed by t_kv before version 4
+	kvDao := NewKvDao(db)
+	blocks := make([]*types.Block, 0)
+	for height := uint32(0); height < 3; height++ {
+		block := types.NewBlock(&types.Header{Height: height, Time: height}, nil, nil)
+		val, err := rlp.EncodeToBytes(block)
+		assert.NoError(t, err)
+		assert.NoError(t, kvDao.Set(GetCanonicalKey(height), block.Hash().Bytes()))
+		assert.NoError(t, kvDao.Set(GetBlockHashKey(block.Hash()), val))
+		blocks = append(blocks, block)
+	}
+	// a canonical key whose block is lost
+	assert.NoError(t, kvDao.Set(GetCanonicalKey(3), common.HexToHash("0x03").Bytes()))
+
+	assert.NoError(t, MigrateUp(db, 4))
+	blockDao := NewBlockDao(db)
+	for _, block := range blocks {
+		result, err := blockDao.GetBlockByHeight(block.Height())
+		assert.NoError(t, err)
+		assert.Equal(t, block.Hash(), result.Hash())
+	}
+	_, err := blockDao.GetHashByHeight(3)
+	assert.Equal(t, ErrNotExist, err)
+	val, err := kvDao.Get(GetCanonicalKey(0))
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+	val, err = kvDao.Get(GetBlockHashKey(blocks[0].Hash()))
+	assert.NoError(t, err)
+	assert.NotNil(t, val)
+
+	// the index is moved back
+	assert.NoError(t, MigrateDown(db, 3))
+	val, err = kvDao.Get(GetCanonicalKey(2))
+	assert.NoError(t, err)
+	assert.Equal(t, blocks[2].Hash().Bytes(), val)
+}
+
+func TestMigrateEventTable(t *testing.T) {
+	db, closeDB := newSqliteDB(t)
+	defer closeDB()
+	assert.NoError(t, MigrateDown(db, 4))
+
+	// the blocks saved before version 5
+	addr := common.HexToAddress("0x01")
+	blockDao := NewBlockDao(db)
+	for height := uint32(0); height < 3; height++ {
+		logs := types.ChangeLogSlice{{LogType: account.AddEventLog, Address: addr, Version: 1, NewVal: &types.Event{Address: addr, Topics: []common.Hash{common.HexToHash("0x02")}}}}
+		if height == 1 {
+			logs = nil
+		}
+		block := types.NewBlock(&types.Header{Height: height}, nil, logs)
+		assert.NoError(t, blockDao.SetBlock(block.Hash(), block))
+	}
+
+	assert.NoError(t, MigrateUp(db, 5))
+	events, err := NewEventDao(db).GetByFilter(&EventFilter{FromHeight: 0, ToHeight: 2}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, uint32(0), events[0].Height)
+	assert.Equal(t, uint32(2), events[1].Height)
+	assert.Equal(t, []common.Hash{common.HexToHash("0x02")}, events[1].Topics)
+}
@@ -2,7 +2,7 @@
 /*   DatabaseName = lemochain   */
 /*   TableName = t_asset   */
 /******************************************/
-CREATE TABLE `t_asset` (
+CREATE TABLE IF NOT EXISTS `t_asset` (
   `code` varchar(128) NOT NULL,
   `addr` varchar(128) NOT NULL,
   `attrs` blob NOT NULL,
@@ -17,7 +17,7 @@ CREATE TABLE `t_asset` (
 /*   DatabaseName = lemochain   */
 /*   TableName = t_candidates   */
 /******************************************/
-CREATE TABLE `t_candidates` (
+CREATE TABLE IF NOT EXISTS `t_candidates` (
   `addr` varchar(128) NOT NULL,
   `votes` bigint(20) NOT NULL,
   `st` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
@@ -29,7 +29,7 @@ CREATE TABLE `t_candidates` (
 /*   DatabaseName = lemochain   */
 /*   TableName = t_context   */
 /******************************************/
-CREATE TABLE `t_context` (
+CREATE TABLE IF NOT EXISTS `t_context` (
   `lm_key` varchar(128) NOT NULL,
   `lm_val` blob NOT NULL,
   `st` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
@@ -41,7 +41,7 @@ CREATE TABLE `t_context` (
 /*   DatabaseName = lemochain   */
 /*   TableName = t_equity   */
 /******************************************/
-CREATE TABLE `t_equity` (
+CREATE TABLE IF NOT EXISTS `t_equity` (
   `code` varchar(128) NOT NULL,
   `id` varchar(128) NOT NULL,
   `addr` varchar(128) NOT NULL,
@@ -57,7 +57,7 @@ CREATE TABLE `t_equity` (
 /*   DatabaseName = lemochain   */
 /*   TableName = t_kv   */
 /******************************************/
-CREATE TABLE `t_kv` (
+CREATE TABLE IF NOT EXISTS `t_kv` (
   `lm_key` varchar(128) NOT NULL,
   `lm_val` mediumblob NOT NULL,
   `st` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
@@ -69,7 +69,7 @@ CREATE TABLE `t_kv` (
 /*   DatabaseName = lemochain   */
 /*   TableName = t_meta_data   */
 /******************************************/
-CREATE TABLE `t_meta_data` (
+CREATE TABLE IF NOT EXISTS `t_meta_data` (
   `id` varchar(128) NOT NULL,
   `code` varchar(128) NOT NULL,
   `addr` varchar(128) NOT NULL,
@@ -85,7 +85,7 @@ CREATE TABLE `t_meta_data` (
 /*   DatabaseName = lemochain   */
 /*   TableName = t_tx   */
 /******************************************/
-CREATE TABLE `t_tx` (
+CREATE TABLE IF NOT EXISTS `t_tx` (
   `thash` varchar(128) NOT NULL,
   `phash` varchar(128) NOT NULL,
   `bhash` varchar(128) NOT NULL,
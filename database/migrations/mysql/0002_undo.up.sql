@@ -0,0 +1,14 @@
+/******************************************/
+/*   DatabaseName = lemochain   */
+/*   TableName = t_undo   */
+/******************************************/
+CREATE TABLE IF NOT EXISTS `t_undo` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `height` bigint(20) NOT NULL,
+  `tbl` varchar(32) NOT NULL,
+  `row_data` mediumblob NOT NULL,
+  `st` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_height` (`height`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8
+;
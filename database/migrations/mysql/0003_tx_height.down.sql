@@ -0,0 +1 @@
+DROP INDEX `idx_tx_height` ON `t_tx`;
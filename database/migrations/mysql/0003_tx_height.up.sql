@@ -0,0 +1,5 @@
+/******************************************/
+/*   DatabaseName = lemochain   */
+/*   TableName = t_tx   */
+/******************************************/
+CREATE INDEX `idx_tx_height` ON `t_tx` (`height`);
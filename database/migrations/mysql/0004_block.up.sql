@@ -0,0 +1,20 @@
+/******************************************/
+/*   DatabaseName = lemochain   */
+/*   TableName = t_block   */
+/******************************************/
+CREATE TABLE IF NOT EXISTS `t_block` (
+  `height` bigint(20) NOT NULL,
+  `bhash` varchar(128) NOT NULL,
+  `parent_hash` varchar(128) NOT NULL,
+  `miner` varchar(128) NOT NULL,
+  `block_time` bigint(20) NOT NULL,
+  `tx_count` int(11) NOT NULL,
+  `gas_used` bigint(20) NOT NULL,
+  `event_count` int(11) NOT NULL,
+  `st` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`height`),
+  UNIQUE KEY `idx_block_hash` (`bhash`),
+  KEY `idx_block_miner` (`miner`, `height`),
+  KEY `idx_block_time` (`block_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8
+;
@@ -0,0 +1,22 @@
+/******************************************/
+/*   DatabaseName = lemochain   */
+/*   TableName = t_event   */
+/******************************************/
+CREATE TABLE IF NOT EXISTS `t_event` (
+  `height` bigint(20) NOT NULL,
+  `log_index` int(11) NOT NULL,
+  `bhash` varchar(128) NOT NULL,
+  `thash` varchar(128) NOT NULL,
+  `addr` varchar(128) NOT NULL,
+  `topic0` varchar(128) NOT NULL DEFAULT '',
+  `topic1` varchar(128) NOT NULL DEFAULT '',
+  `topic2` varchar(128) NOT NULL DEFAULT '',
+  `topic3` varchar(128) NOT NULL DEFAULT '',
+  `data` blob NOT NULL,
+  `st` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`height`, `log_index`),
+  KEY `idx_event_addr` (`addr`, `height`),
+  KEY `idx_event_topic0` (`topic0`, `height`),
+  KEY `idx_event_thash` (`thash`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8
+;
@@ -0,0 +1,97 @@
+/******************************************/
+/*   DatabaseName = lemochain   */
+/*   TableName = t_asset   */
+/******************************************/
+CREATE TABLE IF NOT EXISTS "t_asset" (
+  "code" varchar(128) NOT NULL,
+  "addr" varchar(128) NOT NULL,
+  "attrs" bytea NOT NULL,
+  "version" integer NOT NULL,
+  "utc_st" bigint NOT NULL,
+  "st" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY ("code")
+);
+
+/******************************************/
+/*   DatabaseName = lemochain   */
+/*   TableName = t_candidates   */
+/******************************************/
+CREATE TABLE IF NOT EXISTS "t_candidates" (
+  "addr" varchar(128) NOT NULL,
+  "votes" bigint NOT NULL,
+  "st" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY ("addr")
+);
+
+/******************************************/
+/*   DatabaseName = lemochain   */
+/*   TableName = t_context   */
+/******************************************/
+CREATE TABLE IF NOT EXISTS "t_context" (
+  "lm_key" varchar(128) NOT NULL,
+  "lm_val" bytea NOT NULL,
+  "st" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY ("lm_key")
+);
+
+/******************************************/
+/*   DatabaseName = lemochain   */
+/*   TableName = t_equity   */
+/******************************************/
+CREATE TABLE IF NOT EXISTS "t_equity" (
+  "code" varchar(128) NOT NULL,
+  "id" varchar(128) NOT NULL,
+  "addr" varchar(128) NOT NULL,
+  "equity" varchar(128) NOT NULL,
+  "version" integer NOT NULL,
+  "utc_st" bigint NOT NULL,
+  "st" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY ("id","addr")
+);
+
+/******************************************/
+/*   DatabaseName = lemochain   */
+/*   TableName = t_kv   */
+/******************************************/
+CREATE TABLE IF NOT EXISTS "t_kv" (
+  "lm_key" varchar(128) NOT NULL,
+  "lm_val" bytea NOT NULL,
+  "st" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY ("lm_key")
+);
+
+/******************************************/
+/*   DatabaseName = lemochain   */
+/*   TableName = t_meta_data   */
+/******************************************/
+CREATE TABLE IF NOT EXISTS "t_meta_data" (
+  "id" varchar(128) NOT NULL,
+  "code" varchar(128) NOT NULL,
+  "addr" varchar(128) NOT NULL,
+  "attrs" bytea NOT NULL,
+  "version" integer NOT NULL,
+  "utc_st" bigint NOT NULL,
+  "st" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY ("id")
+);
+
+/******************************************/
+/*   DatabaseName = lemochain   */
+/*   TableName = t_tx   */
+/******************************************/
+CREATE TABLE IF NOT EXISTS "t_tx" (
+  "thash" varchar(128) NOT NULL,
+  "phash" varchar(128) NOT NULL,
+  "bhash" varchar(128) NOT NULL,
+  "height" bigint NOT NULL,
+  "faddr" varchar(128) NOT NULL,
+  "taddr" varchar(128) NOT NULL,
+  "tx" bytea NOT NULL,
+  "flag" integer NOT NULL,
+  "utc_st" bigint NOT NULL,
+  "st" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "package_time" bigint DEFAULT NULL,
+  "asset_code" varchar(128) DEFAULT NULL,
+  "asset_id" varchar(128) DEFAULT NULL,
+  PRIMARY KEY ("thash")
+);
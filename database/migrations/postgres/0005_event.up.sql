@@ -0,0 +1,21 @@
+/******************************************/
+/*   DatabaseName = lemochain   */
+/*   TableName = t_event   */
+/******************************************/
+CREATE TABLE IF NOT EXISTS "t_event" (
+  "height" bigint NOT NULL,
+  "log_index" integer NOT NULL,
+  "bhash" varchar(128) NOT NULL,
+  "thash" varchar(128) NOT NULL,
+  "addr" varchar(128) NOT NULL,
+  "topic0" varchar(128) NOT NULL DEFAULT '',
+  "topic1" varchar(128) NOT NULL DEFAULT '',
+  "topic2" varchar(128) NOT NULL DEFAULT '',
+  "topic3" varchar(128) NOT NULL DEFAULT '',
+  "data" bytea NOT NULL,
+  "st" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY ("height", "log_index")
+);
+CREATE INDEX IF NOT EXISTS "idx_event_addr" ON "t_event" ("addr", "height");
+CREATE INDEX IF NOT EXISTS "idx_event_topic0" ON "t_event" ("topic0", "height");
+CREATE INDEX IF NOT EXISTS "idx_event_thash" ON "t_event" ("thash");
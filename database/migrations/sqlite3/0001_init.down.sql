@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS "t_asset";
+DROP TABLE IF EXISTS "t_candidates";
+DROP TABLE IF EXISTS "t_context";
+DROP TABLE IF EXISTS "t_equity";
+DROP TABLE IF EXISTS "t_kv";
+DROP TABLE IF EXISTS "t_meta_data";
+DROP TABLE IF EXISTS "t_tx";
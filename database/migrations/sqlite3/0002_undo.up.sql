@@ -0,0 +1,12 @@
+/******************************************/
+/*   DatabaseName = lemochain   */
+/*   TableName = t_undo   */
+/******************************************/
+CREATE TABLE IF NOT EXISTS "t_undo" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "height" bigint NOT NULL,
+  "tbl" varchar(32) NOT NULL,
+  "row_data" blob NOT NULL,
+  "st" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS "idx_undo_height" ON "t_undo" ("height");
@@ -0,0 +1,19 @@
+/******************************************/
+/*   DatabaseName = lemochain   */
+/*   TableName = t_block   */
+/******************************************/
+CREATE TABLE IF NOT EXISTS "t_block" (
+  "height" bigint NOT NULL,
+  "bhash" varchar(128) NOT NULL,
+  "parent_hash" varchar(128) NOT NULL,
+  "miner" varchar(128) NOT NULL,
+  "block_time" bigint NOT NULL,
+  "tx_count" integer NOT NULL,
+  "gas_used" bigint NOT NULL,
+  "event_count" integer NOT NULL,
+  "st" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY ("height")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_block_hash" ON "t_block" ("bhash");
+CREATE INDEX IF NOT EXISTS "idx_block_miner" ON "t_block" ("miner", "height");
+CREATE INDEX IF NOT EXISTS "idx_block_time" ON "t_block" ("block_time");
@@ -22,14 +22,18 @@ type Tx struct {
 	PackageTime uint32      // 打包交易的时间
 	AssetCode   common.Hash // 如果是资产交易则对应资产code
 	AssetId     common.Hash // 对应资产交易的资产id
+	Raw         []byte      // rlp of Tx. It is encoded when saving if it is nil
 }
 
+var txColumns = []string{"thash", "phash", "bhash", "height", "faddr", "taddr", "tx", "flag", "utc_st", "package_time", "asset_code", "asset_id"}
+
 type TxDao struct {
-	engine *sql.DB
+	engine  Executor
+	dialect Dialect
 }
 
 func NewTxDao(db DBEngine) *TxDao {
-	return &TxDao{engine: db.GetDB()}
+	return &TxDao{engine: GetExecutor(db), dialect: db.GetDialect()}
 }
 
 func (dao *TxDao) Set(tx *Tx) error {
@@ -38,15 +42,13 @@ func (dao *TxDao) Set(tx *Tx) error {
 		return ErrArgInvalid
 	}
 
-	sql := "REPLACE INTO t_tx(thash, phash, bhash, height, faddr, taddr, tx, flag, utc_st, package_time,asset_code,asset_id)VALUES(?,?,?,?,?,?,?,?,?,?,?,?)"
+	sql := dao.dialect.Replace("t_tx", txColumns, []string{"thash"})
 
-	val, err := rlp.EncodeToBytes(tx.Tx)
+	args, err := dao.txArgs(tx)
 	if err != nil {
 		return err
 	}
-
-	height := int64(tx.Height)
-	_, err = dao.engine.Exec(sql, tx.THash.Hex(), tx.PHash.Hex(), tx.BHash.Hex(), height, tx.From.Hex(), tx.To.Hex(), val, tx.Tx.Type(), time.Now().UnixNano()/1000000, tx.PackageTime, tx.AssetCode.Hex(), tx.AssetId.Hex())
+	_, err = dao.engine.Exec(sql, args...)
 	if err != nil {
 		return err
 	} else {
@@ -54,6 +56,49 @@ func (dao *TxDao) Set(tx *Tx) error {
 	}
 }
 
+// SetBatch save the txs with multi-row statements. If some txs have the same hash, the last one is saved
+func (dao *TxDao) SetBatch(txes []*Tx) error {
+	hashes := make([]common.Hash, 0, len(txes))
+	latest := make(map[common.Hash]*Tx)
+	for _, tx := range txes {
+		if tx == nil {
+			log.Errorf("set tx batch. tx is nil.")
+			return ErrArgInvalid
+		}
+		if _, ok := latest[tx.THash]; !ok {
+			hashes = append(hashes, tx.THash)
+		}
+		latest[tx.THash] = tx
+	}
+
+	return forEachBatch(len(hashes), len(txColumns), func(start, end int) error {
+		args := make([]interface{}, 0, (end-start)*len(txColumns))
+		for _, hash := range hashes[start:end] {
+			txArgs, err := dao.txArgs(latest[hash])
+			if err != nil {
+				return err
+			}
+			args = append(args, txArgs...)
+		}
+		_, err := dao.engine.Exec(dao.dialect.ReplaceRows("t_tx", txColumns, []string{"thash"}, end-start), args...)
+		return err
+	})
+}
+
+// txArgs return the values of txColumns
+func (dao *TxDao) txArgs(tx *Tx) ([]interface{}, error) {
+	val := tx.Raw
+	if val == nil {
+		var err error
+		if val, err = rlp.EncodeToBytes(tx.Tx); err != nil {
+			return nil, err
+		}
+	}
+
+	height := int64(tx.Height)
+	return []interface{}{tx.THash.Hex(), tx.PHash.Hex(), tx.BHash.Hex(), height, tx.From.Hex(), tx.To.Hex(), val, tx.Tx.Type(), time.Now().UnixNano() / 1000000, tx.PackageTime, tx.AssetCode.Hex(), tx.AssetId.Hex()}, nil
+}
+
 func (dao *TxDao) Get(hash common.Hash) (*Tx, error) {
 	if hash == (common.Hash{}) {
 		log.Errorf("get tx. hash is common.hash{}")
@@ -157,19 +202,36 @@ func (dao *TxDao) buildTxBatch(rows *sql.Rows) ([]*Tx, error) {
 	return result, nil
 }
 
+// GetByHeightRange return the txs in the blocks from height from to height to, ordered by height
+func (dao *TxDao) GetByHeightRange(from, to uint32) ([]*Tx, error) {
+	if from > to {
+		log.Errorf("get tx by height range. from > to")
+		return nil, ErrArgInvalid
+	}
+
+	sqlQuery := "SELECT thash, phash, bhash, height, faddr, taddr, tx, flag, utc_st, package_time,asset_code,asset_id FROM t_tx WHERE height >= ? AND height <= ? ORDER BY height"
+	rows, err := dao.engine.Query(sqlQuery, int64(from), int64(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return dao.buildTxBatch(rows)
+}
+
 func (dao *TxDao) GetByAddr(addr common.Address, start, limit int) ([]*Tx, error) {
 	if addr == (common.Address{}) || (start < 0) || (limit <= 0) {
 		log.Errorf("get tx by addr. addr is common.address{} or start < 0 or limit <= 0")
 		return nil, ErrArgInvalid
 	}
 
-	sqlQuery := "SELECT thash, phash, bhash, height, faddr, taddr, tx, flag, utc_st, package_time,asset_code,asset_id FROM t_tx WHERE faddr = ? or taddr = ? ORDER BY utc_st DESC LIMIT ?, ?"
+	sqlQuery := "SELECT thash, phash, bhash, height, faddr, taddr, tx, flag, utc_st, package_time,asset_code,asset_id FROM t_tx WHERE faddr = ? or taddr = ? ORDER BY utc_st DESC LIMIT ? OFFSET ?"
 	stmt, err := dao.engine.Prepare(sqlQuery)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := stmt.Query(addr.Hex(), addr.Hex(), start, start+limit)
+	rows, err := stmt.Query(addr.Hex(), addr.Hex(), start+limit, start)
 	if err != nil {
 		return nil, err
 	}
@@ -205,13 +267,13 @@ func (dao *TxDao) GetByTime(addr common.Address, stStart, stStop int64, start, l
 		return nil, ErrArgInvalid
 	}
 
-	sqlQuery := "SELECT thash, phash, bhash, height, faddr, taddr, tx, flag, utc_st, package_time,asset_code,asset_id FROM t_tx WHERE (faddr = ? OR taddr = ?) AND utc_st > ? AND utc_st < ? ORDER BY utc_st DESC LIMIT ?, ?"
+	sqlQuery := "SELECT thash, phash, bhash, height, faddr, taddr, tx, flag, utc_st, package_time,asset_code,asset_id FROM t_tx WHERE (faddr = ? OR taddr = ?) AND utc_st > ? AND utc_st < ? ORDER BY utc_st DESC LIMIT ? OFFSET ?"
 	stmt, err := dao.engine.Prepare(sqlQuery)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := stmt.Query(addr.Hex(), addr.Hex(), stStop, stStart, start, start+limit)
+	rows, err := stmt.Query(addr.Hex(), addr.Hex(), stStop, stStart, start+limit, start)
 	if err != nil {
 		return nil, err
 	}
@@ -247,13 +309,13 @@ func (dao *TxDao) GetByFrom(addr common.Address, start, limit int) ([]*Tx, error
 		return nil, ErrArgInvalid
 	}
 
-	sqlQuery := "SELECT thash, phash, bhash, height, faddr, taddr, tx, flag, utc_st ,package_time,asset_code,asset_id FROM t_tx WHERE faddr = ? ORDER BY utc_st DESC LIMIT ?, ?"
+	sqlQuery := "SELECT thash, phash, bhash, height, faddr, taddr, tx, flag, utc_st ,package_time,asset_code,asset_id FROM t_tx WHERE faddr = ? ORDER BY utc_st DESC LIMIT ? OFFSET ?"
 	stmt, err := dao.engine.Prepare(sqlQuery)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := stmt.Query(addr.Hex(), start, start+limit)
+	rows, err := stmt.Query(addr.Hex(), start+limit, start)
 	if err != nil {
 		return nil, err
 	}
@@ -289,13 +351,13 @@ func (dao *TxDao) GetByTo(addr common.Address, start, limit int) ([]*Tx, error)
 		return nil, ErrArgInvalid
 	}
 
-	sqlQuery := "SELECT thash, phash, bhash, height, faddr, taddr, tx, flag, utc_st, package_time,asset_code,asset_id FROM t_tx WHERE taddr = ? ORDER BY utc_st DESC LIMIT ?, ?"
+	sqlQuery := "SELECT thash, phash, bhash, height, faddr, taddr, tx, flag, utc_st, package_time,asset_code,asset_id FROM t_tx WHERE taddr = ? ORDER BY utc_st DESC LIMIT ? OFFSET ?"
 	stmt, err := dao.engine.Prepare(sqlQuery)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := stmt.Query(addr.Hex(), start, start+limit)
+	rows, err := stmt.Query(addr.Hex(), start+limit, start)
 	if err != nil {
 		return nil, err
 	}
@@ -331,13 +393,13 @@ func (dao *TxDao) GetByType(addr common.Address, txType uint16, start, limit int
 		return nil, ErrArgInvalid
 	}
 
-	sqlQuery := "SELECT thash, phash, bhash, height, faddr, taddr, tx, flag, utc_st, package_time,asset_code,asset_id FROM t_tx WHERE (faddr = ? OR taddr = ?) AND (flag = ?) ORDER BY utc_st DESC LIMIT ?, ?"
+	sqlQuery := "SELECT thash, phash, bhash, height, faddr, taddr, tx, flag, utc_st, package_time,asset_code,asset_id FROM t_tx WHERE (faddr = ? OR taddr = ?) AND (flag = ?) ORDER BY utc_st DESC LIMIT ? OFFSET ?"
 	stmt, err := dao.engine.Prepare(sqlQuery)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := stmt.Query(addr.Hex(), addr.Hex(), txType, start, start+limit)
+	rows, err := stmt.Query(addr.Hex(), addr.Hex(), txType, start+limit, start)
 	if err != nil {
 		return nil, err
 	}
@@ -373,12 +435,12 @@ func (dao *TxDao) GetByAddressAndAssetCodeOrAssetId(addr common.Address, assetCo
 		log.Errorf("get tx by asset. addr is common.address{} or asset is common.Hash{} or start < 0 or limit <= 0")
 		return nil, ErrArgInvalid
 	}
-	sqlQuery := "SELECT thash, phash, bhash, height, faddr, taddr, tx, flag, utc_st, package_time,asset_code,asset_id FROM t_tx WHERE (faddr = ? OR taddr = ?) AND (asset_code = ? OR asset_id = ?) ORDER BY utc_st DESC LIMIT ?, ?"
+	sqlQuery := "SELECT thash, phash, bhash, height, faddr, taddr, tx, flag, utc_st, package_time,asset_code,asset_id FROM t_tx WHERE (faddr = ? OR taddr = ?) AND (asset_code = ? OR asset_id = ?) ORDER BY utc_st DESC LIMIT ? OFFSET ?"
 	stmt, err := dao.engine.Prepare(sqlQuery)
 	if err != nil {
 		return nil, err
 	}
-	rows, err := stmt.Query(addr.Hex(), addr.Hex(), assetCodeOrId.Hex(), assetCodeOrId.Hex(), start, start+limit)
+	rows, err := stmt.Query(addr.Hex(), addr.Hex(), assetCodeOrId.Hex(), assetCodeOrId.Hex(), start+limit, start)
 	if err != nil {
 		return nil, err
 	}
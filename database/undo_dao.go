@@ -0,0 +1,361 @@
+package database
+
+import (
+	"database/sql"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-core/common/log"
+	"github.com/LemoFoundationLtd/lemochain-core/common/rlp"
+	"strconv"
+	"strings"
+)
+
+type undoColumn struct {
+	Name   string
+	Null   bool
+	Binary bool // restore the value as []byte, otherwise as string
+	Value  []byte
+}
+
+// undoRow is the snapshot of a table row before a block changes it. Cols is empty if the row did not exist
+type undoRow struct {
+	Table string
+	Keys  []undoColumn
+	Cols  []undoColumn
+}
+
+// UndoDao records the previous value of the rows which are overwritten by a block, so that the block can be reverted
+type UndoDao struct {
+	engine Executor
+}
+
+func NewUndoDao(db DBEngine) *UndoDao {
+	return &UndoDao{engine: GetExecutor(db)}
+}
+
+func (dao *UndoDao) RecordKv(height uint32, key []byte) error {
+	if len(key) <= 0 {
+		log.Errorf("record k/v undo. key is nil.")
+		return ErrArgInvalid
+	}
+	return dao.record(height, "t_kv", undoColumn{Name: "lm_key", Value: []byte(common.ToHex(key))})
+}
+
+func (dao *UndoDao) RecordContext(height uint32, key string) error {
+	return dao.record(height, "t_context", undoColumn{Name: "lm_key", Value: []byte(key)})
+}
+
+func (dao *UndoDao) RecordAsset(height uint32, code common.Hash) error {
+	return dao.record(height, "t_asset", undoColumn{Name: "code", Value: []byte(code.Hex())})
+}
+
+func (dao *UndoDao) RecordAssetToken(height uint32, id common.Hash) error {
+	return dao.record(height, "t_meta_data", undoColumn{Name: "id", Value: []byte(id.Hex())})
+}
+
+func (dao *UndoDao) RecordEquity(height uint32, addr common.Address, id common.Hash) error {
+	return dao.record(height, "t_equity",
+		undoColumn{Name: "id", Value: []byte(id.Hex())},
+		undoColumn{Name: "addr", Value: []byte(addr.Hex())})
+}
+
+func (dao *UndoDao) RecordCandidate(height uint32, addr common.Address) error {
+	return dao.record(height, "t_candidates", undoColumn{Name: "addr", Value: []byte(addr.Hex())})
+}
+
+func (dao *UndoDao) RecordBlock(height uint32) error {
+	return dao.record(height, "t_block", undoColumn{Name: "height", Value: []byte(strconv.FormatUint(uint64(height), 10))})
+}
+
+func (dao *UndoDao) RecordTx(height uint32, hash common.Hash) error {
+	return dao.record(height, "t_tx", undoColumn{Name: "thash", Value: []byte(hash.Hex())})
+}
+
+// RecordKvs is like RecordKv, but it records the keys with a few statements
+func (dao *UndoDao) RecordKvs(height uint32, keys [][]byte) error {
+	rows := make([][]undoColumn, len(keys))
+	for i, key := range keys {
+		if len(key) <= 0 {
+			log.Errorf("record k/v undo batch. key is nil.")
+			return ErrArgInvalid
+		}
+		rows[i] = []undoColumn{{Name: "lm_key", Value: []byte(common.ToHex(key))}}
+	}
+	return dao.recordBatch(height, "t_kv", rows)
+}
+
+// RecordAssetTokens is like RecordAssetToken, but it records the ids with a few statements
+func (dao *UndoDao) RecordAssetTokens(height uint32, ids []common.Hash) error {
+	rows := make([][]undoColumn, len(ids))
+	for i, id := range ids {
+		rows[i] = []undoColumn{{Name: "id", Value: []byte(id.Hex())}}
+	}
+	return dao.recordBatch(height, "t_meta_data", rows)
+}
+
+// RecordEquities is like RecordEquity, but it records the ids with a few statements
+func (dao *UndoDao) RecordEquities(height uint32, addr common.Address, ids []common.Hash) error {
+	rows := make([][]undoColumn, len(ids))
+	for i, id := range ids {
+		rows[i] = []undoColumn{{Name: "id", Value: []byte(id.Hex())}, {Name: "addr", Value: []byte(addr.Hex())}}
+	}
+	return dao.recordBatch(height, "t_equity", rows)
+}
+
+// RecordTxs is like RecordTx, but it records the hashes with a few statements
+func (dao *UndoDao) RecordTxs(height uint32, hashes []common.Hash) error {
+	rows := make([][]undoColumn, len(hashes))
+	for i, hash := range hashes {
+		rows[i] = []undoColumn{{Name: "thash", Value: []byte(hash.Hex())}}
+	}
+	return dao.recordBatch(height, "t_tx", rows)
+}
+
+// RecordEvents record the events of the block at height, whose log indexes are in [0, count)
+func (dao *UndoDao) RecordEvents(height uint32, count int) error {
+	rows := make([][]undoColumn, count)
+	for i := range rows {
+		rows[i] = []undoColumn{
+			{Name: "height", Value: []byte(strconv.FormatUint(uint64(height), 10))},
+			{Name: "log_index", Value: []byte(strconv.Itoa(i))},
+		}
+	}
+	return dao.recordBatch(height, "t_event", rows)
+}
+
+// Revert restore all the rows changed by the block at height, and drop its undo records
+func (dao *UndoDao) Revert(height uint32) error {
+	rows, err := dao.engine.Query("SELECT row_data FROM t_undo WHERE height = ? ORDER BY id DESC", height)
+	if err != nil {
+		return err
+	}
+
+	// read all the records before writing, the connection is busy until rows is closed
+	undoRows := make([]*undoRow, 0)
+	for rows.Next() {
+		var val []byte
+		if err := rows.Scan(&val); err != nil {
+			rows.Close()
+			return err
+		}
+
+		var row undoRow
+		if err := rlp.DecodeBytes(val, &row); err != nil {
+			rows.Close()
+			return err
+		}
+		undoRows = append(undoRows, &row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, row := range undoRows {
+		if err := dao.restore(row); err != nil {
+			return err
+		}
+	}
+
+	_, err = dao.engine.Exec("DELETE FROM t_undo WHERE height = ?", height)
+	return err
+}
+
+// Prune drop the undo records of the blocks lower than height
+func (dao *UndoDao) Prune(height uint32) error {
+	_, err := dao.engine.Exec("DELETE FROM t_undo WHERE height < ?", height)
+	return err
+}
+
+// OldestHeight return the lowest height which has undo records
+func (dao *UndoDao) OldestHeight() (uint32, error) {
+	row := dao.engine.QueryRow("SELECT MIN(height) FROM t_undo")
+	var height sql.NullInt64
+	if err := row.Scan(&height); err != nil {
+		return 0, err
+	}
+	if !height.Valid {
+		return 0, ErrNotExist
+	}
+	return uint32(height.Int64), nil
+}
+
+// record save the current value of the row in table
+func (dao *UndoDao) record(height uint32, table string, keys ...undoColumn) error {
+	cols, err := dao.snapshot(table, keys)
+	if err != nil {
+		return err
+	}
+
+	val, err := rlp.EncodeToBytes(&undoRow{Table: table, Keys: keys, Cols: cols})
+	if err != nil {
+		return err
+	}
+
+	_, err = dao.engine.Exec("INSERT INTO t_undo(height, tbl, row_data) VALUES (?,?,?)", height, table, val)
+	return err
+}
+
+// recordBatch save the current values of the rows in table. Every item of keyRows is the keys of a row
+func (dao *UndoDao) recordBatch(height uint32, table string, keyRows [][]undoColumn) error {
+	// the same row is recorded once
+	unique := make([][]undoColumn, 0, len(keyRows))
+	exist := make(map[string]bool)
+	for _, keys := range keyRows {
+		id := undoKeyID(keys)
+		if !exist[id] {
+			exist[id] = true
+			unique = append(unique, keys)
+		}
+	}
+
+	cols := []string{"height", "tbl", "row_data"}
+	return forEachBatch(len(unique), len(cols), func(start, end int) error {
+		snapshots, err := dao.snapshotBatch(table, unique[start:end])
+		if err != nil {
+			return err
+		}
+
+		args := make([]interface{}, 0, (end-start)*len(cols))
+		for _, keys := range unique[start:end] {
+			snapshot, ok := snapshots[undoKeyID(keys)]
+			if !ok {
+				snapshot = make([]undoColumn, 0)
+			}
+			val, err := rlp.EncodeToBytes(&undoRow{Table: table, Keys: keys, Cols: snapshot})
+			if err != nil {
+				return err
+			}
+			args = append(args, height, table, val)
+		}
+		_, err = dao.engine.Exec(insertRows("t_undo", cols, end-start), args...)
+		return err
+	})
+}
+
+func (dao *UndoDao) snapshot(table string, keys []undoColumn) ([]undoColumn, error) {
+	where, args := undoWhere(keys)
+	rows, err := dao.engine.Query("SELECT * FROM "+table+" WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return make([]undoColumn, 0), rows.Err()
+	}
+	return scanUndoColumns(rows)
+}
+
+// snapshotBatch query the rows of keyRows in one statement. The result is indexed by undoKeyID of the keys
+func (dao *UndoDao) snapshotBatch(table string, keyRows [][]undoColumn) (map[string][]undoColumn, error) {
+	conditions := make([]string, len(keyRows))
+	args := make([]interface{}, 0, len(keyRows))
+	for i, keys := range keyRows {
+		where, keyArgs := undoWhere(keys)
+		conditions[i] = "(" + where + ")"
+		args = append(args, keyArgs...)
+	}
+	rows, err := dao.engine.Query("SELECT * FROM "+table+" WHERE "+strings.Join(conditions, " OR "), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string][]undoColumn)
+	for rows.Next() {
+		cols, err := scanUndoColumns(rows)
+		if err != nil {
+			return nil, err
+		}
+		// pick the key columns in the same order as keyRows
+		values := make(map[string]undoColumn)
+		for _, col := range cols {
+			values[col.Name] = col
+		}
+		keys := make([]undoColumn, len(keyRows[0]))
+		for i, key := range keyRows[0] {
+			keys[i] = undoColumn{Name: key.Name, Value: values[key.Name].Value}
+		}
+		result[undoKeyID(keys)] = cols
+	}
+	return result, rows.Err()
+}
+
+// scanUndoColumns read the current row of rows
+func scanUndoColumns(rows *sql.Rows) ([]undoColumn, error) {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]sql.RawBytes, len(columnTypes))
+	dest := make([]interface{}, len(columnTypes))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	result := make([]undoColumn, len(columnTypes))
+	for i, columnType := range columnTypes {
+		typeName := strings.ToUpper(columnType.DatabaseTypeName())
+		result[i] = undoColumn{
+			Name:   columnType.Name(),
+			Null:   values[i] == nil,
+			Binary: strings.Contains(typeName, "BLOB") || strings.Contains(typeName, "BINARY") || typeName == "BYTEA",
+			Value:  append([]byte{}, values[i]...),
+		}
+	}
+	return result, nil
+}
+
+func (dao *UndoDao) restore(row *undoRow) error {
+	where, args := undoWhere(row.Keys)
+	if _, err := dao.engine.Exec("DELETE FROM "+row.Table+" WHERE "+where, args...); err != nil {
+		return err
+	}
+
+	if len(row.Cols) <= 0 {
+		return nil
+	}
+
+	names := make([]string, len(row.Cols))
+	marks := make([]string, len(row.Cols))
+	values := make([]interface{}, len(row.Cols))
+	for i, col := range row.Cols {
+		names[i] = col.Name
+		marks[i] = "?"
+		if col.Null {
+			continue
+		}
+		if col.Binary {
+			values[i] = col.Value
+		} else {
+			values[i] = string(col.Value)
+		}
+	}
+
+	sqlInsert := "INSERT INTO " + row.Table + "(" + strings.Join(names, ", ") + ") VALUES (" + strings.Join(marks, ",") + ")"
+	_, err := dao.engine.Exec(sqlInsert, values...)
+	return err
+}
+
+func undoWhere(keys []undoColumn) (string, []interface{}) {
+	conditions := make([]string, len(keys))
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		conditions[i] = key.Name + " = ?"
+		args[i] = string(key.Value)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// undoKeyID join the values of keys to identify a row
+func undoKeyID(keys []undoColumn) string {
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = string(key.Value)
+	}
+	return strings.Join(values, "\x00")
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func TestUndoDao_RevertKv(t *testing.T) {
+	db := NewMySqlDB(DRIVER_MYSQL, HOST_MYSQL)
+	defer db.Close()
+	defer db.Clear()
+
+	kvDao := NewKvDao(db)
+	undoDao := NewUndoDao(db)
+
+	// block 1 insert the key
+	err := undoDao.RecordKv(1, []byte("key"))
+	assert.NoError(t, err)
+	err = kvDao.Set([]byte("key"), []byte("val1"))
+	assert.NoError(t, err)
+
+	// block 2 update the key twice
+	err = undoDao.RecordKv(2, []byte("key"))
+	assert.NoError(t, err)
+	err = kvDao.Set([]byte("key"), []byte("val2"))
+	assert.NoError(t, err)
+	err = undoDao.RecordKv(2, []byte("key"))
+	assert.NoError(t, err)
+	err = kvDao.Set([]byte("key"), []byte("val3"))
+	assert.NoError(t, err)
+
+	err = undoDao.Revert(2)
+	assert.NoError(t, err)
+	result, err := kvDao.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val1"), result)
+
+	err = undoDao.Revert(1)
+	assert.NoError(t, err)
+	result, err = kvDao.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+
+	err = undoDao.RecordKv(1, nil)
+	assert.Equal(t, ErrArgInvalid, err)
+}
+
+func TestUndoDao_RevertEquity(t *testing.T) {
+	db := NewMySqlDB(DRIVER_MYSQL, HOST_MYSQL)
+	defer db.Close()
+	defer db.Clear()
+
+	equityDao := NewEquityDao(db)
+	undoDao := NewUndoDao(db)
+
+	addr := common.HexToAddress("0x01")
+	equity := NewAssetEquity(common.HexToHash("0x02"), common.HexToHash("0x03"), 100)
+	err := equityDao.Set(addr, equity)
+	assert.NoError(t, err)
+
+	err = undoDao.RecordEquity(1, addr, equity.AssetId)
+	assert.NoError(t, err)
+	equity.Equity = big.NewInt(999)
+	err = equityDao.Set(addr, equity)
+	assert.NoError(t, err)
+
+	err = undoDao.Revert(1)
+	assert.NoError(t, err)
+	result, err := equityDao.Get(addr, equity.AssetId)
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(100), result.Equity)
+
+	// update after revert with the restored version
+	err = equityDao.Set(addr, equity)
+	assert.NoError(t, err)
+}
+
+func TestUndoDao_Prune(t *testing.T) {
+	db := NewMySqlDB(DRIVER_MYSQL, HOST_MYSQL)
+	defer db.Close()
+	defer db.Clear()
+
+	undoDao := NewUndoDao(db)
+	_, err := undoDao.OldestHeight()
+	assert.Equal(t, ErrNotExist, err)
+
+	for height := uint32(1); height <= 10; height++ {
+		err := undoDao.RecordKv(height, []byte("key"))
+		assert.NoError(t, err)
+	}
+	oldest, err := undoDao.OldestHeight()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), oldest)
+
+	err = undoDao.Prune(6)
+	assert.NoError(t, err)
+	oldest, err = undoDao.OldestHeight()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(6), oldest)
+}
@@ -1,6 +1,6 @@
 module github.com/LemoFoundationLtd/lemochain-distribution
 
-go 1.14
+go 1.16
 
 require (
 	github.com/LemoFoundationLtd/lemochain-core v1.4.3
@@ -8,10 +8,15 @@ require (
 	github.com/btcsuite/btcd v0.20.1-beta // indirect
 	github.com/go-sql-driver/mysql v1.4.1-0.20190308052631-2c9d54fefcfb
 	github.com/inconshreveable/log15 v0.0.0-20200109203555-b30bc20e4fd1 // indirect
+	github.com/lib/pq v1.8.0
 	github.com/mattn/go-colorable v0.1.7 // indirect
+	github.com/mattn/go-sqlite3 v1.14.3
+	github.com/prometheus/client_golang v1.11.1
 	github.com/rs/cors v1.7.0 // indirect
 	github.com/stretchr/testify v1.6.1
 	github.com/syndtr/goleveldb v1.0.0 // indirect
+	golang.org/x/crypto v0.0.0-20200728195943-123391ffb6de
+	golang.org/x/net v0.0.0-20200707034311-ab3426394381
 	google.golang.org/appengine v1.6.6 // indirect
 	gopkg.in/LemoFoundationLtd/npipe.v2 v2.0.0-20181023073812-d73773ca71f4 // indirect
 	gopkg.in/fatih/set.v0 v0.2.1 // indirect
@@ -0,0 +1,154 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-core/common/crypto"
+	"golang.org/x/crypto/scrypt"
+	"io"
+)
+
+const (
+	keyVersion   = 3
+	scryptR      = 8
+	scryptDKLen  = 32
+	cipherName   = "aes-128-ctr"
+	kdfName      = "scrypt"
+	saltLength   = 32
+	privateBytes = 32
+)
+
+var (
+	ErrDecrypt    = errors.New("could not decrypt key with given passphrase")
+	ErrKeyVersion = errors.New("unsupported key file")
+)
+
+// encryptedKeyJSON is the content of key file. The private key is encrypted by aes-128-ctr with the key derived by scrypt
+type encryptedKeyJSON struct {
+	Address common.Address `json:"address"`
+	Crypto  cryptoJSON     `json:"crypto"`
+	Version int            `json:"version"`
+}
+
+type cryptoJSON struct {
+	Cipher       string       `json:"cipher"`
+	CipherText   string       `json:"ciphertext"`
+	CipherParams cipherParams `json:"cipherparams"`
+	KDF          string       `json:"kdf"`
+	KDFParams    kdfParams    `json:"kdfparams"`
+	MAC          string       `json:"mac"`
+}
+
+type cipherParams struct {
+	IV string `json:"iv"`
+}
+
+type kdfParams struct {
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	DKLen int    `json:"dklen"`
+	Salt  string `json:"salt"`
+}
+
+// encryptKey encode the private key into the content of key file
+func encryptKey(private *ecdsa.PrivateKey, passphrase string, scryptN, scryptP int) ([]byte, error) {
+	salt := make([]byte, saltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	derivedKey, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptDKLen)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	cipherText, err := aesCTRXOR(derivedKey[:16], crypto.FromECDSA(private), iv)
+	if err != nil {
+		return nil, err
+	}
+	mac := crypto.Keccak256(derivedKey[16:32], cipherText)
+
+	return json.Marshal(&encryptedKeyJSON{
+		Address: crypto.PubkeyToAddress(private.PublicKey),
+		Crypto: cryptoJSON{
+			Cipher:       cipherName,
+			CipherText:   hex.EncodeToString(cipherText),
+			CipherParams: cipherParams{IV: hex.EncodeToString(iv)},
+			KDF:          kdfName,
+			KDFParams:    kdfParams{N: scryptN, R: scryptR, P: scryptP, DKLen: scryptDKLen, Salt: hex.EncodeToString(salt)},
+			MAC:          hex.EncodeToString(mac),
+		},
+		Version: keyVersion,
+	})
+}
+
+// decryptKey decode the private key from the content of key file
+func decryptKey(content []byte, passphrase string) (*ecdsa.PrivateKey, error) {
+	var keyJSON encryptedKeyJSON
+	if err := json.Unmarshal(content, &keyJSON); err != nil {
+		return nil, err
+	}
+	c := keyJSON.Crypto
+	if keyJSON.Version != keyVersion || c.Cipher != cipherName || c.KDF != kdfName {
+		return nil, ErrKeyVersion
+	}
+	salt, err := hex.DecodeString(c.KDFParams.Salt)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := hex.DecodeString(c.CipherParams.IV)
+	if err != nil {
+		return nil, err
+	}
+	cipherText, err := hex.DecodeString(c.CipherText)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := hex.DecodeString(c.MAC)
+	if err != nil {
+		return nil, err
+	}
+
+	derivedKey, err := scrypt.Key([]byte(passphrase), salt, c.KDFParams.N, c.KDFParams.R, c.KDFParams.P, c.KDFParams.DKLen)
+	if err != nil {
+		return nil, err
+	}
+	if len(derivedKey) < 32 || !bytes.Equal(crypto.Keccak256(derivedKey[16:32], cipherText), mac) {
+		return nil, ErrDecrypt
+	}
+	plainText, err := aesCTRXOR(derivedKey[:16], cipherText, iv)
+	if err != nil {
+		return nil, err
+	}
+	if len(plainText) != privateBytes {
+		return nil, ErrDecrypt
+	}
+	private, err := crypto.ToECDSA(plainText)
+	if err != nil {
+		return nil, err
+	}
+	if crypto.PubkeyToAddress(private.PublicKey) != keyJSON.Address {
+		return nil, ErrDecrypt
+	}
+	return private, nil
+}
+
+func aesCTRXOR(key, input, iv []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	output := make([]byte, len(input))
+	cipher.NewCTR(block, iv).XORKeyStream(output, input)
+	return output, nil
+}
@@ -0,0 +1,244 @@
+package keystore
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-core/common/crypto"
+	"github.com/LemoFoundationLtd/lemochain-core/common/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// StandardScryptN and StandardScryptP cost 256MB memory and about 1 second to encrypt or decrypt a key
+	StandardScryptN = 1 << 18
+	StandardScryptP = 1
+	// LightScryptN and LightScryptP cost 4MB memory and about 100ms. They are used in tests
+	LightScryptN = 1 << 12
+	LightScryptP = 6
+)
+
+var (
+	ErrNoMatch       = errors.New("no key for given address")
+	ErrLocked        = errors.New("account is locked")
+	ErrAccountExists = errors.New("account already exists")
+)
+
+type unlockedKey struct {
+	private *ecdsa.PrivateKey
+	timer   *time.Timer // nil if the key is unlocked until Lock
+}
+
+// KeyStore manages the encrypted key files in a directory. A key must be unlocked by passphrase before signing
+type KeyStore struct {
+	dir      string
+	scryptN  int
+	scryptP  int
+	unlocked map[common.Address]*unlockedKey
+	lock     sync.Mutex
+	fileLock sync.Mutex // serialize checking and writing the key files, so one address has one file
+}
+
+func New(dir string, scryptN, scryptP int) *KeyStore {
+	return &KeyStore{
+		dir:      dir,
+		scryptN:  scryptN,
+		scryptP:  scryptP,
+		unlocked: make(map[common.Address]*unlockedKey),
+	}
+}
+
+// NewAccount generate a new key and save it encrypted by passphrase
+func (ks *KeyStore) NewAccount(passphrase string) (common.Address, error) {
+	private, err := crypto.GenerateKey()
+	if err != nil {
+		return common.Address{}, err
+	}
+	return ks.Import(private, passphrase)
+}
+
+// Import save the private key encrypted by passphrase
+func (ks *KeyStore) Import(private *ecdsa.PrivateKey, passphrase string) (common.Address, error) {
+	addr := crypto.PubkeyToAddress(private.PublicKey)
+	content, err := encryptKey(private, passphrase, ks.scryptN, ks.scryptP)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	ks.fileLock.Lock()
+	defer ks.fileLock.Unlock()
+	if _, err := ks.keyFile(addr); err == nil {
+		return common.Address{}, ErrAccountExists
+	} else if err != ErrNoMatch {
+		return common.Address{}, err
+	}
+	if err := os.MkdirAll(ks.dir, 0700); err != nil {
+		return common.Address{}, err
+	}
+	// write a temp file then rename it, so that a half written key file is never seen
+	name := fmt.Sprintf("UTC--%s--%s", time.Now().UTC().Format("2006-01-02T15-04-05.000000000Z"), addressSuffix(addr))
+	tmp, err := ioutil.TempFile(ks.dir, "."+name+".tmp")
+	if err != nil {
+		return common.Address{}, err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return common.Address{}, err
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), filepath.Join(ks.dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return common.Address{}, err
+	}
+	log.Infof("save key of %s", addr.String())
+	return addr, nil
+}
+
+// Accounts return the addresses of all the key files, in the order of creation
+func (ks *KeyStore) Accounts() ([]common.Address, error) {
+	files, err := ks.keyFiles()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]common.Address, 0, len(files))
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var keyJSON encryptedKeyJSON
+		if err := json.Unmarshal(content, &keyJSON); err != nil {
+			log.Warnf("skip invalid key file %s: %v", file, err)
+			continue
+		}
+		result = append(result, keyJSON.Address)
+	}
+	return result, nil
+}
+
+// Unlock decrypt the key and keep it in memory for timeout. The key is kept until Lock if timeout is 0
+func (ks *KeyStore) Unlock(addr common.Address, passphrase string, timeout time.Duration) error {
+	file, err := ks.keyFile(addr)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	private, err := decryptKey(content, passphrase)
+	if err != nil {
+		return err
+	}
+
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	ks.lockKey(addr)
+	key := &unlockedKey{private: private}
+	if timeout > 0 {
+		key.timer = time.AfterFunc(timeout, func() {
+			ks.lock.Lock()
+			defer ks.lock.Unlock()
+			// the key may be unlocked again with another timer
+			if ks.unlocked[addr] == key {
+				ks.lockKey(addr)
+			}
+		})
+	}
+	ks.unlocked[addr] = key
+	return nil
+}
+
+// Lock remove the decrypted key from memory
+func (ks *KeyStore) Lock(addr common.Address) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	ks.lockKey(addr)
+}
+
+func (ks *KeyStore) lockKey(addr common.Address) {
+	key, ok := ks.unlocked[addr]
+	if !ok {
+		return
+	}
+	if key.timer != nil {
+		key.timer.Stop()
+	}
+	zeroKey(key.private)
+	delete(ks.unlocked, addr)
+}
+
+// SignTx sign the tx by the unlocked key of addr
+func (ks *KeyStore) SignTx(addr common.Address, tx *types.Transaction, signer types.Signer) (*types.Transaction, error) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	key, ok := ks.unlocked[addr]
+	if !ok {
+		return nil, ErrLocked
+	}
+	return signer.SignTx(tx, key.private)
+}
+
+// Close lock all the keys
+func (ks *KeyStore) Close() {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	for addr := range ks.unlocked {
+		ks.lockKey(addr)
+	}
+}
+
+// keyFiles return the paths of key files, sorted by name
+func (ks *KeyStore) keyFiles() ([]string, error) {
+	infos, err := ioutil.ReadDir(ks.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		result = append(result, filepath.Join(ks.dir, info.Name()))
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// keyFile find the key file of addr by the suffix of file name
+func (ks *KeyStore) keyFile(addr common.Address) (string, error) {
+	files, err := ks.keyFiles()
+	if err != nil {
+		return "", err
+	}
+	suffix := "--" + addressSuffix(addr)
+	for _, file := range files {
+		if strings.HasSuffix(file, suffix) {
+			return file, nil
+		}
+	}
+	return "", ErrNoMatch
+}
+
+func addressSuffix(addr common.Address) string {
+	return strings.TrimPrefix(addr.Hex(), "0x")
+}
+
+func zeroKey(private *ecdsa.PrivateKey) {
+	bits := private.D.Bits()
+	for i := range bits {
+		bits[i] = 0
+	}
+}
@@ -0,0 +1,114 @@
+package keystore
+
+import (
+	"github.com/LemoFoundationLtd/lemochain-core/chain/params"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-core/common/crypto"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestKeyStore(t *testing.T) (*KeyStore, func()) {
+	dir, err := ioutil.TempDir("", "lemo-keystore")
+	assert.NoError(t, err)
+	ks := New(filepath.Join(dir, "keystore"), LightScryptN, LightScryptP)
+	return ks, func() {
+		ks.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestKeyStore_Accounts(t *testing.T) {
+	ks, clean := newTestKeyStore(t)
+	defer clean()
+
+	accounts, err := ks.Accounts()
+	assert.NoError(t, err)
+	assert.Empty(t, accounts)
+
+	addr1, err := ks.NewAccount("pass1")
+	assert.NoError(t, err)
+	private, _ := crypto.GenerateKey()
+	addr2, err := ks.Import(private, "pass2")
+	assert.NoError(t, err)
+	assert.Equal(t, crypto.PubkeyToAddress(private.PublicKey), addr2)
+	_, err = ks.Import(private, "pass3")
+	assert.Equal(t, ErrAccountExists, err)
+
+	accounts, err = ks.Accounts()
+	assert.NoError(t, err)
+	assert.Equal(t, []common.Address{addr1, addr2}, accounts)
+
+	// the key file is only readable by owner
+	file, err := ks.keyFile(addr1)
+	assert.NoError(t, err)
+	info, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestKeyStore_ImportConcurrently(t *testing.T) {
+	ks, clean := newTestKeyStore(t)
+	defer clean()
+
+	// only one of the imports of the same key is saved
+	private, _ := crypto.GenerateKey()
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := ks.Import(private, "pass")
+			errs <- err
+		}()
+	}
+	saved := 0
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err == nil {
+			saved++
+		} else {
+			assert.Equal(t, ErrAccountExists, err)
+		}
+	}
+	assert.Equal(t, 1, saved)
+	files, err := ks.keyFiles()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(files))
+}
+
+func TestKeyStore_Unlock(t *testing.T) {
+	ks, clean := newTestKeyStore(t)
+	defer clean()
+
+	private, _ := crypto.GenerateKey()
+	addr, err := ks.Import(private, "pass")
+	assert.NoError(t, err)
+	tx := types.NewTransaction(addr, common.BigToAddress(big.NewInt(1)), big.NewInt(1), 21000, params.MinGasPrice, nil, params.OrdinaryTx, 1, uint64(time.Now().Unix()+60), "", "")
+
+	_, err = ks.SignTx(addr, tx, types.MakeSigner())
+	assert.Equal(t, ErrLocked, err)
+	assert.Equal(t, ErrDecrypt, ks.Unlock(addr, "wrong", 0))
+	assert.Equal(t, ErrNoMatch, ks.Unlock(common.BigToAddress(big.NewInt(1)), "pass", 0))
+
+	assert.NoError(t, ks.Unlock(addr, "pass", 0))
+	signed, err := ks.SignTx(addr, tx, types.MakeSigner())
+	assert.NoError(t, err)
+	signers, err := types.MakeSigner().GetSigners(signed)
+	assert.NoError(t, err)
+	assert.Equal(t, []common.Address{addr}, signers)
+	ks.Lock(addr)
+	_, err = ks.SignTx(addr, tx, types.MakeSigner())
+	assert.Equal(t, ErrLocked, err)
+
+	// lock after timeout
+	assert.NoError(t, ks.Unlock(addr, "pass", 50*time.Millisecond))
+	_, err = ks.SignTx(addr, tx, types.MakeSigner())
+	assert.NoError(t, err)
+	time.Sleep(200 * time.Millisecond)
+	_, err = ks.SignTx(addr, tx, types.MakeSigner())
+	assert.Equal(t, ErrLocked, err)
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/LemoFoundationLtd/lemochain-core/common/flock"
+	"github.com/LemoFoundationLtd/lemochain-core/common/log"
+	"github.com/LemoFoundationLtd/lemochain-core/network/rpc"
+	"github.com/LemoFoundationLtd/lemochain-distribution/chain"
+	"github.com/LemoFoundationLtd/lemochain-distribution/database"
+	"github.com/LemoFoundationLtd/lemochain-distribution/main/config"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrLeaseHeld      = errors.New("writer lease is held by another node, stop it first")
+	ErrVerifyFailed   = errors.New("database is inconsistent with the blocks")
+)
+
+// commands run by "lemo-distribution <datadir> <command> [args...]"
+var commands = map[string]func(cfg *config.Config, args []string) error{
+	"revert":  revertCommand,
+	"migrate": migrateCommand,
+	"import":  importCommand,
+	"export":  exportCommand,
+	"verify":  verifyCommand,
+	"attach":  attachCommand,
+}
+
+// readOnlyCommands don't write database or only write it through the running node, so they can run while the node is
+// running
+var readOnlyCommands = map[string]bool{
+	"export": true,
+	"attach": true,
+}
+
+// runCommand run the command with the data directory locked, so that it can't run with a started node. The read only
+// commands are not locked
+func runCommand(cfg *config.Config, name string, args []string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		return ErrUnknownCommand
+	}
+	if readOnlyCommands[name] {
+		return cmd(cfg, args)
+	}
+	if err := os.MkdirAll(cfg.DataDir, 0700); err != nil {
+		return err
+	}
+	release, _, err := flock.New(filepath.Join(cfg.DataDir, "LOCK"))
+	if err != nil {
+		return fmt.Errorf("lock data directory failed: %v", err)
+	}
+	defer release.Release()
+
+	return cmd(cfg, args)
+}
+
+// revertCommand revert database to the block at height. usage: revert <height>
+func revertCommand(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: revert <height>")
+	}
+	height, err := strconv.ParseUint(args[0], 10, 32)
+	if err != nil {
+		return err
+	}
+
+	db := database.NewSqlDB(cfg.DbDriver, cfg.DbUri)
+	defer db.Close()
+	bc, err := chain.NewBlockChain(uint16(cfg.ChainID), int(cfg.DeputyCount), cfg.UndoRetention, db)
+	if err != nil {
+		return err
+	}
+	// a running writer node would insert blocks while reverting
+	release, err := holdLease(cfg, db, bc)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := bc.RevertTo(uint32(height)); err != nil {
+		return err
+	}
+	log.Infof("revert to block %d success", height)
+	return nil
+}
+
+// importCommand insert the blocks from a stopped lemochain-core node's data directory, or from a file exported by the
+// export command. It resumes from the stable block in database. usage: import <core data directory | file>
+func importCommand(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: import <core data directory | file>")
+	}
+
+	db := database.NewSqlDB(cfg.DbDriver, cfg.DbUri)
+	defer db.Close()
+	if err := database.CreateDB(db); err != nil {
+		return err
+	}
+	bc, err := chain.NewBlockChain(uint16(cfg.ChainID), int(cfg.DeputyCount), cfg.UndoRetention, db)
+	if err != nil {
+		return err
+	}
+	release, err := holdLease(cfg, db, bc)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	from := uint32(0)
+	if stable := bc.StableBlock(); stable != nil {
+		from = stable.Height() + 1
+	}
+	src, err := chain.OpenBlockSource(args[0], from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	start := time.Now()
+	count, err := bc.Import(src)
+	if err != nil {
+		return err
+	}
+	if stable := bc.StableBlock(); stable != nil {
+		log.Infof("import %d blocks in %v, stable height: %d", count, time.Since(start), stable.Height())
+	}
+	return nil
+}
+
+// exportCommand write the stable blocks to a new file. The file is in json-lines format if its name ends with ".jsonl" or
+// ".jsonl.gz", otherwise it is a rlp stream which can be imported. usage: export [-txs] <file> [from [to]]
+func exportCommand(cfg *config.Config, args []string) error {
+	usage := errors.New("usage: export [-txs] <file> [from [to]]")
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	withTxs := flags.Bool("txs", false, "export the saved txs of blocks")
+	if err := flags.Parse(args); err != nil {
+		return usage
+	}
+	args = flags.Args()
+	if len(args) < 1 || len(args) > 3 {
+		return usage
+	}
+	heights := make([]uint32, len(args)-1)
+	for i, arg := range args[1:] {
+		height, err := strconv.ParseUint(arg, 10, 32)
+		if err != nil {
+			return err
+		}
+		heights[i] = uint32(height)
+	}
+
+	db := database.NewSqlDB(cfg.DbDriver, cfg.DbUri)
+	defer db.Close()
+	if err := database.CheckSchema(db); err != nil {
+		return err
+	}
+	bc, err := chain.NewBlockChain(uint16(cfg.ChainID), int(cfg.DeputyCount), cfg.UndoRetention, db)
+	if err != nil {
+		return err
+	}
+	stable := bc.StableBlock()
+	if stable == nil {
+		return chain.ErrExportRange
+	}
+	from, to := uint32(0), stable.Height()
+	if len(heights) > 0 {
+		from = heights[0]
+	}
+	if len(heights) > 1 {
+		to = heights[1]
+	}
+	// check the range before the file is created
+	if from > to || to > stable.Height() {
+		return chain.ErrExportRange
+	}
+
+	w, err := chain.CreateBlockFile(args[0])
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	count, err := bc.Export(w, from, to, *withTxs)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	log.Infof("export %d blocks to %s in %v", count, args[0], time.Since(start))
+	return nil
+}
+
+// verifyCommand replay the change logs of blocks in database, and report the accounts, assets, equities and candidates which
+// are different from the replayed state. It also reports the missing heights and the blocks which don't match their
+// LogRoot or VersionRoot. usage: verify
+func verifyCommand(cfg *config.Config, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: verify")
+	}
+
+	db := database.NewSqlDB(cfg.DbDriver, cfg.DbUri)
+	defer db.Close()
+	if err := database.CheckSchema(db); err != nil {
+		return err
+	}
+	bc, err := chain.NewBlockChain(uint16(cfg.ChainID), int(cfg.DeputyCount), cfg.UndoRetention, db)
+	if err != nil {
+		return err
+	}
+	// the state must not change while it is compared
+	release, err := holdLease(cfg, db, bc)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	start := time.Now()
+	count, err := bc.Verify(func(m *chain.Mismatch) {
+		log.Errorf("mismatch at %s", m)
+	})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		log.Errorf("found %d mismatches in %v", count, time.Since(start))
+		return ErrVerifyFailed
+	}
+	log.Infof("verify success in %v", time.Since(start))
+	return nil
+}
+
+// holdLease acquire the writer lease, and renew it until release is called. The chain stops writing if the lease is lost
+func holdLease(cfg *config.Config, db database.DBEngine, bc *chain.BlockChain) (release func(), err error) {
+	leaseDao := database.NewLeaseDao(db)
+	ttl := time.Duration(cfg.LeaseTTL) * time.Second
+	acquire := func() (bool, error) {
+		start := time.Now()
+		ok, err := leaseDao.Acquire(database.ContextKeyWriterLease, cfg.LeaseOwner(), ttl)
+		if ok {
+			bc.SetWriterLease(cfg.LeaseOwner(), start.Add(ttl))
+		}
+		return ok, err
+	}
+	ok, err := acquire()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrLeaseHeld
+	}
+
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				if ok, err := acquire(); err != nil {
+					log.Warnf("renew writer lease failed: %v", err)
+				} else if !ok {
+					log.Errorf("writer lease is taken by another node")
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(quit)
+		<-done
+		leaseDao.Release(database.ContextKeyWriterLease, cfg.LeaseOwner())
+	}, nil
+}
+
+// migrateCommand change the database schema. usage: migrate up [version] | down <version> | status
+func migrateCommand(cfg *config.Config, args []string) error {
+	usage := errors.New("usage: migrate up [version] | down <version> | status")
+	if len(args) < 1 || len(args) > 2 {
+		return usage
+	}
+
+	db := database.NewSqlDB(cfg.DbDriver, cfg.DbUri)
+	defer db.Close()
+	migrations, err := database.Migrations(db.GetDialect())
+	if err != nil {
+		return err
+	}
+	version := uint32(len(migrations))
+	if len(args) == 2 {
+		target, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return err
+		}
+		version = uint32(target)
+	}
+
+	switch {
+	case args[0] == "up":
+		err = database.MigrateUp(db, version)
+	case args[0] == "down" && len(args) == 2:
+		err = database.MigrateDown(db, version)
+	case args[0] == "status" && len(args) == 1:
+	default:
+		return usage
+	}
+	if err != nil {
+		return err
+	}
+
+	current, err := database.SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		status := "pending"
+		if m.Version <= current {
+			status = "applied"
+		}
+		fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, status)
+	}
+	fmt.Printf("schema version: %d, latest version: %d\n", current, len(migrations))
+	return nil
+}
+
+// attachCommand call the RPC methods through the IPC socket of the running node. It calls the method in args and exits,
+// or reads "<method> [params...]" from each line of stdin if there is no args. A param is parsed as json, or taken as a
+// string if it isn't json. usage: attach [method [params...]]
+func attachCommand(cfg *config.Config, args []string) error {
+	if cfg.Ipc.Disable {
+		return errors.New("ipc is disabled in config")
+	}
+	client, err := rpc.DialIPC(context.Background(), cfg.IpcEndpoint())
+	if err != nil {
+		return fmt.Errorf("attach %s failed: %v", cfg.IpcEndpoint(), err)
+	}
+	defer client.Close()
+
+	if len(args) > 0 {
+		return callRPC(client, args[0], args[1:])
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for fmt.Print("> "); scanner.Scan(); fmt.Print("> ") {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "exit" {
+			return nil
+		}
+		if err := callRPC(client, fields[0], fields[1:]); err != nil {
+			fmt.Println("Error:", err)
+		}
+	}
+	return scanner.Err()
+}
+
+// callRPC call the method and print the result as indented json
+func callRPC(client *rpc.Client, method string, params []string) error {
+	args := make([]interface{}, len(params))
+	for i, param := range params {
+		var value json.RawMessage
+		if json.Unmarshal([]byte(param), &value) == nil {
+			args[i] = value
+		} else {
+			args[i] = param
+		}
+	}
+	var result json.RawMessage
+	if err := client.Call(&result, method, args...); err != nil {
+		return err
+	}
+	// print the string result as it is, such as the stacks from debug_stacks
+	var text string
+	if json.Unmarshal(result, &text) == nil {
+		fmt.Println(text)
+		return nil
+	}
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
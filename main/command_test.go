@@ -0,0 +1,85 @@
+package main
+
+import (
+	"github.com/LemoFoundationLtd/lemochain-core/network/rpc"
+	"github.com/LemoFoundationLtd/lemochain-distribution/main/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type AttachService struct{}
+
+func (s *AttachService) Echo(value string) string {
+	return value
+}
+
+func (s *AttachService) Add(a, b int) map[string]int {
+	return map[string]int{"sum": a + b}
+}
+
+// captureOutput run f with stdin and return what it prints
+func captureOutput(t *testing.T, stdin string, f func()) string {
+	dir, err := ioutil.TempDir("", "lemo-attach-io")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	in, err := os.Create(dir + "/stdin")
+	assert.NoError(t, err)
+	in.WriteString(stdin)
+	in.Seek(0, 0)
+	out, err := os.Create(dir + "/stdout")
+	assert.NoError(t, err)
+
+	oldIn, oldOut := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = in, out
+	f()
+	os.Stdin, os.Stdout = oldIn, oldOut
+	in.Close()
+	out.Close()
+	result, err := ioutil.ReadFile(dir + "/stdout")
+	assert.NoError(t, err)
+	return string(result)
+}
+
+func TestAttachCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lemo-attach")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cfg := &config.Config{DataDir: dir, Ipc: config.Ipc{Path: "test.ipc"}}
+
+	// not running
+	assert.Error(t, attachCommand(cfg, []string{"test_echo", "a"}))
+
+	handler := rpc.NewServer()
+	assert.NoError(t, handler.RegisterName("test", &AttachService{}))
+	listener, err := rpc.CreateIPCListener(cfg.IpcEndpoint())
+	assert.NoError(t, err)
+	go handler.ServeListener(listener)
+	defer handler.Stop()
+	defer listener.Close()
+
+	// the method in args. A param which isn't json is a string
+	out := captureOutput(t, "", func() {
+		assert.NoError(t, attachCommand(cfg, []string{"test_echo", "a"}))
+	})
+	assert.Equal(t, "a\n", out)
+	out = captureOutput(t, "", func() {
+		assert.NoError(t, attachCommand(cfg, []string{"test_add", "1", "2"}))
+	})
+	assert.Equal(t, "{\n  \"sum\": 3\n}\n", out)
+	captureOutput(t, "", func() {
+		assert.Error(t, attachCommand(cfg, []string{"test_unknown"}))
+	})
+
+	// the methods in stdin, the errors are printed
+	out = captureOutput(t, "test_echo b\n\ntest_unknown\nexit\ntest_echo c\n", func() {
+		assert.NoError(t, attachCommand(cfg, nil))
+	})
+	assert.Contains(t, out, "> b\n")
+	assert.Contains(t, out, "> Error:")
+	assert.NotContains(t, out, "c\n")
+
+	cfg.Ipc.Disable = true
+	assert.Error(t, attachCommand(cfg, []string{"test_echo", "a"}))
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,11 +15,13 @@ import (
 	"github.com/LemoFoundationLtd/lemochain-core/common/hexutil"
 	"github.com/LemoFoundationLtd/lemochain-core/common/log"
 	"github.com/LemoFoundationLtd/lemochain-core/network/p2p"
+	"github.com/LemoFoundationLtd/lemochain-distribution/database"
 	"net"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -27,6 +31,17 @@ const (
 	DefaultHttpPort         = 8001
 	DefaultHttpVirtualHosts = "localhost"
 	DefaultWSPort           = 8002
+	DefaultMetricsPort      = 8003
+	DefaultIpcPath          = "lemo-distribution.ipc"
+	DefaultUndoRetention    = 10000
+	DefaultLeaseTTL         = 15 // seconds
+	DefaultDbMaxOpenConns   = 50
+	DefaultDbMaxIdleConns   = 10
+	DefaultDbConnIdleTime   = 300  // seconds
+	DefaultDbConnLifetime   = 3600 // seconds
+
+	ModeSync   = "sync"   // sync blocks from core node and write them to database
+	ModeReader = "reader" // only serve RPC from the database which is written by another node
 )
 
 var (
@@ -35,11 +50,16 @@ var (
 	ErrLogLevelInConfig      = fmt.Errorf(`file "%s" error: logLevel must be in [1, 5]`, JsonFileName)
 	ErrHttpPortInConfig      = fmt.Errorf(`file "%s" error: http port must be less than 65535`, JsonFileName)
 	ErrWebSocketPortInConfig = fmt.Errorf(`file "%s" error: websocket port must be less than 65535`, JsonFileName)
+	ErrMetricsPortInConfig   = fmt.Errorf(`file "%s" error: metrics port must be less than 65535`, JsonFileName)
+	ErrDbDriverInConfig      = fmt.Errorf(`file "%s" error: dbDriver must be mysql, postgres or sqlite3`, JsonFileName)
+	ErrModeInConfig          = fmt.Errorf(`file "%s" error: mode must be sync or reader`, JsonFileName)
 	ErrCoreNodeInConfig      = fmt.Errorf(`file "%s" error: coreNode must be like: 5e3600755f9b512a65603b38e30885c98cbac70259c3235c9b3f42ee563b480edea351ba0ff5748a638fe0aeff5d845bf37a3b437831871b48fd32f33cd9a3c0@127.0.0.1:60001`, JsonFileName)
 )
 
 //go:generate gencodec -type RpcHttp -field-override RpcMarshaling -out gen_http_json.go
 //go:generate gencodec -type RpcWS -field-override RpcMarshaling -out gen_ws_json.go
+//go:generate gencodec -type Metrics -field-override RpcMarshaling -out gen_metrics_json.go
+//go:generate gencodec -type DbPool -field-override DbPoolMarshaling -out gen_db_pool_json.go
 //go:generate gencodec -type Config -field-override ConfigMarshaling -out gen_config_json.go
 
 type RpcHttp struct {
@@ -59,22 +79,63 @@ type RpcWS struct {
 	CorsDomain string `json:"corsDomain"`
 }
 
+// Metrics is the config of the prometheus metrics endpoint "/metrics"
+type Metrics struct {
+	Disable bool   `json:"disable"`
+	Port    uint32 `json:"port"`
+}
+
+// Ipc is the config of the unix socket which serves the private APIs
+type Ipc struct {
+	Disable bool   `json:"disable"`
+	Path    string `json:"path"` // the socket file in data directory, or an absolute path
+}
+
+// DbPool is the connection pool config of the database. The times are in seconds
+type DbPool struct {
+	MaxOpenConns    uint32 `json:"maxOpenConns"`
+	MaxIdleConns    uint32 `json:"maxIdleConns"`
+	ConnMaxIdleTime uint32 `json:"connMaxIdleTime"`
+	ConnMaxLifetime uint32 `json:"connMaxLifetime"`
+}
+
+type DbPoolMarshaling struct {
+	MaxOpenConns    hexutil.Uint32
+	MaxIdleConns    hexutil.Uint32
+	ConnMaxIdleTime hexutil.Uint32
+	ConnMaxLifetime hexutil.Uint32
+}
+
 type Config struct {
-	ChainID         uint32  `json:"chainID"        gencodec:"required"`
-	DeputyCount     uint32  `json:"deputyCount"    gencodec:"required"`
-	TermDuration    uint64  `json:"termDuration"`
-	InterimDuration uint64  `json:"interimDuration"`
-	DbUri           string  `json:"dbUri"          gencodec:"required"` // sample: root:123123@tcp(localhost:3306)/lemochain?charset=utf8mb4
-	DbDriver        string  `json:"dbDriver"       gencodec:"required"`
-	LogLevel        uint32  `json:"logLevel"`
-	CoreNode        string  `json:"coreNode"       gencodec:"required"`
-	Http            RpcHttp `json:"http"`
-	WebSocket       RpcWS   `json:"webSocket"`
-
-	DataDir      string
-	nodeKey      *ecdsa.PrivateKey
-	coreNodeID   *p2p.NodeID
-	coreEndpoint string
+	ChainID         uint32   `json:"chainID"        gencodec:"required"`
+	DeputyCount     uint32   `json:"deputyCount"    gencodec:"required"`
+	TermDuration    uint64   `json:"termDuration"`
+	InterimDuration uint64   `json:"interimDuration"`
+	DbUri           string   `json:"dbUri"          gencodec:"required"` // sample: root:123123@tcp(localhost:3306)/lemochain?charset=utf8mb4
+	DbDriver        string   `json:"dbDriver"       gencodec:"required"` // mysql, postgres or sqlite3
+	DbPool          DbPool   `json:"dbPool"`
+	LogLevel        uint32   `json:"logLevel"`
+	CoreNode        string   `json:"coreNode"`  // required in sync mode if coreNodes is empty
+	CoreNodes       []string `json:"coreNodes"` // the upstreams to fail over
+	Http            RpcHttp  `json:"http"`
+	WebSocket       RpcWS    `json:"webSocket"`
+	Metrics         Metrics  `json:"metrics"`
+	Ipc             Ipc      `json:"ipc"`
+	UndoRetention   uint32   `json:"undoRetention"` // how many latest blocks can be reverted
+	Mode            string   `json:"mode"`          // sync or reader
+	LeaseTTL        uint32   `json:"leaseTTL"`      // seconds of the writer lease in sync mode
+	DevMode         bool     `json:"devMode"`       // expose the tx methods which take private keys. Never enable it in production
+
+	DataDir    string
+	nodeKey    *ecdsa.PrivateKey
+	coreNodes  []*CoreNodeAddr
+	leaseOwner string
+}
+
+// CoreNodeAddr is the parsed address of a core node
+type CoreNodeAddr struct {
+	NodeID   *p2p.NodeID
+	Endpoint string
 }
 
 type ConfigMarshaling struct {
@@ -83,6 +144,8 @@ type ConfigMarshaling struct {
 	TermDuration    hexutil.Uint64
 	InterimDuration hexutil.Uint64
 	LogLevel        hexutil.Uint32
+	UndoRetention   hexutil.Uint32
+	LeaseTTL        hexutil.Uint32
 }
 
 func ReadConfigFile() (*Config, error) {
@@ -120,12 +183,42 @@ func (c *Config) Check() {
 	if c.InterimDuration > 0 {
 		params.InterimDuration = uint32(c.InterimDuration)
 	}
+	if _, err := database.NewDialect(c.DbDriver); err != nil {
+		panic(ErrDbDriverInConfig)
+	}
+	if c.DbPool.MaxOpenConns == 0 {
+		c.DbPool.MaxOpenConns = DefaultDbMaxOpenConns
+	}
+	if c.DbPool.MaxIdleConns == 0 {
+		c.DbPool.MaxIdleConns = DefaultDbMaxIdleConns
+	}
+	if c.DbPool.MaxIdleConns > c.DbPool.MaxOpenConns {
+		c.DbPool.MaxIdleConns = c.DbPool.MaxOpenConns
+	}
+	if c.DbPool.ConnMaxIdleTime == 0 {
+		c.DbPool.ConnMaxIdleTime = DefaultDbConnIdleTime
+	}
+	if c.DbPool.ConnMaxLifetime == 0 {
+		c.DbPool.ConnMaxLifetime = DefaultDbConnLifetime
+	}
 	if c.LogLevel == 0 {
 		c.LogLevel = 4
 	}
 	if c.LogLevel > 5 {
 		panic(ErrLogLevelInConfig)
 	}
+	if c.UndoRetention == 0 {
+		c.UndoRetention = DefaultUndoRetention
+	}
+	if c.LeaseTTL == 0 {
+		c.LeaseTTL = DefaultLeaseTTL
+	}
+	if c.Mode == "" {
+		c.Mode = ModeSync
+	}
+	if c.Mode != ModeSync && c.Mode != ModeReader {
+		panic(ErrModeInConfig)
+	}
 	if !c.Http.Disable {
 		if c.Http.Port > 65535 {
 			panic(ErrHttpPortInConfig)
@@ -144,12 +237,31 @@ func (c *Config) Check() {
 			c.WebSocket.Port = DefaultWSPort
 		}
 	}
-	nodeID, endpoint := parseNodeString(c.CoreNode)
-	if nodeID == nil {
+	if !c.Metrics.Disable {
+		if c.Metrics.Port > 65535 {
+			panic(ErrMetricsPortInConfig)
+		} else if c.Metrics.Port == 0 {
+			c.Metrics.Port = DefaultMetricsPort
+		}
+	}
+	if !c.Ipc.Disable && c.Ipc.Path == "" {
+		c.Ipc.Path = DefaultIpcPath
+	}
+	nodes := c.CoreNodes
+	if c.CoreNode != "" {
+		nodes = append([]string{c.CoreNode}, nodes...)
+	}
+	c.coreNodes = nil
+	for _, node := range nodes {
+		nodeID, endpoint := parseNodeString(node)
+		if nodeID == nil {
+			panic(ErrCoreNodeInConfig)
+		}
+		c.coreNodes = append(c.coreNodes, &CoreNodeAddr{NodeID: nodeID, Endpoint: endpoint})
+	}
+	if len(c.coreNodes) == 0 && c.Mode == ModeSync {
 		panic(ErrCoreNodeInConfig)
 	}
-	c.coreNodeID = nodeID
-	c.coreEndpoint = endpoint
 }
 
 func (c *Config) NodeKey() *ecdsa.PrivateKey {
@@ -180,12 +292,41 @@ func (c *Config) NodeKey() *ecdsa.PrivateKey {
 	return key
 }
 
-func (c *Config) CoreNodeID() *p2p.NodeID {
-	return c.coreNodeID
+// DbPoolConfig return the connection pool config for database.SqlDB
+func (c *Config) DbPoolConfig() database.PoolConfig {
+	return database.PoolConfig{
+		MaxOpenConns:    int(c.DbPool.MaxOpenConns),
+		MaxIdleConns:    int(c.DbPool.MaxIdleConns),
+		ConnMaxIdleTime: time.Duration(c.DbPool.ConnMaxIdleTime) * time.Second,
+		ConnMaxLifetime: time.Duration(c.DbPool.ConnMaxLifetime) * time.Second,
+	}
+}
+
+// IpcEndpoint return the path of the unix socket
+func (c *Config) IpcEndpoint() string {
+	if filepath.IsAbs(c.Ipc.Path) {
+		return c.Ipc.Path
+	}
+	return filepath.Join(c.DataDir, c.Ipc.Path)
+}
+
+// LeaseOwner return the id of the node in the writer lease. It is the public key of node key with a random suffix of the
+// process, so the nodes started from a cloned data directory are different owners
+func (c *Config) LeaseOwner() string {
+	if c.leaseOwner != "" {
+		return c.leaseOwner
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		log.Critf("Failed to generate lease owner: %v", err)
+	}
+	c.leaseOwner = common.ToHex(crypto.FromECDSAPub(&c.NodeKey().PublicKey)[1:]) + "-" + hex.EncodeToString(suffix)
+	return c.leaseOwner
 }
 
-func (c *Config) CoreEndpoint() string {
-	return c.coreEndpoint
+// CoreNodeAddrs return the core nodes in "coreNode" and "coreNodes"
+func (c *Config) CoreNodeAddrs() []*CoreNodeAddr {
+	return c.coreNodes
 }
 
 // parseNodeString verify node address
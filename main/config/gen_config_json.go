@@ -20,10 +20,18 @@ func (c Config) MarshalJSON() ([]byte, error) {
 		InterimDuration hexutil.Uint64 `json:"interimDuration"`
 		DbUri           string         `json:"dbUri"          gencodec:"required"`
 		DbDriver        string         `json:"dbDriver"       gencodec:"required"`
+		DbPool          DbPool         `json:"dbPool"`
 		LogLevel        hexutil.Uint32 `json:"logLevel"`
-		CoreNode        string         `json:"coreNode"       gencodec:"required"`
+		CoreNode        string         `json:"coreNode"`
+		CoreNodes       []string       `json:"coreNodes"`
 		Http            RpcHttp        `json:"http"`
 		WebSocket       RpcWS          `json:"webSocket"`
+		Metrics         Metrics        `json:"metrics"`
+		Ipc             Ipc            `json:"ipc"`
+		UndoRetention   hexutil.Uint32 `json:"undoRetention"`
+		Mode            string         `json:"mode"`
+		LeaseTTL        hexutil.Uint32 `json:"leaseTTL"`
+		DevMode         bool           `json:"devMode"`
 		DataDir         string
 	}
 	var enc Config
@@ -33,10 +41,18 @@ func (c Config) MarshalJSON() ([]byte, error) {
 	enc.InterimDuration = hexutil.Uint64(c.InterimDuration)
 	enc.DbUri = c.DbUri
 	enc.DbDriver = c.DbDriver
+	enc.DbPool = c.DbPool
 	enc.LogLevel = hexutil.Uint32(c.LogLevel)
 	enc.CoreNode = c.CoreNode
+	enc.CoreNodes = c.CoreNodes
 	enc.Http = c.Http
 	enc.WebSocket = c.WebSocket
+	enc.Metrics = c.Metrics
+	enc.Ipc = c.Ipc
+	enc.UndoRetention = hexutil.Uint32(c.UndoRetention)
+	enc.Mode = c.Mode
+	enc.LeaseTTL = hexutil.Uint32(c.LeaseTTL)
+	enc.DevMode = c.DevMode
 	enc.DataDir = c.DataDir
 	return json.Marshal(&enc)
 }
@@ -50,10 +66,18 @@ func (c *Config) UnmarshalJSON(input []byte) error {
 		InterimDuration *hexutil.Uint64 `json:"interimDuration"`
 		DbUri           *string         `json:"dbUri"          gencodec:"required"`
 		DbDriver        *string         `json:"dbDriver"       gencodec:"required"`
+		DbPool          *DbPool         `json:"dbPool"`
 		LogLevel        *hexutil.Uint32 `json:"logLevel"`
-		CoreNode        *string         `json:"coreNode"       gencodec:"required"`
+		CoreNode        *string         `json:"coreNode"`
+		CoreNodes       []string        `json:"coreNodes"`
 		Http            *RpcHttp        `json:"http"`
 		WebSocket       *RpcWS          `json:"webSocket"`
+		Metrics         *Metrics        `json:"metrics"`
+		Ipc             *Ipc            `json:"ipc"`
+		UndoRetention   *hexutil.Uint32 `json:"undoRetention"`
+		Mode            *string         `json:"mode"`
+		LeaseTTL        *hexutil.Uint32 `json:"leaseTTL"`
+		DevMode         *bool           `json:"devMode"`
 		DataDir         *string
 	}
 	var dec Config
@@ -82,19 +106,42 @@ func (c *Config) UnmarshalJSON(input []byte) error {
 		return errors.New("missing required field 'dbDriver' for Config")
 	}
 	c.DbDriver = *dec.DbDriver
+	if dec.DbPool != nil {
+		c.DbPool = *dec.DbPool
+	}
 	if dec.LogLevel != nil {
 		c.LogLevel = uint32(*dec.LogLevel)
 	}
-	if dec.CoreNode == nil {
-		return errors.New("missing required field 'coreNode' for Config")
+	if dec.CoreNode != nil {
+		c.CoreNode = *dec.CoreNode
+	}
+	if dec.CoreNodes != nil {
+		c.CoreNodes = dec.CoreNodes
 	}
-	c.CoreNode = *dec.CoreNode
 	if dec.Http != nil {
 		c.Http = *dec.Http
 	}
 	if dec.WebSocket != nil {
 		c.WebSocket = *dec.WebSocket
 	}
+	if dec.Metrics != nil {
+		c.Metrics = *dec.Metrics
+	}
+	if dec.Ipc != nil {
+		c.Ipc = *dec.Ipc
+	}
+	if dec.UndoRetention != nil {
+		c.UndoRetention = uint32(*dec.UndoRetention)
+	}
+	if dec.Mode != nil {
+		c.Mode = *dec.Mode
+	}
+	if dec.LeaseTTL != nil {
+		c.LeaseTTL = uint32(*dec.LeaseTTL)
+	}
+	if dec.DevMode != nil {
+		c.DevMode = *dec.DevMode
+	}
 	if dec.DataDir != nil {
 		c.DataDir = *dec.DataDir
 	}
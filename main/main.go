@@ -24,6 +24,12 @@ func main() {
 
 	deputynode.SetSelfNodeKey(cfg.NodeKey())
 	coreNode.InitLogConfig(int(cfg.LogLevel) - 1)
+	if len(os.Args) > 2 {
+		if err := runCommand(cfg, os.Args[2], os.Args[3:]); err != nil {
+			panic(fmt.Sprintf("run command %s failed: %v", os.Args[2], err))
+		}
+		return
+	}
 	if err := startServer(cfg); err != nil {
 		panic(fmt.Sprintf("start server failed: %v", err))
 	}
@@ -12,15 +12,21 @@ import (
 	"github.com/LemoFoundationLtd/lemochain-core/common/hexutil"
 	"github.com/LemoFoundationLtd/lemochain-core/common/log"
 	"github.com/LemoFoundationLtd/lemochain-core/store"
+	"github.com/LemoFoundationLtd/lemochain-distribution/chain"
 	"github.com/LemoFoundationLtd/lemochain-distribution/chain/params"
 	"github.com/LemoFoundationLtd/lemochain-distribution/database"
 	"math/big"
+	"runtime"
+	"strings"
 	"time"
 )
 
 const (
 	MaxTxToNameLength  = 100
 	MaxTxMessageLength = 1024
+	MaxLogsResult      = 10000 // the max count of events returned by GetLogs
+	// DefaultUnlockDuration is the seconds to keep an account unlocked if the duration is not specified
+	DefaultUnlockDuration = 300
 )
 
 var (
@@ -29,6 +35,7 @@ var (
 	ErrCreateContract = errors.New("the data of create contract transaction can't be null")
 	ErrSpecialTx      = errors.New("the data of special transaction can't be null")
 	ErrTxType         = errors.New("the transaction type does not exit")
+	ErrReaderMode     = errors.New("the node is in reader mode")
 )
 
 // Private
@@ -41,13 +48,107 @@ func NewPrivateAccountAPI(node *Node) *PrivateAccountAPI {
 	return &PrivateAccountAPI{node: node}
 }
 
-// NewAccount get lemo address api
-func (a *PrivateAccountAPI) NewKeyPair() (*crypto.AccountKey, error) {
-	accountKey, err := crypto.GenerateAddress()
+// NewAccount create a key encrypted by passphrase in keystore
+func (a *PrivateAccountAPI) NewAccount(passphrase string) (common.Address, error) {
+	return a.node.keystore.NewAccount(passphrase)
+}
+
+// ImportRawKey save the hex private key encrypted by passphrase in keystore
+func (a *PrivateAccountAPI) ImportRawKey(privateKey string, passphrase string) (common.Address, error) {
+	private, err := crypto.HexToECDSA(strings.TrimPrefix(privateKey, "0x"))
 	if err != nil {
-		return nil, err
+		return common.Address{}, err
+	}
+	return a.node.keystore.Import(private, passphrase)
+}
+
+// ListAccounts return the addresses in keystore
+func (a *PrivateAccountAPI) ListAccounts() ([]common.Address, error) {
+	return a.node.keystore.Accounts()
+}
+
+// UnlockAccount keep the decrypted key in memory for duration seconds. It is DefaultUnlockDuration if duration is
+// absent, and 0 means until LockAccount
+func (a *PrivateAccountAPI) UnlockAccount(addr common.Address, passphrase string, duration *uint64) (bool, error) {
+	seconds := uint64(DefaultUnlockDuration)
+	if duration != nil {
+		seconds = *duration
 	}
-	return accountKey, nil
+	if err := a.node.keystore.Unlock(addr, passphrase, time.Duration(seconds)*time.Second); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// LockAccount remove the decrypted key from memory
+func (a *PrivateAccountAPI) LockAccount(addr common.Address) bool {
+	a.node.keystore.Lock(addr)
+	return true
+}
+
+// SignTx sign the tx by the unlocked account. The account signs as the gas payer if it is the gas payer of a
+// reimbursed tx, otherwise it signs as the sender or one of the signers of a multisig sender
+func (a *PrivateAccountAPI) SignTx(addr common.Address, tx *types.Transaction) (*types.Transaction, error) {
+	var signer types.Signer
+	if tx.GasPayer() == tx.From() {
+		signer = types.MakeSigner()
+	} else if addr == tx.GasPayer() {
+		signer = types.MakeGasPayerSigner()
+	} else {
+		signer = types.MakeReimbursementTxSigner()
+	}
+	return a.node.keystore.SignTx(addr, tx, signer)
+}
+
+// SendTx sign the tx by the unlocked account and send it
+func (a *PrivateAccountAPI) SendTx(addr common.Address, tx *types.Transaction) (common.Hash, error) {
+	signed, err := a.SignTx(addr, tx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return NewPublicTxAPI(a.node).SendTx(signed)
+}
+
+// PrivateAdminAPI API for node administration
+type PrivateAdminAPI struct {
+	node *Node
+}
+
+// NewPrivateAdminAPI
+func NewPrivateAdminAPI(node *Node) *PrivateAdminAPI {
+	return &PrivateAdminAPI{node: node}
+}
+
+// RevertTo revert the database to the block at height by the undo journal. The reverted blocks will be synced from core node again
+func (a *PrivateAdminAPI) RevertTo(height uint32) error {
+	if a.node.isReader() {
+		return ErrReaderMode
+	}
+	return a.node.chain.RevertTo(height)
+}
+
+// PrivateDebugAPI API for inspecting the running node
+type PrivateDebugAPI struct {
+	node *Node
+}
+
+// NewPrivateDebugAPI
+func NewPrivateDebugAPI(node *Node) *PrivateDebugAPI {
+	return &PrivateDebugAPI{node: node}
+}
+
+// Stacks return the stacks of all goroutines
+func (d *PrivateDebugAPI) Stacks() string {
+	buf := make([]byte, 1024*1024)
+	buf = buf[:runtime.Stack(buf, true)]
+	return string(buf)
+}
+
+// MemStats return the memory allocator statistics
+func (d *PrivateDebugAPI) MemStats() *runtime.MemStats {
+	stats := new(runtime.MemStats)
+	runtime.ReadMemStats(stats)
+	return stats
 }
 
 // PublicAccountAPI API for access to account information
@@ -78,8 +179,7 @@ func (a *PublicAccountAPI) GetAccount(LemoAddress string) (*types.AccountData, e
 		return nil, err
 	}
 
-	dbEngine := database.NewMySqlDB(a.node.config.DbDriver, a.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := a.node.dbEngine
 
 	accountDao := database.NewAccountDao(dbEngine)
 	accountData, err := accountDao.Get(address)
@@ -96,8 +196,7 @@ func (a *PublicAccountAPI) GetEquity(LemoAddress string, assetId common.Hash) (*
 		return nil, err
 	}
 
-	dbEngine := database.NewMySqlDB(a.node.config.DbDriver, a.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := a.node.dbEngine
 
 	equityDao := database.NewEquityDao(dbEngine)
 	return equityDao.Get(address, assetId)
@@ -119,8 +218,7 @@ func (a *PublicAccountAPI) GetEquityList(LemoAddress string, index, limit int) (
 		return nil, err
 	}
 
-	dbEngine := database.NewMySqlDB(a.node.config.DbDriver, a.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := a.node.dbEngine
 
 	equityDao := database.NewEquityDao(dbEngine)
 	result, total, err := equityDao.GetPageWithTotal(address, index, limit)
@@ -140,8 +238,7 @@ func (a *PublicAccountAPI) GetEquityListByAssetCode(LemoAddress string, assetCod
 		return nil, err
 	}
 
-	dbEngine := database.NewMySqlDB(a.node.config.DbDriver, a.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := a.node.dbEngine
 
 	equityDao := database.NewEquityDao(dbEngine)
 	result, total, err := equityDao.GetPageByCodeWithTotal(address, assetCode, index, limit)
@@ -156,16 +253,14 @@ func (a *PublicAccountAPI) GetEquityListByAssetCode(LemoAddress string, assetCod
 }
 
 func (a *PublicAccountAPI) GetAsset(assetCode common.Hash) (*types.Asset, error) {
-	dbEngine := database.NewMySqlDB(a.node.config.DbDriver, a.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := a.node.dbEngine
 
 	assetDao := database.NewAssetDao(dbEngine)
 	return assetDao.Get(assetCode)
 }
 
 func (a *PublicAccountAPI) GetAssetToken(assetId common.Hash) (*database.AssetToken, error) {
-	dbEngine := database.NewMySqlDB(a.node.config.DbDriver, a.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := a.node.dbEngine
 
 	AssetTokenDao := database.NewAssetTokenDao(dbEngine)
 	return AssetTokenDao.Get(assetId)
@@ -220,8 +315,7 @@ type deputyNodeInfoMarshaling struct {
 // GetAllRewardValue get the value for each bonus
 func (c *PublicChainAPI) GetAllRewardValue() (coreParams.RewardsMap, error) {
 	address := coreParams.TermRewardContract
-	dbEngine := database.NewMySqlDB(c.node.config.DbDriver, c.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := c.node.dbEngine
 	kvDao := database.NewKvDao(dbEngine)
 
 	value, err := kvDao.Get(database.GetStorageKey(address.Hash()))
@@ -273,8 +367,7 @@ func (c *PublicChainAPI) GetTermReward(height uint32) (*TermRewardInfo, error) {
 // GetDeputyNodeList get deputy nodes who are in charge
 func (c *PublicChainAPI) GetDeputyNodeList(onlyBlockSigner bool) []*DeputyNodeInfo {
 	nodes := c.node.chain.DeputyManager().GetDeputiesByHeight(c.node.chain.StableBlock().Height(), onlyBlockSigner)
-	dbEngine := database.NewMySqlDB(c.node.config.DbDriver, c.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := c.node.dbEngine
 
 	accountDao := database.NewAccountDao(dbEngine)
 
@@ -313,8 +406,7 @@ func (c *PublicChainAPI) GetDeputyNodeList(onlyBlockSigner bool) []*DeputyNodeIn
 
 // // GetCandidateNodeList get all candidate node list information and return total candidate node
 func (c *PublicChainAPI) GetCandidateList(index, size int) (*CandidateListRes, error) {
-	dbEngine := database.NewMySqlDB(c.node.config.DbDriver, c.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := c.node.dbEngine
 
 	candidateDao := database.NewCandidateDao(dbEngine)
 	candidates, total, err := candidateDao.GetPageWithTotal(index, size)
@@ -347,8 +439,7 @@ func (c *PublicChainAPI) GetCandidateList(index, size int) (*CandidateListRes, e
 func (c *PublicChainAPI) GetCandidateTop30() []*CandidateInfo {
 	result := make([]*CandidateInfo, 0)
 
-	dbEngine := database.NewMySqlDB(c.node.config.DbDriver, c.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := c.node.dbEngine
 
 	candidateDao := database.NewCandidateDao(dbEngine)
 	candidateItems, err := candidateDao.GetTop(20)
@@ -374,8 +465,7 @@ func (c *PublicChainAPI) GetCandidateTop30() []*CandidateInfo {
 
 // GetBlockByNumber get block information by height
 func (c *PublicChainAPI) GetBlockByHeight(height uint32, withBody bool) *types.Block {
-	dbEngine := database.NewMySqlDB(c.node.config.DbDriver, c.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := c.node.dbEngine
 
 	blockDao := database.NewBlockDao(dbEngine)
 	block, err := blockDao.GetBlockByHeight(height)
@@ -395,8 +485,7 @@ func (c *PublicChainAPI) GetBlockByHeight(height uint32, withBody bool) *types.B
 
 // GetBlockByHash get block information by hash
 func (c *PublicChainAPI) GetBlockByHash(hash string, withBody bool) *types.Block {
-	dbEngine := database.NewMySqlDB(c.node.config.DbDriver, c.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := c.node.dbEngine
 
 	blockDao := database.NewBlockDao(dbEngine)
 	block, err := blockDao.GetBlock(common.HexToHash(hash))
@@ -414,6 +503,144 @@ func (c *PublicChainAPI) GetBlockByHash(hash string, withBody bool) *types.Block
 	}
 }
 
+//go:generate gencodec -type BlockSummary --field-override blockSummaryMarshaling -out gen_block_summary_json.go
+type BlockSummary struct {
+	Height       uint32         `json:"height" gencodec:"required"`
+	Hash         common.Hash    `json:"hash" gencodec:"required"`
+	ParentHash   common.Hash    `json:"parentHash" gencodec:"required"`
+	MinerAddress common.Address `json:"miner" gencodec:"required"`
+	Time         uint32         `json:"timestamp" gencodec:"required"`
+	TxCount      uint32         `json:"txCount" gencodec:"required"`
+	GasUsed      uint64         `json:"gasUsed" gencodec:"required"`
+	EventCount   uint32         `json:"eventCount" gencodec:"required"`
+}
+type blockSummaryMarshaling struct {
+	Height     hexutil.Uint32
+	Time       hexutil.Uint32
+	TxCount    hexutil.Uint32
+	GasUsed    hexutil.Uint64
+	EventCount hexutil.Uint32
+}
+
+//go:generate gencodec -type BlockListRes --field-override blockListResMarshaling -out gen_block_list_res_json.go
+type BlockListRes struct {
+	BlockList []*BlockSummary `json:"blockList" gencodec:"required"`
+	Total     uint32          `json:"total" gencodec:"required"`
+}
+type blockListResMarshaling struct {
+	Total hexutil.Uint32
+}
+
+func newBlockListRes(rows []*database.BlockRow, total int) *BlockListRes {
+	result := make([]*BlockSummary, len(rows))
+	for index, row := range rows {
+		result[index] = &BlockSummary{
+			Height:       row.Height,
+			Hash:         row.Hash,
+			ParentHash:   row.ParentHash,
+			MinerAddress: row.Miner,
+			Time:         row.Time,
+			TxCount:      uint32(row.TxCount),
+			GasUsed:      row.GasUsed,
+			EventCount:   uint32(row.EventCount),
+		}
+	}
+	return &BlockListRes{
+		BlockList: result,
+		Total:     uint32(total),
+	}
+}
+
+// GetBlockListByMiner get the blocks mined by the address, from the highest one
+func (c *PublicChainAPI) GetBlockListByMiner(minerAddress string, index, size int) (*BlockListRes, error) {
+	miner, err := common.StringToAddress(minerAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	blockDao := database.NewBlockDao(c.node.dbEngine)
+	rows, total, err := blockDao.GetRowsByMinerWithTotal(miner, index, size)
+	if err != nil {
+		return nil, err
+	}
+	return newBlockListRes(rows, total), nil
+}
+
+// GetBlockListByTime get the blocks whose timestamp is between beginTime and endTime (in seconds), from the highest one
+func (c *PublicChainAPI) GetBlockListByTime(beginTime, endTime uint32, index, size int) (*BlockListRes, error) {
+	blockDao := database.NewBlockDao(c.node.dbEngine)
+	rows, total, err := blockDao.GetRowsByTimeWithTotal(beginTime, endTime, index, size)
+	if err != nil {
+		return nil, err
+	}
+	return newBlockListRes(rows, total), nil
+}
+
+// GetBlockList get the blocks whose height is between fromHeight and toHeight, from the highest one
+func (c *PublicChainAPI) GetBlockList(fromHeight, toHeight uint32, index, size int) (*BlockListRes, error) {
+	blockDao := database.NewBlockDao(c.node.dbEngine)
+	rows, total, err := blockDao.GetRowsByHeightWithTotal(fromHeight, toHeight, index, size)
+	if err != nil {
+		return nil, err
+	}
+	return newBlockListRes(rows, total), nil
+}
+
+// SearchBlockList get the blocks mined by the address in the time window (in seconds), from the highest one. The blocks of
+// all miners are returned if minerAddress is empty
+func (c *PublicChainAPI) SearchBlockList(minerAddress string, beginTime, endTime uint32, index, size int) (*BlockListRes, error) {
+	miner := common.Address{}
+	if minerAddress != "" {
+		var err error
+		if miner, err = common.StringToAddress(minerAddress); err != nil {
+			return nil, err
+		}
+	}
+
+	blockDao := database.NewBlockDao(c.node.dbEngine)
+	rows, total, err := blockDao.GetRowsByMinerAndTimeWithTotal(miner, beginTime, endTime, index, size)
+	if err != nil {
+		return nil, err
+	}
+	return newBlockListRes(rows, total), nil
+}
+
+// LogFilterArgs is the filter of GetLogs. ToHeight is the current block if it is absent. Topics[i] is the candidates of
+// the i-th topic, and a null Topics[i] matches any topic
+type LogFilterArgs struct {
+	FromHeight uint32          `json:"fromHeight"`
+	ToHeight   *uint32         `json:"toHeight"`
+	Addresses  []string        `json:"addresses"`
+	Topics     [][]common.Hash `json:"topics"`
+}
+
+// GetLogs get the events logged by contracts, which are matched by the filter. The transactionHash of event is null if
+// the block has more than one tx, and the logIndex follows the order of contracts in block
+func (c *PublicChainAPI) GetLogs(args LogFilterArgs) ([]*database.Event, error) {
+	dbEngine := c.node.dbEngine
+
+	filter := &database.EventFilter{FromHeight: args.FromHeight, Topics: args.Topics}
+	if args.ToHeight != nil {
+		filter.ToHeight = *args.ToHeight
+	} else {
+		current, err := database.NewContextDao(dbEngine).GetCurrentBlock()
+		if err != nil {
+			return nil, err
+		}
+		filter.ToHeight = current.Height()
+	}
+	for _, lemoAddress := range args.Addresses {
+		address, err := common.StringToAddress(lemoAddress)
+		if err != nil {
+			return nil, err
+		}
+		filter.Addresses = append(filter.Addresses, address)
+	}
+
+	eventDao := database.NewEventDao(dbEngine)
+	return eventDao.GetByFilter(filter, MaxLogsResult)
+}
+
 // ChainID get chain id
 func (c *PublicChainAPI) ChainID() uint16 {
 	return c.node.chain.ChainID()
@@ -426,8 +653,7 @@ func (c *PublicChainAPI) Genesis() *types.Block {
 
 // CurrentBlock get the current latest block
 func (c *PublicChainAPI) CurrentBlock(withBody bool) *types.Block {
-	dbEngine := database.NewMySqlDB(c.node.config.DbDriver, c.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := c.node.dbEngine
 
 	contextDao := database.NewContextDao(dbEngine)
 	block, err := contextDao.GetCurrentBlock()
@@ -467,187 +693,336 @@ func NewPublicTxAPI(node *Node) *PublicTxAPI {
 	return &PublicTxAPI{node}
 }
 
-// Send send a transaction
+// SendTx submit a signed transaction, such as the tx built by the Build methods and signed offline
 func (t *PublicTxAPI) SendTx(tx *types.Transaction) (common.Hash, error) {
-	err := tx.VerifyTxBody(t.node.chain.ChainID(), uint64(time.Now().Unix()), false)
-	if err != nil {
-		return common.Hash{}, err
+	if t.node.isReader() {
+		return common.Hash{}, ErrReaderMode
 	}
-	err = t.node.txPool.AddTx(tx)
+	err := t.node.txPool.AddTx(tx)
 	return tx.Hash(), err
 }
 
-// SendReimbursedGasTx gas代付交易 todo 测试使用
-func (t *PublicTxAPI) SendReimbursedGasTx(senderPrivate, gasPayerPrivate string, from, to, gasPayer common.Address, amount int64, data []byte, txType uint16, toName, message string) (common.Hash, error) {
-	tx := types.NewReimbursementTransaction(from, to, gasPayer, big.NewInt(amount), data, txType, t.node.chain.ChainID(), uint64(time.Now().Unix()+1800), toName, message)
-	senderPriv, _ := crypto.HexToECDSA(senderPrivate)
-	gasPayerPriv, _ := crypto.HexToECDSA(gasPayerPrivate)
-	firstSignTx, err := types.MakeReimbursementTxSigner().SignTx(tx, senderPriv)
-	if err != nil {
-		return common.Hash{}, err
+// BuildGasLimit is the gas limit of the txs built by the node
+const BuildGasLimit = 500000
+
+//go:generate gencodec -type UnsignedTxRes -out gen_unsigned_tx_res_json.go
+type UnsignedTxRes struct {
+	Tx       *types.Transaction `json:"tx" gencodec:"required"`
+	SignHash common.Hash        `json:"signHash" gencodec:"required"` // the hash to sign offline. The signature is appended to sigs, or gasPayerSigs for the gas payer
+}
+
+func newUnsignedTxRes(tx *types.Transaction, signer types.Signer) *UnsignedTxRes {
+	return &UnsignedTxRes{Tx: tx, SignHash: signer.Hash(tx)}
+}
+
+// toBig convert the decimal string parameter, and nil is zero
+func toBig(value *hexutil.Big10) *big.Int {
+	if value == nil {
+		return new(big.Int)
 	}
-	signTx := types.GasPayerSignatureTx(firstSignTx, common.Big1, uint64(60000))
-	lastSignTx, err := types.MakeGasPayerSigner().SignTx(signTx, gasPayerPriv)
-	if err != nil {
-		return common.Hash{}, err
+	return (*big.Int)(value)
+}
+
+// BuildTxOptions override the gas price and expiration of the built tx. The gas price is params.MinGasPrice and the tx
+// expires after params.MaxTxLifeTime seconds if they are absent
+type BuildTxOptions struct {
+	GasPrice   *hexutil.Big10 `json:"gasPrice"`
+	Expiration *uint64        `json:"expiration"` // unix seconds
+}
+
+func (opts *BuildTxOptions) gasPrice() *big.Int {
+	if opts == nil || opts.GasPrice == nil {
+		return coreParams.MinGasPrice
 	}
-	err = lastSignTx.VerifyTxBody(t.node.chain.ChainID(), uint64(time.Now().Unix()), false)
-	if err != nil {
-		return common.Hash{}, err
+	return (*big.Int)(opts.GasPrice)
+}
+
+func (opts *BuildTxOptions) expiration() uint64 {
+	if opts == nil || opts.Expiration == nil {
+		return uint64(time.Now().Unix()) + uint64(coreParams.MaxTxLifeTime)
 	}
-	err = t.node.txPool.AddTx(lastSignTx)
-	return lastSignTx.Hash(), err
+	return *opts.Expiration
 }
 
-// CreateAsset 创建资产
-func (t *PublicTxAPI) CreateAsset(prv string, category, decimals uint32, isReplenishable, isDivisible bool) (common.Hash, error) {
-	private, _ := crypto.HexToECDSA(prv)
-	issuer := crypto.PubkeyToAddress(private.PublicKey)
-	profile := make(types.Profile)
-	profile[types.AssetName] = "Demo Token"
-	profile[types.AssetSymbol] = "DT"
-	profile[types.AssetDescription] = "test issue token"
-	profile[types.AssetFreeze] = "false"
-	profile[types.AssetSuggestedGasLimit] = "60000"
+// BuildReimbursedGasTx build a tx whose gas is paid by gasPayer. The sender signs it first, then the gas payer sets the
+// gas by BuildGasPayerTx and signs. The gas price in opts is ignored
+func (t *PublicTxAPI) BuildReimbursedGasTx(from, to, gasPayer common.Address, amount *hexutil.Big10, data hexutil.Bytes, txType uint16, toName, message string, opts *BuildTxOptions) *UnsignedTxRes {
+	tx := types.NewReimbursementTransaction(from, to, gasPayer, toBig(amount), data, txType, t.node.chain.ChainID(), opts.expiration(), toName, message)
+	return newUnsignedTxRes(tx, types.MakeReimbursementTxSigner())
+}
+
+// BuildGasPayerTx set the gas of a reimbursed tx signed by the sender, and return the hash for the gas payer to sign
+func (t *PublicTxAPI) BuildGasPayerTx(tx *types.Transaction, gasPrice *hexutil.Big10, gasLimit uint64) (*UnsignedTxRes, error) {
+	if len(tx.Sigs()) == 0 {
+		return nil, types.ErrNoSignsData
+	}
+	tx = types.GasPayerSignatureTx(tx, toBig(gasPrice), gasLimit)
+	return newUnsignedTxRes(tx, types.MakeGasPayerSigner()), nil
+}
+
+// BuildCreateAssetTx build a tx to create asset. The total supply is 0 until the asset is issued
+func (t *PublicTxAPI) BuildCreateAssetTx(issuer common.Address, category, decimals uint32, isReplenishable, isDivisible bool, profile types.Profile, opts *BuildTxOptions) (*UnsignedTxRes, error) {
+	return t.buildCreateAssetTx(issuer, category, decimals, isReplenishable, isDivisible, profile, big.NewInt(0), opts)
+}
+
+func (t *PublicTxAPI) buildCreateAssetTx(issuer common.Address, category, decimals uint32, isReplenishable, isDivisible bool, profile types.Profile, totalSupply *big.Int, opts *BuildTxOptions) (*UnsignedTxRes, error) {
 	asset := &types.Asset{
 		Category:        category,
 		IsDivisible:     isDivisible,
 		AssetCode:       common.Hash{},
 		Decimal:         decimals,
-		TotalSupply:     big.NewInt(1000000),
+		TotalSupply:     totalSupply,
 		IsReplenishable: isReplenishable,
 		Issuer:          issuer,
 		Profile:         profile,
 	}
 	data, err := json.Marshal(asset)
 	if err != nil {
-		return common.Hash{}, err
-	}
-	tx := types.NoReceiverTransaction(issuer, nil, uint64(500000), big.NewInt(1), data, coreParams.CreateAssetTx, t.node.chain.ChainID(), uint64(time.Now().Unix()+30*60), "", "create asset tx")
-	signTx, err := types.MakeSigner().SignTx(tx, private)
-	if err != nil {
-		return common.Hash{}, err
+		return nil, err
 	}
-	return t.SendTx(signTx)
+	tx := types.NoReceiverTransaction(issuer, nil, BuildGasLimit, opts.gasPrice(), data, coreParams.CreateAssetTx, t.node.chain.ChainID(), opts.expiration(), "", "create asset tx")
+	return newUnsignedTxRes(tx, types.MakeSigner()), nil
 }
 
-// 发行资产
-func (t *PublicTxAPI) IssueAsset(prv string, receiver common.Address, assetCode common.Hash, amount *big.Int, metaData string) (common.Hash, error) {
+// BuildIssueAssetTx build a tx to issue asset to receiver
+func (t *PublicTxAPI) BuildIssueAssetTx(issuer, receiver common.Address, assetCode common.Hash, amount *hexutil.Big10, metaData string, opts *BuildTxOptions) (*UnsignedTxRes, error) {
 	issue := &types.IssueAsset{
 		AssetCode: assetCode,
 		MetaData:  metaData,
-		Amount:    amount,
+		Amount:    toBig(amount),
 	}
 	data, err := json.Marshal(issue)
 	if err != nil {
-		return common.Hash{}, err
-	}
-	private, _ := crypto.HexToECDSA(prv)
-	sender := crypto.PubkeyToAddress(private.PublicKey)
-	tx := types.NewTransaction(sender, receiver, nil, uint64(500000), big.NewInt(1), data, coreParams.IssueAssetTx, t.node.chain.ChainID(), uint64(time.Now().Unix()+30*60), "", "issue asset tx")
-
-	signTx, err := types.MakeSigner().SignTx(tx, private)
-	if err != nil {
-		return common.Hash{}, err
+		return nil, err
 	}
-	return t.SendTx(signTx)
+	tx := types.NewTransaction(issuer, receiver, nil, BuildGasLimit, opts.gasPrice(), data, coreParams.IssueAssetTx, t.node.chain.ChainID(), opts.expiration(), "", "issue asset tx")
+	return newUnsignedTxRes(tx, types.MakeSigner()), nil
 }
 
-// 增发资产
-func (t *PublicTxAPI) ReplenishAsset(prv string, receiver common.Address, assetCode, assetId common.Hash, amount *big.Int) (common.Hash, error) {
+// BuildReplenishAssetTx build a tx to replenish asset to receiver
+func (t *PublicTxAPI) BuildReplenishAssetTx(issuer, receiver common.Address, assetCode, assetId common.Hash, amount *hexutil.Big10, opts *BuildTxOptions) (*UnsignedTxRes, error) {
 	repl := &types.ReplenishAsset{
 		AssetCode: assetCode,
 		AssetId:   assetId,
-		Amount:    amount,
+		Amount:    toBig(amount),
 	}
 	data, err := json.Marshal(repl)
 	if err != nil {
-		return common.Hash{}, err
+		return nil, err
+	}
+	tx := types.NewTransaction(issuer, receiver, nil, BuildGasLimit, opts.gasPrice(), data, coreParams.ReplenishAssetTx, t.node.chain.ChainID(), opts.expiration(), "", "replenish asset tx")
+	return newUnsignedTxRes(tx, types.MakeSigner()), nil
+}
+
+// BuildModifyAssetTx build a tx to update the profile of asset
+func (t *PublicTxAPI) BuildModifyAssetTx(issuer common.Address, assetCode common.Hash, profile types.Profile, opts *BuildTxOptions) (*UnsignedTxRes, error) {
+	modify := &types.ModifyAssetInfo{
+		AssetCode:     assetCode,
+		UpdateProfile: profile,
+	}
+	data, err := json.Marshal(modify)
+	if err != nil {
+		return nil, err
+	}
+	tx := types.NoReceiverTransaction(issuer, nil, BuildGasLimit, opts.gasPrice(), data, coreParams.ModifyAssetTx, t.node.chain.ChainID(), opts.expiration(), "", "modify asset tx")
+	return newUnsignedTxRes(tx, types.MakeSigner()), nil
+}
+
+// BuildTransferAssetTx build a tx to transfer asset
+func (t *PublicTxAPI) BuildTransferAssetTx(sender, to common.Address, assetId common.Hash, amount *hexutil.Big10, input hexutil.Bytes, opts *BuildTxOptions) (*UnsignedTxRes, error) {
+	transfer := &types.TransferAsset{
+		AssetId: assetId,
+		Amount:  toBig(amount),
+		Input:   input,
 	}
-	private, _ := crypto.HexToECDSA(prv)
-	sender := crypto.PubkeyToAddress(private.PublicKey)
-	tx := types.NewTransaction(sender, receiver, nil, uint64(500000), big.NewInt(1), data, coreParams.ReplenishAssetTx, t.node.chain.ChainID(), uint64(time.Now().Unix()+30*60), "", "replenish asset tx")
+	data, err := json.Marshal(transfer)
+	if err != nil {
+		return nil, err
+	}
+	tx := types.NewTransaction(sender, to, toBig(amount), BuildGasLimit, opts.gasPrice(), data, coreParams.TransferAssetTx, t.node.chain.ChainID(), opts.expiration(), "", "trading asset tx")
+	return newUnsignedTxRes(tx, types.MakeSigner()), nil
+}
+
+// DevTxAPI is the legacy tx methods which take private keys. It is only registered in dev mode. The txs expire after 30
+// minutes as before. The gas price is params.MinGasPrice if gasPrice is absent, because the tx pool rejects the legacy
+// gas price 1
+type DevTxAPI struct {
+	txAPI *PublicTxAPI
+}
+
+func NewDevTxAPI(node *Node) *DevTxAPI {
+	return &DevTxAPI{NewPublicTxAPI(node)}
+}
+
+// devTxOptions return the options of the legacy txs
+func devTxOptions(gasPrice *hexutil.Big10) *BuildTxOptions {
+	expiration := uint64(time.Now().Unix() + 30*60)
+	return &BuildTxOptions{GasPrice: gasPrice, Expiration: &expiration}
+}
 
-	signTx, err := types.MakeSigner().SignTx(tx, private)
+// signAndSend sign the built tx by private key and send it
+func (d *DevTxAPI) signAndSend(res *UnsignedTxRes, err error, prv string) (common.Hash, error) {
+	if err != nil {
+		return common.Hash{}, err
+	}
+	private, err := crypto.HexToECDSA(prv)
 	if err != nil {
 		return common.Hash{}, err
 	}
-	return t.SendTx(signTx)
+	signTx, err := types.MakeSigner().SignTx(res.Tx, private)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return d.txAPI.SendTx(signTx)
 }
 
-// ModifyAsset 修改资产信息
-func (t *PublicTxAPI) ModifyAsset(prv string, assetCode common.Hash) (common.Hash, error) {
-	info := make(types.Profile)
-	info["name"] = "Modify"
-	info["stop"] = "true"
-	modify := &types.ModifyAssetInfo{
-		AssetCode:     assetCode,
-		UpdateProfile: info,
+// SendReimbursedGasTx gas代付交易 todo 测试使用
+func (d *DevTxAPI) SendReimbursedGasTx(senderPrivate, gasPayerPrivate string, from, to, gasPayer common.Address, amount int64, data []byte, txType uint16, toName, message string, gasPrice *hexutil.Big10) (common.Hash, error) {
+	opts := devTxOptions(gasPrice)
+	res := d.txAPI.BuildReimbursedGasTx(from, to, gasPayer, (*hexutil.Big10)(big.NewInt(amount)), data, txType, toName, message, opts)
+	senderPriv, err := crypto.HexToECDSA(senderPrivate)
+	if err != nil {
+		return common.Hash{}, err
 	}
-	data, err := json.Marshal(modify)
+	gasPayerPriv, err := crypto.HexToECDSA(gasPayerPrivate)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	firstSignTx, err := types.MakeReimbursementTxSigner().SignTx(res.Tx, senderPriv)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	res, err = d.txAPI.BuildGasPayerTx(firstSignTx, (*hexutil.Big10)(opts.gasPrice()), uint64(60000))
 	if err != nil {
 		return common.Hash{}, err
 	}
-	private, _ := crypto.HexToECDSA(prv)
-	sender := crypto.PubkeyToAddress(private.PublicKey)
-	tx := types.NoReceiverTransaction(sender, nil, uint64(500000), big.NewInt(1), data, coreParams.ModifyAssetTx, t.node.chain.ChainID(), uint64(time.Now().Unix()+30*60), "", "modify asset tx")
-	signTx, err := types.MakeSigner().SignTx(tx, private)
+	lastSignTx, err := types.MakeGasPayerSigner().SignTx(res.Tx, gasPayerPriv)
 	if err != nil {
 		return common.Hash{}, err
 	}
-	return t.SendTx(signTx)
+	return d.txAPI.SendTx(lastSignTx)
 }
 
-// 交易资产
-func (t *PublicTxAPI) TransferAsset(prv string, to common.Address, assetCode, assetId common.Hash, amount *big.Int, input []byte) (common.Hash, error) {
-	transfer := &types.TransferAsset{
-		AssetId: assetId,
-		Amount:  amount,
-		Input:   input,
+// CreateAsset 创建资产
+func (d *DevTxAPI) CreateAsset(prv string, category, decimals uint32, isReplenishable, isDivisible bool, gasPrice *hexutil.Big10) (common.Hash, error) {
+	private, err := crypto.HexToECDSA(prv)
+	if err != nil {
+		return common.Hash{}, err
 	}
-	data, err := json.Marshal(transfer)
+	profile := make(types.Profile)
+	profile[types.AssetName] = "Demo Token"
+	profile[types.AssetSymbol] = "DT"
+	profile[types.AssetDescription] = "test issue token"
+	profile[types.AssetFreeze] = "false"
+	profile[types.AssetSuggestedGasLimit] = "60000"
+	res, err := d.txAPI.buildCreateAssetTx(crypto.PubkeyToAddress(private.PublicKey), category, decimals, isReplenishable, isDivisible, profile, big.NewInt(1000000), devTxOptions(gasPrice))
+	return d.signAndSend(res, err, prv)
+}
+
+// 发行资产
+func (d *DevTxAPI) IssueAsset(prv string, receiver common.Address, assetCode common.Hash, amount *big.Int, metaData string, gasPrice *hexutil.Big10) (common.Hash, error) {
+	private, err := crypto.HexToECDSA(prv)
 	if err != nil {
 		return common.Hash{}, err
 	}
-	private, _ := crypto.HexToECDSA(prv)
-	sender := crypto.PubkeyToAddress(private.PublicKey)
-	tx := types.NewTransaction(sender, to, amount, uint64(500000), big.NewInt(1), data, coreParams.TransferAssetTx, t.node.chain.ChainID(), uint64(time.Now().Unix()+30*60), "", "trading asset tx")
-	signTx, err := types.MakeSigner().SignTx(tx, private)
+	res, err := d.txAPI.BuildIssueAssetTx(crypto.PubkeyToAddress(private.PublicKey), receiver, assetCode, (*hexutil.Big10)(amount), metaData, devTxOptions(gasPrice))
+	return d.signAndSend(res, err, prv)
+}
+
+// 增发资产
+func (d *DevTxAPI) ReplenishAsset(prv string, receiver common.Address, assetCode, assetId common.Hash, amount *big.Int, gasPrice *hexutil.Big10) (common.Hash, error) {
+	private, err := crypto.HexToECDSA(prv)
 	if err != nil {
 		return common.Hash{}, err
 	}
-	return t.SendTx(signTx)
+	res, err := d.txAPI.BuildReplenishAssetTx(crypto.PubkeyToAddress(private.PublicKey), receiver, assetCode, assetId, (*hexutil.Big10)(amount), devTxOptions(gasPrice))
+	return d.signAndSend(res, err, prv)
 }
 
-// // PendingTx
-// func (t *PublicTxAPI) PendingTx(size int) []*types.Transaction {
-// 	return t.node.txPool.Pending(size)
-// }
+// ModifyAsset 修改资产信息
+func (d *DevTxAPI) ModifyAsset(prv string, assetCode common.Hash, gasPrice *hexutil.Big10) (common.Hash, error) {
+	private, err := crypto.HexToECDSA(prv)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	info := make(types.Profile)
+	info["name"] = "Modify"
+	info["stop"] = "true"
+	res, err := d.txAPI.BuildModifyAssetTx(crypto.PubkeyToAddress(private.PublicKey), assetCode, info, devTxOptions(gasPrice))
+	return d.signAndSend(res, err, prv)
+}
+
+// 交易资产
+func (d *DevTxAPI) TransferAsset(prv string, to common.Address, assetCode, assetId common.Hash, amount *big.Int, input []byte, gasPrice *hexutil.Big10) (common.Hash, error) {
+	private, err := crypto.HexToECDSA(prv)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	res, err := d.txAPI.BuildTransferAssetTx(crypto.PubkeyToAddress(private.PublicKey), to, assetId, (*hexutil.Big10)(amount), input, devTxOptions(gasPrice))
+	return d.signAndSend(res, err, prv)
+}
+
+// PendingTx return the first size txs in pool, which are sent by this node but not in a stored block yet
+func (t *PublicTxAPI) PendingTx(size int) []*types.Transaction {
+	txs := t.node.txPool.Pending()
+	if size >= 0 && len(txs) > size {
+		txs = txs[:size]
+	}
+	return txs
+}
+
+// GetPendingTxByHash return the tx in pool, or nil if it is not pending
+func (t *PublicTxAPI) GetPendingTxByHash(hash string) *types.Transaction {
+	return t.node.txPool.Get(common.HexToHash(hash))
+}
+
+//go:generate gencodec -type TxStatusRes --field-override txStatusResMarshaling -out gen_tx_status_res_json.go
+type TxStatusRes struct {
+	Status        chain.TxStatus `json:"status" gencodec:"required"`
+	BlockHeight   uint32         `json:"blockHeight"`
+	BlockHash     common.Hash    `json:"blockHash"`
+	Confirmations uint32         `json:"confirmations"` // the count of stable blocks since the tx is included
+}
+type txStatusResMarshaling struct {
+	BlockHeight   hexutil.Uint32
+	Confirmations hexutil.Uint32
+}
+
+// GetStatus return the status of tx sent by this node, or nil if the tx is unknown
+func (t *PublicTxAPI) GetStatus(hash string) (*TxStatusRes, error) {
+	txHash := common.HexToHash(hash)
+	tx, err := database.NewTxDao(t.node.dbEngine).Get(txHash)
+	if err == nil {
+		res := &TxStatusRes{Status: chain.TxIncluded, BlockHeight: tx.Height, BlockHash: tx.BHash}
+		if stable := t.node.chain.StableBlock(); stable != nil && stable.Height() >= tx.Height {
+			res.Confirmations = stable.Height() - tx.Height + 1
+		}
+		return res, nil
+	} else if err != database.ErrNotExist {
+		return nil, err
+	}
+
+	if status, ok := t.node.txPool.Status(txHash); ok {
+		return &TxStatusRes{Status: status}, nil
+	}
+	return nil, nil
+}
 
 // // GetTxByHash pull the specified transaction through a transaction hash
 func (t *PublicTxAPI) GetTxByHash(hash string) (*store.VTransactionDetail, error) {
 	txHash := common.HexToHash(hash)
 
-	dbEngine := database.NewMySqlDB(t.node.config.DbDriver, t.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := t.node.dbEngine
 
 	txDao := database.NewTxDao(dbEngine)
 	tx, err := txDao.Get(txHash)
 	if err != nil {
-		if database.ErrIsNotExist(err) {
+		if err == database.ErrNotExist {
 			return nil, nil
 		}
 		return nil, err
 	} else {
-		return &store.VTransactionDetail{
-			BlockHash:   tx.BHash,
-			PHash:       tx.PHash,
-			Height:      tx.Height,
-			Tx:          tx.Tx,
-			PackageTime: tx.PackageTime,
-			AssetCode:   tx.AssetCode,
-			AssetId:     tx.AssetId,
-		}, nil
+		return txDetail(tx), nil
 	}
 }
 
@@ -667,8 +1042,7 @@ func (t *PublicTxAPI) GetTxListByAddress(lemoAddress string, index int, size int
 		return nil, err
 	}
 
-	dbEngine := database.NewMySqlDB(t.node.config.DbDriver, t.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := t.node.dbEngine
 
 	txDao := database.NewTxDao(dbEngine)
 	txes, total, err := txDao.GetByAddrWithTotal(src, index, size)
@@ -699,8 +1073,7 @@ func (t *PublicTxAPI) GetTxListByTimestamp(lemoAddress string, beginTime int64,
 		return nil, err
 	}
 
-	dbEngine := database.NewMySqlDB(t.node.config.DbDriver, t.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := t.node.dbEngine
 
 	txDao := database.NewTxDao(dbEngine)
 	txes, total, err := txDao.GetByTimeWithTotal(src, beginTime, endTime, index, size)
@@ -731,8 +1104,7 @@ func (t *PublicTxAPI) GetTxListByType(lemoAddress string, txType uint16, index i
 	if err != nil {
 		return nil, err
 	}
-	dbEngine := database.NewMySqlDB(t.node.config.DbDriver, t.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := t.node.dbEngine
 
 	txDao := database.NewTxDao(dbEngine)
 	txes, total, err := txDao.GetByTypeWithTotal(addr, txType, index, size)
@@ -762,8 +1134,7 @@ func (t *PublicTxAPI) GetAssetTxList(lemoAddress string, assetCodeOrId common.Ha
 	if err != nil {
 		return nil, err
 	}
-	dbEngine := database.NewMySqlDB(t.node.config.DbDriver, t.node.config.DbUri)
-	defer dbEngine.Close()
+	dbEngine := t.node.dbEngine
 
 	txDao := database.NewTxDao(dbEngine)
 	txes, total, err := txDao.GetByAddressAndAssetCodeOrAssetIdWithTotal(addr, assetCodeOrId, index, size)
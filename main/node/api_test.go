@@ -0,0 +1,30 @@
+package node
+
+import (
+	coreParams "github.com/LemoFoundationLtd/lemochain-core/chain/params"
+	"github.com/LemoFoundationLtd/lemochain-core/common/hexutil"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestBuildTxOptions(t *testing.T) {
+	now := uint64(time.Now().Unix())
+
+	// default
+	var opts *BuildTxOptions
+	assert.Equal(t, coreParams.MinGasPrice, opts.gasPrice())
+	assert.InDelta(t, now+uint64(coreParams.MaxTxLifeTime), opts.expiration(), 1)
+
+	// override
+	expiration := now + 60
+	opts = &BuildTxOptions{GasPrice: (*hexutil.Big10)(big.NewInt(2)), Expiration: &expiration}
+	assert.Equal(t, big.NewInt(2), opts.gasPrice())
+	assert.Equal(t, expiration, opts.expiration())
+
+	// the legacy dev txs expire after 30 minutes
+	opts = devTxOptions(nil)
+	assert.Equal(t, coreParams.MinGasPrice, opts.gasPrice())
+	assert.InDelta(t, now+30*60, opts.expiration(), 1)
+}
@@ -0,0 +1,200 @@
+package node
+
+import (
+	"context"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-core/common/log"
+	"github.com/LemoFoundationLtd/lemochain-core/common/subscribe"
+	"github.com/LemoFoundationLtd/lemochain-core/network/rpc"
+	"github.com/LemoFoundationLtd/lemochain-core/store"
+	"github.com/LemoFoundationLtd/lemochain-distribution/chain"
+	"github.com/LemoFoundationLtd/lemochain-distribution/database"
+	"sync"
+)
+
+// eventBufferSize is the count of block events which can be queued for a subscription
+const eventBufferSize = 64
+
+// eventHub dispatch the block events to rpc subscriptions. A slow subscription loses events instead of blocking the chain
+type eventHub struct {
+	blockCh chan *chain.BlockEvent
+	subs    map[rpc.ID]chan *chain.BlockEvent
+	lock    sync.Mutex
+	quitCh  chan struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		blockCh: make(chan *chain.BlockEvent),
+		subs:    make(map[rpc.ID]chan *chain.BlockEvent),
+		quitCh:  make(chan struct{}),
+	}
+}
+
+func (h *eventHub) start() {
+	subscribe.Sub(subscribe.NewStableBlock, h.blockCh)
+	go h.loop()
+}
+
+// stop should unsubscribe before quit the loop, or the sender will be blocked
+func (h *eventHub) stop() {
+	subscribe.UnSub(subscribe.NewStableBlock, h.blockCh)
+	close(h.quitCh)
+}
+
+func (h *eventHub) loop() {
+	for {
+		select {
+		case event := <-h.blockCh:
+			h.lock.Lock()
+			for id, ch := range h.subs {
+				select {
+				case ch <- event:
+				default:
+					log.Warnf("subscription %s is too slow, drop event of block %d", id, event.Block.Height())
+				}
+			}
+			h.lock.Unlock()
+		case <-h.quitCh:
+			return
+		}
+	}
+}
+
+func (h *eventHub) add(id rpc.ID) chan *chain.BlockEvent {
+	ch := make(chan *chain.BlockEvent, eventBufferSize)
+	h.lock.Lock()
+	h.subs[id] = ch
+	h.lock.Unlock()
+	return ch
+}
+
+func (h *eventHub) remove(id rpc.ID) {
+	h.lock.Lock()
+	delete(h.subs, id)
+	h.lock.Unlock()
+}
+
+type CandidateVotesInfo struct {
+	CandidateAddress string `json:"address"`
+	Votes            string `json:"votes"`
+	Cancelled        bool   `json:"cancelled"`
+}
+
+// PublicEventAPI push the changes of stable blocks by "event_subscribe". It only works on websocket
+type PublicEventAPI struct {
+	node *Node
+}
+
+func NewPublicEventAPI(node *Node) *PublicEventAPI {
+	return &PublicEventAPI{node}
+}
+
+// NewStableBlock push the new stable blocks
+func (e *PublicEventAPI) NewStableBlock(ctx context.Context, withBody bool) (*rpc.Subscription, error) {
+	return e.subscribe(ctx, func(notify func(data interface{}) error, event *chain.BlockEvent) error {
+		if withBody {
+			return notify(event.Block)
+		}
+		return notify(&types.Block{Header: event.Block.Header})
+	})
+}
+
+// NewTx push the txs which are sent from or to the address
+func (e *PublicEventAPI) NewTx(ctx context.Context, lemoAddress string) (*rpc.Subscription, error) {
+	address, err := common.StringToAddress(lemoAddress)
+	if err != nil {
+		return nil, err
+	}
+	return e.subscribe(ctx, func(notify func(data interface{}) error, event *chain.BlockEvent) error {
+		for _, tx := range event.Txs {
+			if tx.From == address || tx.To == address {
+				if err := notify(txDetail(tx)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// AssetTx push the asset txs of the asset code or asset id. All the asset txs are pushed if assetCodeOrId is empty
+func (e *PublicEventAPI) AssetTx(ctx context.Context, assetCodeOrId common.Hash) (*rpc.Subscription, error) {
+	return e.subscribe(ctx, func(notify func(data interface{}) error, event *chain.BlockEvent) error {
+		for _, tx := range event.Txs {
+			if (tx.AssetCode == common.Hash{}) && (tx.AssetId == common.Hash{}) {
+				continue
+			}
+			if (assetCodeOrId == common.Hash{}) || tx.AssetCode == assetCodeOrId || tx.AssetId == assetCodeOrId {
+				if err := notify(txDetail(tx)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// CandidateVotes push the candidates whose votes are changed
+func (e *PublicEventAPI) CandidateVotes(ctx context.Context) (*rpc.Subscription, error) {
+	return e.subscribe(ctx, func(notify func(data interface{}) error, event *chain.BlockEvent) error {
+		for _, candidate := range event.Candidates {
+			info := &CandidateVotesInfo{
+				CandidateAddress: candidate.Address.String(),
+				Votes:            "0",
+				Cancelled:        candidate.Cancelled,
+			}
+			if candidate.Votes != nil {
+				info.Votes = candidate.Votes.String()
+			}
+			if err := notify(info); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// subscribe create a subscription which calls the filter with every block event until the client unsubscribe or disconnect
+func (e *PublicEventAPI) subscribe(ctx context.Context, filter func(notify func(data interface{}) error, event *chain.BlockEvent) error) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+
+	sub := notifier.CreateSubscription()
+	eventCh := e.node.eventHub.add(sub.ID)
+	notify := func(data interface{}) error {
+		return notifier.Notify(sub.ID, data)
+	}
+	go func() {
+		defer e.node.eventHub.remove(sub.ID)
+		for {
+			select {
+			case event := <-eventCh:
+				if err := filter(notify, event); err != nil {
+					log.Debugf("notify subscription %s failed: %v", sub.ID, err)
+					return
+				}
+			case <-sub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+func txDetail(tx *database.Tx) *store.VTransactionDetail {
+	return &store.VTransactionDetail{
+		BlockHash:   tx.BHash,
+		PHash:       tx.PHash,
+		Height:      tx.Height,
+		Tx:          tx.Tx,
+		PackageTime: tx.PackageTime,
+		AssetCode:   tx.AssetCode,
+		AssetId:     tx.AssetId,
+	}
+}
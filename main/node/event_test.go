@@ -0,0 +1,184 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/params"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-core/common/subscribe"
+	"github.com/LemoFoundationLtd/lemochain-core/network/rpc"
+	"github.com/LemoFoundationLtd/lemochain-distribution/chain"
+	"github.com/LemoFoundationLtd/lemochain-distribution/database"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// blockNotification is the part of types.Block to check
+type blockNotification struct {
+	Header struct {
+		Hash common.Hash `json:"hash"`
+	} `json:"header"`
+	Txs []json.RawMessage `json:"transactions"`
+}
+
+// txNotification is the part of store.VTransactionDetail to check. The tx in notification can't be decoded without signature
+type txNotification struct {
+	BlockHash common.Hash `json:"blockHash"`
+	AssetCode common.Hash `json:"assetCode"`
+	Tx        struct {
+		Hash common.Hash `json:"hash"`
+	} `json:"tx"`
+}
+
+// newEventClient start an event hub and serve the event API to an in-process rpc client
+func newEventClient(t *testing.T) (*rpc.Client, func()) {
+	n := &Node{eventHub: newEventHub()}
+	n.eventHub.start()
+	server := rpc.NewServer()
+	assert.NoError(t, server.RegisterName("event", NewPublicEventAPI(n)))
+	client := rpc.DialInProc(server)
+	return client, func() {
+		client.Close()
+		server.Stop()
+		n.eventHub.stop()
+	}
+}
+
+func newBlockEvent(height uint32, from, to common.Address, assetCode common.Hash) *chain.BlockEvent {
+	block := types.NewBlock(&types.Header{Height: height, Time: 1600000000}, nil, nil)
+	ordinary := types.NewTransaction(from, to, big.NewInt(1), 21000, big.NewInt(1), nil, params.OrdinaryTx, 1, 1600000000, "", "")
+	asset := types.NewTransaction(from, to, big.NewInt(0), 21000, big.NewInt(1), []byte("{}"), params.TransferAssetTx, 1, 1600000000, "", "")
+	return &chain.BlockEvent{
+		Block: block,
+		Txs: []*database.Tx{
+			{BHash: block.Hash(), Height: height, THash: ordinary.Hash(), From: from, To: to, Tx: ordinary},
+			{BHash: block.Hash(), Height: height, THash: asset.Hash(), From: from, To: to, Tx: asset, AssetCode: assetCode, AssetId: assetCode},
+		},
+		Candidates: []*chain.CandidateEvent{
+			{Address: from, Votes: big.NewInt(100)},
+			{Address: to, Cancelled: true},
+		},
+	}
+}
+
+func TestPublicEventAPI_Subscribe(t *testing.T) {
+	client, closeClient := newEventClient(t)
+	defer closeClient()
+
+	from := common.HexToAddress("0x01")
+	to := common.HexToAddress("0x02")
+	other := common.HexToAddress("0x03")
+	assetCode := common.HexToHash("0x04")
+
+	ctx := context.Background()
+	blockCh := make(chan *blockNotification, 10)
+	sub, err := client.Subscribe(ctx, "event", blockCh, "newStableBlock", false)
+	assert.NoError(t, err)
+	defer sub.Unsubscribe()
+	txCh := make(chan *txNotification, 10)
+	sub, err = client.Subscribe(ctx, "event", txCh, "newTx", to.String())
+	assert.NoError(t, err)
+	defer sub.Unsubscribe()
+	otherTxCh := make(chan *txNotification, 10)
+	sub, err = client.Subscribe(ctx, "event", otherTxCh, "newTx", other.String())
+	assert.NoError(t, err)
+	defer sub.Unsubscribe()
+	assetCh := make(chan *txNotification, 10)
+	sub, err = client.Subscribe(ctx, "event", assetCh, "assetTx", assetCode)
+	assert.NoError(t, err)
+	defer sub.Unsubscribe()
+	voteCh := make(chan *CandidateVotesInfo, 10)
+	sub, err = client.Subscribe(ctx, "event", voteCh, "candidateVotes")
+	assert.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	// the server activates the subscriptions after their responses are sent
+	time.Sleep(100 * time.Millisecond)
+	event := newBlockEvent(1, from, to, assetCode)
+	subscribe.Send(subscribe.NewStableBlock, event)
+
+	timeout := time.After(5 * time.Second)
+	receive := func(name string, ch interface{}) interface{} {
+		switch ch := ch.(type) {
+		case chan *blockNotification:
+			select {
+			case v := <-ch:
+				return v
+			case <-timeout:
+			}
+		case chan *txNotification:
+			select {
+			case v := <-ch:
+				return v
+			case <-timeout:
+			}
+		case chan *CandidateVotesInfo:
+			select {
+			case v := <-ch:
+				return v
+			case <-timeout:
+			}
+		}
+		t.Fatalf("%s notification timeout", name)
+		return nil
+	}
+
+	block := receive("block", blockCh).(*blockNotification)
+	assert.Equal(t, event.Block.Hash(), block.Header.Hash)
+	assert.Empty(t, block.Txs)
+	for _, tx := range event.Txs {
+		detail := receive("tx", txCh).(*txNotification)
+		assert.Equal(t, tx.THash, detail.Tx.Hash)
+	}
+	detail := receive("asset", assetCh).(*txNotification)
+	assert.Equal(t, event.Txs[1].THash, detail.Tx.Hash)
+	assert.Equal(t, assetCode, detail.AssetCode)
+	vote := receive("vote", voteCh).(*CandidateVotesInfo)
+	assert.Equal(t, from.String(), vote.CandidateAddress)
+	assert.Equal(t, "100", vote.Votes)
+	assert.False(t, vote.Cancelled)
+	vote = receive("vote", voteCh).(*CandidateVotesInfo)
+	assert.Equal(t, to.String(), vote.CandidateAddress)
+	assert.Equal(t, "0", vote.Votes)
+	assert.True(t, vote.Cancelled)
+
+	// nothing is pushed to the unrelated address
+	select {
+	case tx := <-otherTxCh:
+		t.Fatalf("unexpected tx %s", tx.Tx.Hash.Hex())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEventHub_DropSlowSubscription(t *testing.T) {
+	hub := newEventHub()
+	hub.start()
+	defer hub.stop()
+
+	// nobody reads the subscription
+	ch := hub.add("slow")
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < eventBufferSize*2; i++ {
+			subscribe.Send(subscribe.NewStableBlock, newBlockEvent(uint32(i), common.HexToAddress("0x01"), common.HexToAddress("0x02"), common.Hash{}))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the sender is blocked by slow subscription")
+	}
+
+	// the oldest events are kept
+	assert.Equal(t, eventBufferSize, len(ch))
+	assert.Equal(t, uint32(0), (<-ch).Block.Height())
+
+	hub.remove("slow")
+	hub.lock.Lock()
+	assert.Empty(t, hub.subs)
+	hub.lock.Unlock()
+}
@@ -0,0 +1,18 @@
+//go:build darwin || dragonfly || freebsd || linux || nacl || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux nacl netbsd openbsd solaris
+
+package node
+
+import (
+	"github.com/LemoFoundationLtd/lemochain-core/network/rpc"
+	"net"
+	"syscall"
+)
+
+// createIPCListener create the unix socket under umask 0077, so it is never accessible by other users, even before the
+// mode is changed
+func createIPCListener(endpoint string) (net.Listener, error) {
+	old := syscall.Umask(0077)
+	defer syscall.Umask(old)
+	return rpc.CreateIPCListener(endpoint)
+}
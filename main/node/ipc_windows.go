@@ -0,0 +1,11 @@
+package node
+
+import (
+	"github.com/LemoFoundationLtd/lemochain-core/network/rpc"
+	"net"
+)
+
+// createIPCListener create the named pipe with the default security descriptor. There is no umask on windows
+func createIPCListener(endpoint string) (net.Listener, error) {
+	return rpc.CreateIPCListener(endpoint)
+}
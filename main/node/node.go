@@ -2,7 +2,6 @@ package node
 
 import (
 	"fmt"
-	"github.com/LemoFoundationLtd/lemochain-core/chain/account"
 	"github.com/LemoFoundationLtd/lemochain-core/common/flock"
 	"github.com/LemoFoundationLtd/lemochain-core/common/log"
 	coreNode "github.com/LemoFoundationLtd/lemochain-core/main/node"
@@ -10,23 +9,37 @@ import (
 	"github.com/LemoFoundationLtd/lemochain-core/store/protocol"
 	"github.com/LemoFoundationLtd/lemochain-distribution/chain"
 	"github.com/LemoFoundationLtd/lemochain-distribution/database"
+	"github.com/LemoFoundationLtd/lemochain-distribution/keystore"
 	"github.com/LemoFoundationLtd/lemochain-distribution/main/config"
+	"github.com/LemoFoundationLtd/lemochain-distribution/metrics"
 	. "github.com/LemoFoundationLtd/lemochain-distribution/network"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// refreshInterval is the interval to reload the stable block in reader mode
+	refreshInterval = 2 * time.Second
+	// keystoreDir is the directory of encrypted key files in DataDir
+	keystoreDir = "keystore"
 )
 
 type Node struct {
 	config *config.Config
 
-	db     protocol.ChainDB
-	accMan *account.Manager
-	chain  *chain.BlockChain
-	pm     *ProtocolManager
+	db       protocol.ChainDB
+	dbEngine *database.SqlDB // shared by the chain and all the APIs
+	keystore *keystore.KeyStore
+	chain    *chain.BlockChain
+	pm       *ProtocolManager
 
-	txPool *chain.TxPool
+	txPool   *chain.TxPool
+	eventHub *eventHub
 
 	instanceDirLock flock.Releaser
 
@@ -40,22 +53,60 @@ type Node struct {
 	wsEndpoint string
 	wsListener net.Listener
 	wsHandler  *rpc.Server
+
+	metricsEndpoint string
+	metricsListener net.Listener
+
+	ipcEndpoint string
+	ipcListener net.Listener
+	ipcHandler  *rpc.Server
+
+	leaseOwner string
+	pmOnce     sync.Once // the protocol manager starts after the node becomes writer
+	quitCh     chan struct{}
+	wg         sync.WaitGroup // the refresh and lease loops
 }
 
 func New(cfg *config.Config) (*Node, error) {
-	bc, err := chain.NewBlockChain(uint16(cfg.ChainID), int(cfg.DeputyCount), database.NewMySqlDB(cfg.DbDriver, cfg.DbUri))
+	db := database.NewSqlDB(cfg.DbDriver, cfg.DbUri)
+	db.SetPool(cfg.DbPoolConfig())
+	if cfg.Mode == config.ModeReader {
+		// the schema is owned by the sync node
+		if err := database.CheckSchema(db); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("check database failed: %v", err)
+		}
+	} else if err := database.CreateDB(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create database failed: %v", err)
+	}
+	bc, err := chain.NewBlockChain(uint16(cfg.ChainID), int(cfg.DeputyCount), cfg.UndoRetention, db)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
-	pm := NewProtocolManager(uint16(cfg.ChainID), cfg.CoreNodeID(), cfg.CoreEndpoint(), bc)
+	var pm *ProtocolManager
+	if cfg.Mode != config.ModeReader {
+		var upstreams []*Upstream
+		for _, addr := range cfg.CoreNodeAddrs() {
+			upstreams = append(upstreams, NewUpstream(addr.NodeID, addr.Endpoint))
+		}
+		pm = NewProtocolManager(uint16(cfg.ChainID), upstreams, bc)
+		// stand by until the writer lease is acquired
+		bc.SetWriterLease(cfg.LeaseOwner(), time.Unix(0, 0))
+	}
 
 	n := &Node{
-		config: cfg,
-		chain:  bc,
-		// accMan: bc.AccountManager(),
-		pm:     pm,
-		txPool: chain.NewTxPool(),
+		config:   cfg,
+		dbEngine: db,
+		chain:    bc,
+		keystore: keystore.New(filepath.Join(cfg.DataDir, keystoreDir), keystore.StandardScryptN, keystore.StandardScryptP),
+		pm:       pm,
+		txPool:   chain.NewTxPool(uint16(cfg.ChainID), db),
+		eventHub: newEventHub(),
+		quitCh:   make(chan struct{}),
 	}
+	n.leaseOwner = cfg.LeaseOwner()
 
 	return n, nil
 }
@@ -65,7 +116,14 @@ func (n *Node) Start() error {
 		log.Errorf("%v", err)
 		return coreNode.ErrOpenFileFailed
 	}
-	n.pm.Start()
+	n.eventHub.start()
+	n.wg.Add(1)
+	if n.isReader() {
+		go n.refreshLoop()
+	} else {
+		n.txPool.Start()
+		go n.leaseLoop()
+	}
 	if err := n.startRPC(); err != nil {
 		log.Errorf("%v", err)
 		return coreNode.ErrRpcStartFailed
@@ -75,20 +133,100 @@ func (n *Node) Start() error {
 
 func (n *Node) Stop() error {
 	n.stopRPC()
-	if err := n.accMan.Stop(true); err != nil {
-		log.Errorf("stop account manager failed: %v", err)
-		return err
+	close(n.quitCh)
+	n.wg.Wait()
+	n.eventHub.stop()
+	if !n.isReader() {
+		// stop syncing before the lease is released, so that no block is written after another node takes over
+		n.pmOnce.Do(func() {})
+		n.pm.Stop()
+		n.txPool.Stop()
+		if err := database.NewLeaseDao(n.dbEngine).Release(database.ContextKeyWriterLease, n.leaseOwner); err != nil {
+			log.Errorf("release writer lease failed: %v", err)
+		}
 	}
-	log.Debug("stop account manager ok...")
+	n.keystore.Close()
 	if n.instanceDirLock != nil {
 		if err := n.instanceDirLock.Release(); err != nil {
 			log.Errorf("Can't release datadir lock: %v", err)
 		}
 		n.instanceDirLock = nil
 	}
+	n.dbEngine.Close()
 	return nil
 }
 
+// isReader test if the node only serves RPC from the database which is written by another node
+func (n *Node) isReader() bool {
+	return n.config.Mode == config.ModeReader
+}
+
+// refreshLoop reload the stable block from database in reader mode
+func (n *Node) refreshLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.quitCh:
+			return
+		case <-ticker.C:
+			if err := n.chain.Refresh(); err != nil {
+				log.Errorf("refresh stable block failed: %v", err)
+			}
+		}
+	}
+}
+
+// leaseLoop keep the writer lease in sync mode. The node stands by as a reader until it takes over the lease
+func (n *Node) leaseLoop() {
+	defer n.wg.Done()
+	ttl := time.Duration(n.config.LeaseTTL) * time.Second
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		n.renewLease(ttl)
+		select {
+		case <-n.quitCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// renewLease acquire or renew the writer lease. The standby node reloads the stable block from database
+func (n *Node) renewLease(ttl time.Duration) {
+	// the lease expires at the database clock. The local deadline starts before acquiring, so it never outlasts the lease
+	start := time.Now()
+	ok, err := database.NewLeaseDao(n.dbEngine).Acquire(database.ContextKeyWriterLease, n.leaseOwner, ttl)
+	if err != nil {
+		// keep the current deadline. It expires by itself if the database is still unreachable
+		log.Errorf("renew writer lease failed: %v", err)
+		return
+	}
+	if ok {
+		if !n.chain.IsWriter() {
+			// the last writer may have inserted blocks
+			if err := n.chain.Refresh(); err != nil {
+				log.Errorf("refresh stable block failed: %v", err)
+				return
+			}
+			log.Infof("become the writer, lease owner: %s", n.leaseOwner)
+		}
+		n.chain.SetWriterLease(n.leaseOwner, start.Add(ttl))
+		n.pmOnce.Do(n.pm.Start)
+		return
+	}
+
+	if n.chain.IsWriter() {
+		log.Warn("writer lease is taken by another node")
+	}
+	n.chain.SetWriterLease(n.leaseOwner, time.Unix(0, 0))
+	if err := n.chain.Refresh(); err != nil {
+		log.Errorf("refresh stable block failed: %v", err)
+	}
+}
+
 func (n *Node) openDataDir() error {
 	if n.config.DataDir == "" {
 		return nil
@@ -111,12 +249,27 @@ func (n *Node) startRPC() error {
 			return err
 		}
 	}
-	if !n.config.Http.Disable {
+	if !n.config.WebSocket.Disable {
 		if err := n.startWS(apis); err != nil {
 			n.stopHttp()
 			return err
 		}
 	}
+	if !n.config.Metrics.Disable {
+		if err := n.startMetrics(); err != nil {
+			n.stopHttp()
+			n.stopWS()
+			return err
+		}
+	}
+	if !n.config.Ipc.Disable {
+		if err := n.startIPC(apis); err != nil {
+			n.stopHttp()
+			n.stopWS()
+			n.stopMetrics()
+			return err
+		}
+	}
 	n.rpcAPIs = apis
 	return nil
 }
@@ -142,7 +295,9 @@ func (n *Node) startHttp(apis []rpc.API) error {
 	}
 	cors := strings.Split(n.config.Http.CorsDomain, ",")
 	vhosts := strings.Split(n.config.Http.VirtualHosts, ",")
-	go rpc.NewHTTPServer(cors, vhosts, handler).Serve(listener)
+	server := rpc.NewHTTPServer(cors, vhosts, handler)
+	server.Handler = metrics.HTTPHandler(server.Handler, metrics.RPCMethods(apis))
+	go server.Serve(listener)
 	log.Info("HTTP endpoint opened", "url", fmt.Sprintf("http://%s", endpoint), "cors", strings.Join(cors, ","), "vhosts", strings.Join(vhosts, ","))
 	// All listeners booted successfully
 	n.httpEndpoint = endpoint
@@ -153,13 +308,77 @@ func (n *Node) startHttp(apis []rpc.API) error {
 }
 
 func (n *Node) startWS(apis []rpc.API) error {
-	// TODO
+	// Register all the APIs exposed by the services
+	handler := rpc.NewServer()
+	for _, api := range apis {
+		if api.Public {
+			if err := handler.RegisterName(api.Namespace, api.Service); err != nil {
+				return err
+			}
+		}
+	}
+	// All APIs registered, start the WebSocket listener
+	var (
+		listener net.Listener
+		err      error
+	)
+	endpoint := fmt.Sprintf("0.0.0.0:%d", n.config.WebSocket.Port)
+	if listener, err = net.Listen("tcp", endpoint); err != nil {
+		return err
+	}
+	cors := strings.Split(n.config.WebSocket.CorsDomain, ",")
+	go (&http.Server{Handler: metrics.WebsocketHandler(handler, cors, metrics.RPCMethods(apis))}).Serve(listener)
+	log.Info("WebSocket endpoint opened", "url", fmt.Sprintf("ws://%s", endpoint), "cors", strings.Join(cors, ","))
+	// All listeners booted successfully
+	n.wsEndpoint = endpoint
+	n.wsListener = listener
+	n.wsHandler = handler
+
+	return nil
+}
+
+// startMetrics serve the prometheus metrics at "/metrics"
+func (n *Node) startMetrics() error {
+	endpoint := fmt.Sprintf("0.0.0.0:%d", n.config.Metrics.Port)
+	listener, err := net.Listen("tcp", endpoint)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	go (&http.Server{Handler: mux}).Serve(listener)
+	log.Info("Metrics endpoint opened", "url", fmt.Sprintf("http://%s/metrics", endpoint))
+	n.metricsEndpoint = endpoint
+	n.metricsListener = listener
+	return nil
+}
+
+// startIPC serve all the APIs on the unix socket, including the private ones. The socket is only accessible by owner
+func (n *Node) startIPC(apis []rpc.API) error {
+	handler := rpc.NewServer()
+	for _, api := range apis {
+		if err := handler.RegisterName(api.Namespace, api.Service); err != nil {
+			return err
+		}
+	}
+	endpoint := n.config.IpcEndpoint()
+	listener, err := createIPCListener(endpoint)
+	if err != nil {
+		return err
+	}
+	go handler.ServeListener(listener)
+	log.Info("IPC endpoint opened", "url", endpoint)
+	n.ipcEndpoint = endpoint
+	n.ipcListener = listener
+	n.ipcHandler = handler
 	return nil
 }
 
 func (n *Node) stopRPC() {
 	n.stopHttp()
 	n.stopWS()
+	n.stopMetrics()
+	n.stopIPC()
 }
 
 func (n *Node) stopHttp() {
@@ -178,11 +397,48 @@ func (n *Node) stopHttp() {
 }
 
 func (n *Node) stopWS() {
-	// todo
+	if n.wsListener != nil {
+		if err := n.wsListener.Close(); err != nil {
+			log.Errorf("close wsListener failed: %v", err)
+		}
+		n.wsListener = nil
+
+		log.Info("WebSocket endpoint closed", "url", fmt.Sprintf("ws://%s", n.wsEndpoint))
+	}
+	if n.wsHandler != nil {
+		n.wsHandler.Stop()
+		n.wsHandler = nil
+	}
+}
+
+func (n *Node) stopIPC() {
+	if n.ipcListener != nil {
+		if err := n.ipcListener.Close(); err != nil {
+			log.Errorf("close ipcListener failed: %v", err)
+		}
+		n.ipcListener = nil
+
+		log.Info("IPC endpoint closed", "url", n.ipcEndpoint)
+	}
+	if n.ipcHandler != nil {
+		n.ipcHandler.Stop()
+		n.ipcHandler = nil
+	}
+}
+
+func (n *Node) stopMetrics() {
+	if n.metricsListener != nil {
+		if err := n.metricsListener.Close(); err != nil {
+			log.Errorf("close metricsListener failed: %v", err)
+		}
+		n.metricsListener = nil
+
+		log.Info("Metrics endpoint closed", "url", fmt.Sprintf("http://%s/metrics", n.metricsEndpoint))
+	}
 }
 
 func (n *Node) apis() []rpc.API {
-	return []rpc.API{
+	apis := []rpc.API{
 		{
 			Namespace: "chain",
 			Version:   "1.0",
@@ -207,5 +463,33 @@ func (n *Node) apis() []rpc.API {
 			Service:   NewPublicTxAPI(n),
 			Public:    true,
 		},
+		{
+			Namespace: "event",
+			Version:   "1.0",
+			Service:   NewPublicEventAPI(n),
+			Public:    true,
+		},
+		{
+			Namespace: "admin",
+			Version:   "1.0",
+			Service:   NewPrivateAdminAPI(n),
+			Public:    false,
+		},
+		{
+			Namespace: "debug",
+			Version:   "1.0",
+			Service:   NewPrivateDebugAPI(n),
+			Public:    false,
+		},
+	}
+	if n.config.DevMode {
+		log.Warn("dev mode is enabled, the tx methods which take private keys are exposed")
+		apis = append(apis, rpc.API{
+			Namespace: "tx",
+			Version:   "1.0",
+			Service:   NewDevTxAPI(n),
+			Public:    true,
+		})
 	}
+	return apis
 }
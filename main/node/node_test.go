@@ -0,0 +1,75 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/LemoFoundationLtd/lemochain-core/network/rpc"
+	"github.com/LemoFoundationLtd/lemochain-distribution/main/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+)
+
+type EchoService struct{}
+
+func (s *EchoService) Echo(value string) string {
+	return value
+}
+
+func TestNode_RPCEndpoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lemo-node")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cfg := &config.Config{DataDir: dir, Ipc: config.Ipc{Path: "test.ipc"}, Http: config.RpcHttp{Port: 0, CorsDomain: "*", VirtualHosts: "*"}}
+	n := &Node{config: cfg}
+	apis := []rpc.API{
+		{Namespace: "public", Version: "1.0", Service: &EchoService{}, Public: true},
+		{Namespace: "private", Version: "1.0", Service: &EchoService{}, Public: false},
+	}
+
+	// the ipc serves all the APIs, and only the owner can access the socket
+	assert.NoError(t, n.startIPC(apis))
+	defer n.stopIPC()
+	info, err := os.Stat(cfg.IpcEndpoint())
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	client, err := rpc.DialIPC(context.Background(), cfg.IpcEndpoint())
+	assert.NoError(t, err)
+	defer client.Close()
+	var result string
+	assert.NoError(t, client.Call(&result, "public_echo", "a"))
+	assert.Equal(t, "a", result)
+	assert.NoError(t, client.Call(&result, "private_echo", "b"))
+	assert.Equal(t, "b", result)
+
+	// the http serves the public APIs only
+	assert.NoError(t, n.startHttp(apis))
+	defer n.stopHttp()
+	url := fmt.Sprintf("http://127.0.0.1:%d", n.httpListener.Addr().(*net.TCPAddr).Port)
+	res := postRPC(t, url, "public_echo", "c")
+	assert.Nil(t, res.Error)
+	assert.Equal(t, `"c"`, string(res.Result))
+	res = postRPC(t, url, "private_echo", "d")
+	assert.NotNil(t, res.Error)
+}
+
+type rpcResponse struct {
+	Result json.RawMessage  `json:"result"`
+	Error  *json.RawMessage `json:"error"`
+}
+
+// postRPC call the method with a param by http
+func postRPC(t *testing.T, url, method, param string) *rpcResponse {
+	body, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": method, "params": []string{param}})
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	var res rpcResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	return &res
+}
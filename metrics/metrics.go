@@ -0,0 +1,80 @@
+// Package metrics collects the health of sync, database and RPC, and exports them in prometheus format
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+const namespace = "lemo_distribution"
+
+var (
+	StableHeight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "stable_height",
+		Help:      "Height of the stable block in database",
+	})
+	CoreStableHeight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "core_stable_height",
+		Help:      "Height of the stable block in the latest status of core peer",
+	})
+	SyncLag = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sync_lag",
+		Help:      "How many stable blocks the database is behind core peer",
+	})
+	BlockCacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "block_cache_size",
+		Help:      "Count of the received blocks which are waiting for their parents",
+	})
+	ReBuildDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rebuild_duration_seconds",
+		Help:      "Time to save a block to database",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	})
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Latency of the dao queries",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"op", "table"})
+	RPCCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_calls_total",
+		Help:      "Count of the rpc method calls",
+	}, []string{"method"})
+	RPCErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_errors_total",
+		Help:      "Count of the rpc method calls which return error",
+	}, []string{"method"})
+	CoreReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "core_reconnects_total",
+		Help:      "Count of the reconnections to core node",
+	})
+	CoreDialFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "core_dial_failures_total",
+		Help:      "Count of the failed dials to core node",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(StableHeight, CoreStableHeight, SyncLag, BlockCacheSize, ReBuildDuration, DBQueryDuration, RPCCalls, RPCErrors, CoreReconnects, CoreDialFailures)
+}
+
+// Handler serve the metrics for prometheus scraping
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveQuery record the latency of a database operation which started at start
+func ObserveQuery(op, table string, start time.Time) {
+	DBQueryDuration.WithLabelValues(op, table).Observe(time.Since(start).Seconds())
+}
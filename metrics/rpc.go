@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/LemoFoundationLtd/lemochain-core/network/rpc"
+	"golang.org/x/net/websocket"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync"
+	"unicode"
+)
+
+// maxRequestContentLength is the same as the limit in rpc server
+const maxRequestContentLength = 1024 * 128
+
+// unknownMethod is the label of the methods which are not registered. It limits the label values sent by clients
+const unknownMethod = "unknown"
+
+// rpcMessage contains the common fields of json rpc request and response
+type rpcMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// RPCMethods return the method names of the public apis, which look like "chain_getBlockByHeight"
+func RPCMethods(apis []rpc.API) map[string]bool {
+	methods := make(map[string]bool)
+	for _, api := range apis {
+		if !api.Public {
+			continue
+		}
+		methods[api.Namespace+"_subscribe"] = true
+		methods[api.Namespace+"_unsubscribe"] = true
+		t := reflect.TypeOf(api.Service)
+		for i := 0; i < t.NumMethod(); i++ {
+			name := []rune(t.Method(i).Name)
+			name[0] = unicode.ToLower(name[0])
+			methods[api.Namespace+"_"+string(name)] = true
+		}
+	}
+	return methods
+}
+
+// rpcObserver count the calls and errors of the rpc methods on one connection by the raw json messages
+type rpcObserver struct {
+	methods map[string]bool
+	pending map[string]string // request id -> method
+	lock    sync.Mutex
+}
+
+func newRPCObserver(methods map[string]bool) *rpcObserver {
+	return &rpcObserver{methods: methods, pending: make(map[string]string)}
+}
+
+func (o *rpcObserver) observeRequest(raw []byte) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for _, msg := range parseMessages(raw) {
+		method := msg.Method
+		if !o.methods[method] {
+			method = unknownMethod
+		}
+		RPCCalls.WithLabelValues(method).Inc()
+		if len(msg.ID) > 0 {
+			o.pending[string(msg.ID)] = method
+		}
+	}
+}
+
+func (o *rpcObserver) observeResponse(raw []byte) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for _, msg := range parseMessages(raw) {
+		// the subscription notifications have method but no id
+		if len(msg.ID) == 0 || msg.Method != "" {
+			continue
+		}
+		method, ok := o.pending[string(msg.ID)]
+		if !ok {
+			// the request is not a valid json rpc message
+			method = unknownMethod
+		}
+		delete(o.pending, string(msg.ID))
+		if len(msg.Error) > 0 && string(msg.Error) != "null" {
+			RPCErrors.WithLabelValues(method).Inc()
+		}
+	}
+}
+
+// parseMessages decode a single message or a batch of messages
+func parseMessages(raw []byte) []rpcMessage {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '[' {
+		var batch []rpcMessage
+		if err := json.Unmarshal(raw, &batch); err != nil {
+			return nil
+		}
+		return batch
+	}
+	var msg rpcMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return nil
+	}
+	return []rpcMessage{msg}
+}
+
+// responseRecorder keep a copy of the http response
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+// HTTPHandler count the rpc calls which are served by next
+func HTTPHandler(next http.Handler, methods map[string]bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRequestContentLength+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		observer := newRPCObserver(methods)
+		observer.observeRequest(body)
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		observer.observeResponse(recorder.body.Bytes())
+	})
+}
+
+// WebsocketHandler serve srv on websocket like srv.WebsocketHandler, and count the rpc calls
+func WebsocketHandler(srv *rpc.Server, allowedOrigins []string, methods map[string]bool) http.Handler {
+	// reuse the origin check of rpc server
+	wsServer := srv.WebsocketHandler(allowedOrigins).(websocket.Server)
+	wsServer.Handler = func(conn *websocket.Conn) {
+		conn.MaxPayloadBytes = maxRequestContentLength
+		observer := newRPCObserver(methods)
+
+		encoder := func(v interface{}) error {
+			msg, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			observer.observeResponse(msg)
+			return websocket.Message.Send(conn, string(msg))
+		}
+		decoder := func(v interface{}) error {
+			var msg []byte
+			if err := websocket.Message.Receive(conn, &msg); err != nil {
+				return err
+			}
+			observer.observeRequest(msg)
+			dec := json.NewDecoder(bytes.NewReader(msg))
+			dec.UseNumber()
+			return dec.Decode(v)
+		}
+		srv.ServeCodec(rpc.NewCodec(conn, encoder, decoder))
+	}
+	return wsServer
+}
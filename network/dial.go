@@ -7,55 +7,156 @@ import (
 	"github.com/LemoFoundationLtd/lemochain-core/common/log"
 	"github.com/LemoFoundationLtd/lemochain-core/common/subscribe"
 	"github.com/LemoFoundationLtd/lemochain-core/network/p2p"
+	"github.com/LemoFoundationLtd/lemochain-distribution/metrics"
 	"net"
+	"sync"
 	"time"
 )
 
 const (
 	AddNewCorePeer = "addNewCorePeer"
 	GetNewTx       = "getNewTx"
+	CorePeerReady  = "corePeerReady" // sent after the handshake with a core peer succeeds
+	TxForwarded    = "txForwarded"   // sent after a tx is written to the core peer
 )
 
+const (
+	scoreInit      = 50
+	scoreMax       = 100
+	scoreConnected = 10 // the score is raised by every successful connection, and halved by every failure
+)
+
+// Upstream is a core node to sync blocks from
+type Upstream struct {
+	NodeID   *p2p.NodeID
+	Endpoint string
+	score    int
+}
+
+func NewUpstream(nodeID *p2p.NodeID, endpoint string) *Upstream {
+	return &Upstream{NodeID: nodeID, Endpoint: endpoint, score: scoreInit}
+}
+
+// DialManager dial the healthiest upstream. It fails over to another upstream when the current one fails
 type DialManager struct {
-	coreNodeID       *p2p.NodeID
-	coreNodeEndpoint string
+	upstreams []*Upstream
+	current   *Upstream // the upstream which is dialing or connected
+	synced    *Upstream // the last upstream which has been accepted
+	lock      sync.Mutex
 }
 
-func NewDialManager(coreNodeID *p2p.NodeID, coreNodeEndpoint string) *DialManager {
+func NewDialManager(upstreams []*Upstream) *DialManager {
 	return &DialManager{
-		coreNodeID:       coreNodeID,
-		coreNodeEndpoint: coreNodeEndpoint,
+		upstreams: upstreams,
+	}
+}
+
+// Current return the upstream which is dialing or connected
+func (dm *DialManager) Current() *Upstream {
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	return dm.current
+}
+
+// IsSwitching test if the upstream is not the last accepted one
+func (dm *DialManager) IsSwitching(upstream *Upstream) bool {
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	return dm.synced != nil && dm.synced != upstream
+}
+
+// Accept record that the upstream is connected and on the same chain
+func (dm *DialManager) Accept(upstream *Upstream) {
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	if dm.synced != upstream {
+		log.Infof("sync from core node: %s", upstream.Endpoint)
+	}
+	dm.synced = upstream
+	upstream.score += scoreConnected
+	if upstream.score > scoreMax {
+		upstream.score = scoreMax
+	}
+}
+
+// Fail lower the score of the upstream, so that another upstream is preferred in next dial
+func (dm *DialManager) Fail(upstream *Upstream) {
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	upstream.score /= 2
+}
+
+// Reject set the score of the upstream which is not on our chain to the lowest
+func (dm *DialManager) Reject(upstream *Upstream) {
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	upstream.score = 0
+}
+
+// pick choose the upstream with the highest score. The upstreams after the current one are preferred if the scores are equal
+func (dm *DialManager) pick() *Upstream {
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	start := 0
+	for i, upstream := range dm.upstreams {
+		if upstream == dm.current {
+			start = i + 1
+			break
+		}
+	}
+	var best *Upstream
+	for i := 0; i < len(dm.upstreams); i++ {
+		upstream := dm.upstreams[(start+i)%len(dm.upstreams)]
+		if best == nil || upstream.score > best.score {
+			best = upstream
+		}
 	}
+	dm.current = best
+	return best
+}
+
+// Others return the upstreams except the current one
+func (dm *DialManager) Others() []*Upstream {
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	others := make([]*Upstream, 0, len(dm.upstreams))
+	for _, upstream := range dm.upstreams {
+		if upstream != dm.current {
+			others = append(others, upstream)
+		}
+	}
+	return others
 }
 
 // Dial run dial
 func (dm *DialManager) Dial() {
-	// dial
-	conn, err := net.DialTimeout("tcp", dm.coreNodeEndpoint, 5*time.Second)
+	upstream := dm.pick()
+	p, err := dm.Connect(upstream)
 	if err != nil {
-		log.Warnf("dial node error: %s", err.Error())
-		SetConnectResult(false)
-		return
-	}
-
-	// handle connection
-	if err = dm.handleConn(conn); err != nil {
 		if err != p2p.ErrConnectSelf {
+			log.Warnf("dial node error: %s", err.Error())
+			metrics.CoreDialFailures.Inc()
+			dm.Fail(upstream)
 			SetConnectResult(false)
 		}
-		log.Debugf("handle connection error: %s", err)
 		return
 	}
+	// go dm.runPeer(p)
+	subscribe.Send(AddNewCorePeer, p)
 }
 
-// handleConn handle the connection
-func (dm *DialManager) handleConn(fd net.Conn) error {
+// Connect dial the upstream and finish the p2p handshake
+func (dm *DialManager) Connect(upstream *Upstream) (p2p.IPeer, error) {
+	fd, err := net.DialTimeout("tcp", upstream.Endpoint, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
 	p := p2p.NewPeer(fd)
-	if err := p.DoHandshake(deputynode.GetSelfNodeKey(), dm.coreNodeID); err != nil {
-		if err = fd.Close(); err != nil {
+	if err := p.DoHandshake(deputynode.GetSelfNodeKey(), upstream.NodeID); err != nil {
+		if err := fd.Close(); err != nil {
 			log.Errorf("close connection failed: %v", err)
 		}
-		return err
+		return nil, err
 	}
 	// is self
 	if bytes.Compare(p.RNodeID()[:], deputynode.GetSelfNodeID()) == 0 {
@@ -64,11 +165,9 @@ func (dm *DialManager) handleConn(fd net.Conn) error {
 		} else {
 			log.Error("can't connect self")
 		}
-		return p2p.ErrConnectSelf
+		return nil, p2p.ErrConnectSelf
 	}
-	// go dm.runPeer(p)
-	subscribe.Send(AddNewCorePeer, p)
-	return nil
+	return p, nil
 }
 
 // runPeer run the connected peer
@@ -76,6 +175,9 @@ func (dm *DialManager) runPeer(p p2p.IPeer) {
 	if err := p.Run(); err != nil { // block this
 		log.Debugf("runPeer error: %v", err)
 	}
+	if upstream := dm.Current(); upstream != nil {
+		dm.Fail(upstream)
+	}
 	SetConnectResult(false)
 	log.Debugf("peer Run finished: %s", common.ToHex(p.RNodeID()[:8]))
 }
@@ -0,0 +1,72 @@
+package network
+
+import (
+	"fmt"
+	"github.com/LemoFoundationLtd/lemochain-core/network/p2p"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func newTestUpstreams(count int) []*Upstream {
+	upstreams := make([]*Upstream, count)
+	for i := range upstreams {
+		upstreams[i] = NewUpstream(&p2p.NodeID{byte(i + 1)}, fmt.Sprintf("127.0.0.1:%d", 60001+i))
+	}
+	return upstreams
+}
+
+func TestDialManager_Failover(t *testing.T) {
+	upstreams := newTestUpstreams(3)
+	dm := NewDialManager(upstreams)
+
+	// the first upstream is picked if the scores are equal
+	assert.Equal(t, upstreams[0], dm.pick())
+	assert.Equal(t, upstreams[0], dm.Current())
+	assert.Equal(t, []*Upstream{upstreams[1], upstreams[2]}, dm.Others())
+	dm.Accept(upstreams[0])
+	assert.False(t, dm.IsSwitching(upstreams[0]))
+
+	// the failing upstream is demoted, and the next one is picked
+	dm.Fail(upstreams[0])
+	assert.Equal(t, (scoreInit+scoreConnected)/2, upstreams[0].score)
+	assert.Equal(t, upstreams[1], dm.pick())
+	assert.Equal(t, []*Upstream{upstreams[0], upstreams[2]}, dm.Others())
+	assert.True(t, dm.IsSwitching(upstreams[1]))
+
+	// the rejected upstream is never preferred
+	dm.Reject(upstreams[1])
+	assert.Equal(t, 0, upstreams[1].score)
+	assert.Equal(t, upstreams[2], dm.pick())
+	dm.Fail(upstreams[2])
+	dm.Fail(upstreams[2])
+	assert.Equal(t, upstreams[0], dm.pick())
+
+	// the score doesn't exceed the max
+	for i := 0; i < 10; i++ {
+		dm.Accept(upstreams[0])
+	}
+	assert.Equal(t, scoreMax, upstreams[0].score)
+	assert.False(t, dm.IsSwitching(upstreams[0]))
+}
+
+func TestDialManager_Dial(t *testing.T) {
+	// nobody listens on the closed port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	closedEndpoint := listener.Addr().String()
+	assert.NoError(t, listener.Close())
+
+	upstreams := newTestUpstreams(2)
+	upstreams[0].Endpoint = closedEndpoint
+	upstreams[1].Endpoint = closedEndpoint
+	dm := NewDialManager(upstreams)
+
+	dm.Dial()
+	assert.Equal(t, upstreams[0], dm.Current())
+	assert.Equal(t, scoreInit/2, upstreams[0].score)
+	dm.Dial()
+	assert.Equal(t, upstreams[1], dm.Current())
+	assert.Equal(t, scoreInit/2, upstreams[1].score)
+	assert.Equal(t, []*Upstream{upstreams[0]}, dm.Others())
+}
@@ -0,0 +1,318 @@
+package network
+
+import (
+	"errors"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common/log"
+	coreNetwork "github.com/LemoFoundationLtd/lemochain-core/network"
+	"sync"
+	"time"
+)
+
+const (
+	DownloadThreshold = 1024             // download in parallel if the core node is ahead more than this
+	downloadRangeSize = 128              // blocks requested from a peer at once
+	maxDownloadRanges = 32               // ranges downloaded ahead of insertion
+	downloadTimeout   = 30 * time.Second // the peer is dropped if it doesn't send any block of a request in time
+	replyIdleTimeout  = 3 * time.Second  // the missing blocks are requested again if the peer stops sending blocks
+	coreBatchSize     = 10               // the core node replies a request in messages of at most this count of blocks
+)
+
+var (
+	ErrDownloading     = errors.New("downloader is running")
+	ErrNoDownloadPeer  = errors.New("no peer to download blocks")
+	ErrDownloadStopped = errors.New("download is stopped")
+	ErrBlockNotLinked  = errors.New("downloaded block is not the child of previous block")
+)
+
+// blockRange is a range of heights which is requested from one peer
+type blockRange struct {
+	from     uint32
+	to       uint32
+	blocks   []*types.Block // indexed by height - from
+	missing  int
+	peer     *peer
+	deadline time.Time // the peer is dropped if nothing is received before deadline
+	replied  time.Time // the time of the last block received for the current request. Zero if nothing is received
+}
+
+func newBlockRange(from, to uint32) *blockRange {
+	return &blockRange{
+		from:    from,
+		to:      to,
+		blocks:  make([]*types.Block, to-from+1),
+		missing: int(to - from + 1),
+	}
+}
+
+// firstMissing return the lowest height which is not received
+func (r *blockRange) firstMissing() uint32 {
+	for i, block := range r.blocks {
+		if block == nil {
+			return r.from + uint32(i)
+		}
+	}
+	return r.to + 1
+}
+
+// fill save the blocks in range, and return the count of new blocks
+func (r *blockRange) fill(blocks types.Blocks) int {
+	count := 0
+	for _, block := range blocks {
+		if block == nil || block.Height() < r.from || block.Height() > r.to {
+			continue
+		}
+		index := block.Height() - r.from
+		if r.blocks[index] == nil {
+			r.blocks[index] = block
+			r.missing--
+			count++
+		}
+	}
+	return count
+}
+
+type delivery struct {
+	peer   *peer
+	blocks types.Blocks
+}
+
+// Downloader download the blocks from several peers in parallel, and insert them strictly in height order
+type Downloader struct {
+	chain     coreNetwork.BlockChain
+	deliverCh chan *delivery
+	done      chan struct{} // it is not nil while downloading
+	lock      sync.Mutex
+
+	timeout     time.Duration
+	idleTimeout time.Duration
+	tick        time.Duration // the interval to check the timeouts
+}
+
+func NewDownloader(chain coreNetwork.BlockChain) *Downloader {
+	return &Downloader{
+		chain:       chain,
+		deliverCh:   make(chan *delivery),
+		timeout:     downloadTimeout,
+		idleTimeout: replyIdleTimeout,
+		tick:        time.Second,
+	}
+}
+
+// Running test if the downloader is downloading
+func (d *Downloader) Running() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.done != nil
+}
+
+// Deliver pass the blocks received from peer to downloader. It returns false if the downloader is not running
+func (d *Downloader) Deliver(p *peer, blocks types.Blocks) bool {
+	d.lock.Lock()
+	done := d.done
+	d.lock.Unlock()
+	if done == nil {
+		return false
+	}
+	select {
+	case d.deliverCh <- &delivery{peer: p, blocks: blocks}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// Run download the blocks in [from, to] from the peers, and insert them into chain. It returns after all the blocks are
+// inserted, or there is no peer to download from
+func (d *Downloader) Run(peers []*peer, from, to uint32, quit <-chan struct{}) error {
+	d.lock.Lock()
+	if d.done != nil {
+		d.lock.Unlock()
+		return ErrDownloading
+	}
+	done := make(chan struct{})
+	d.done = done
+	d.lock.Unlock()
+
+	// the ranges are inserted by another goroutine, so that the downloading goes on while inserting
+	insertCh := make(chan *blockRange, maxDownloadRanges)
+	resultCh := make(chan error, maxDownloadRanges)
+	insertExit := make(chan struct{})
+	go func() {
+		defer close(insertExit)
+		d.insertLoop(d.chain.StableBlock(), insertCh, resultCh, done)
+	}()
+	defer func() {
+		// stop the inserter and wait for the inserting range, so no block is inserted after return
+		close(done)
+		close(insertCh)
+		<-insertExit
+		d.lock.Lock()
+		d.done = nil
+		d.lock.Unlock()
+	}()
+
+	log.Infof("start downloading blocks from %d to %d with %d peers", from, to, len(peers))
+	idle := append([]*peer{}, peers...)
+	var pending []*blockRange // the ranges not inserted, ordered by height
+	inserting := 0
+	next := from // the lowest height which is not scheduled
+	ticker := time.NewTicker(d.tick)
+	defer ticker.Stop()
+	for {
+		// assign the failed ranges first, so that the insertion is not blocked
+		for _, r := range pending {
+			if len(idle) == 0 {
+				break
+			}
+			if r.peer == nil && r.missing > 0 {
+				idle = d.assign(r, idle)
+			}
+		}
+		// backpressure: only a limited count of ranges can be downloaded ahead of the insertion
+		for len(idle) > 0 && next <= to && len(pending)+inserting < maxDownloadRanges {
+			end := next + downloadRangeSize - 1
+			if end > to || end < next {
+				end = to
+			}
+			r := newBlockRange(next, end)
+			pending = append(pending, r)
+			next = end + 1
+			idle = d.assign(r, idle)
+		}
+		// hand the completed ranges to inserter in height order
+		for len(pending) > 0 && pending[0].missing == 0 {
+			insertCh <- pending[0]
+			pending = pending[1:]
+			inserting++
+		}
+		if len(pending) == 0 && inserting == 0 && next > to {
+			log.Infof("download blocks from %d to %d success", from, to)
+			return nil
+		}
+		if len(idle) == 0 && !hasWorkingPeer(pending) {
+			return ErrNoDownloadPeer
+		}
+
+		select {
+		case <-quit:
+			return ErrDownloadStopped
+		case dl := <-d.deliverCh:
+			for _, r := range pending {
+				if r.peer != dl.peer || r.fill(dl.blocks) == 0 {
+					continue
+				}
+				r.replied = time.Now()
+				if r.missing == 0 {
+					r.peer = nil
+					idle = append(idle, dl.peer)
+				} else if replyEnded(r, dl.blocks) {
+					// the peer has capped its reply, request the rest at once
+					d.request(r)
+				}
+				break
+			}
+		case err := <-resultCh:
+			inserting--
+			if err != nil {
+				return err
+			}
+		case now := <-ticker.C:
+			for _, r := range pending {
+				if r.peer == nil {
+					continue
+				}
+				if r.replied.IsZero() && now.After(r.deadline) {
+					log.Warnf("download blocks [%d, %d] timeout, drop peer %s", r.firstMissing(), r.to, r.peer.NodeID().String()[:16])
+					r.peer = nil
+				} else if !r.replied.IsZero() && now.Sub(r.replied) > d.idleTimeout {
+					d.request(r)
+				}
+			}
+		}
+	}
+}
+
+// replyEnded test if the blocks are the last message of the reply to the request of range. The core node replies in
+// messages of coreBatchSize blocks in height order, so a shorter message or the message with the last height ends it
+func replyEnded(r *blockRange, blocks types.Blocks) bool {
+	last := blocks[len(blocks)-1]
+	return len(blocks) < coreBatchSize || last == nil || last.Height() >= r.to
+}
+
+// assign request the missing blocks of range from the first idle peer
+func (d *Downloader) assign(r *blockRange, idle []*peer) []*peer {
+	r.peer = idle[0]
+	d.request(r)
+	return idle[1:]
+}
+
+// request the missing blocks of range from its peer
+func (d *Downloader) request(r *blockRange) {
+	r.deadline = time.Now().Add(d.timeout)
+	r.replied = time.Time{}
+	if r.peer.RequestBlocks(r.firstMissing(), r.to) != 0 {
+		// the deadline will drop the peer
+		log.Warnf("request blocks [%d, %d] failed", r.firstMissing(), r.to)
+	}
+}
+
+// batchInserter is implemented by the chain which can prepare the next blocks while committing the current one
+type batchInserter interface {
+	InsertBlocks(blocks []*types.Block) error
+}
+
+// insertLoop insert the blocks of ranges one by one. The downloaded blocks must be linked to the stable block, so that
+// a wrong peer can't make the chain switch fork. It stops at the first error
+func (d *Downloader) insertLoop(parent *types.Block, insertCh <-chan *blockRange, resultCh chan<- error, done <-chan struct{}) {
+	for r := range insertCh {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		err := checkLinked(parent, r.blocks)
+		if err == nil {
+			err = d.insertBlocks(r.blocks)
+		}
+		resultCh <- err
+		if err != nil {
+			return
+		}
+		parent = r.blocks[len(r.blocks)-1]
+	}
+}
+
+func (d *Downloader) insertBlocks(blocks []*types.Block) error {
+	if inserter, ok := d.chain.(batchInserter); ok {
+		return inserter.InsertBlocks(blocks)
+	}
+	for _, block := range blocks {
+		if err := d.chain.InsertBlock(block); err != nil {
+			log.Errorf("insert downloaded block [%d] failed: %v", block.Height(), err)
+			return err
+		}
+	}
+	return nil
+}
+
+// checkLinked test if every block is the child of the previous one
+func checkLinked(parent *types.Block, blocks []*types.Block) error {
+	for _, block := range blocks {
+		if parent != nil && block.ParentHash() != parent.Hash() {
+			log.Errorf("downloaded block [%d] is not linked to its parent", block.Height())
+			return ErrBlockNotLinked
+		}
+		parent = block
+	}
+	return nil
+}
+
+func hasWorkingPeer(ranges []*blockRange) bool {
+	for _, r := range ranges {
+		if r.peer != nil {
+			return true
+		}
+	}
+	return false
+}
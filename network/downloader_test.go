@@ -0,0 +1,276 @@
+package network
+
+import (
+	"crypto/ecdsa"
+	"github.com/LemoFoundationLtd/lemochain-core/chain/types"
+	"github.com/LemoFoundationLtd/lemochain-core/common"
+	"github.com/LemoFoundationLtd/lemochain-core/common/rlp"
+	coreNetwork "github.com/LemoFoundationLtd/lemochain-core/network"
+	"github.com/LemoFoundationLtd/lemochain-core/network/p2p"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testConn is a p2p connection which records the block requests
+type testConn struct {
+	id       p2p.NodeID
+	requests chan [2]uint32
+}
+
+func newTestPeer(id byte) (*peer, *testConn) {
+	conn := &testConn{id: p2p.NodeID{id}, requests: make(chan [2]uint32, 100)}
+	return newPeer(conn), conn
+}
+
+func (c *testConn) ReadMsg() (*p2p.Msg, error) { select {} }
+func (c *testConn) WriteMsg(code p2p.MsgCode, msg []byte) error {
+	var query coreNetwork.GetBlocksData
+	if err := rlp.DecodeBytes(msg, &query); err != nil {
+		return err
+	}
+	c.requests <- [2]uint32{query.From, query.To}
+	return nil
+}
+func (c *testConn) SetWriteDeadline(duration time.Duration)                     {}
+func (c *testConn) RNodeID() *p2p.NodeID                                        { return &c.id }
+func (c *testConn) RAddress() string                                            { return "" }
+func (c *testConn) LAddress() string                                            { return "" }
+func (c *testConn) DoHandshake(prv *ecdsa.PrivateKey, nodeID *p2p.NodeID) error { return nil }
+func (c *testConn) Run() error                                                  { return nil }
+func (c *testConn) NeedReConnect() bool                                         { return false }
+func (c *testConn) SetStatus(status int32)                                      {}
+func (c *testConn) Close()                                                      {}
+
+// nextRequest return the next block request sent to the peer, or zero if there is no request in time
+func (c *testConn) nextRequest(timeout time.Duration) [2]uint32 {
+	select {
+	case r := <-c.requests:
+		return r
+	case <-time.After(timeout):
+		return [2]uint32{}
+	}
+}
+
+// testChain record the inserted blocks
+type testChain struct {
+	genesis  *types.Block
+	inserted []*types.Block
+	lock     sync.Mutex
+	// hold blocks the insertion of the first block until it is closed, if it isn't nil
+	entered chan struct{}
+	hold    chan struct{}
+}
+
+func (c *testChain) Genesis() *types.Block                        { return c.genesis }
+func (c *testChain) HasBlock(hash common.Hash) bool               { return false }
+func (c *testChain) GetBlockByHeight(height uint32) *types.Block  { return nil }
+func (c *testChain) GetBlockByHash(hash common.Hash) *types.Block { return nil }
+func (c *testChain) CurrentBlock() *types.Block                   { return c.genesis }
+func (c *testChain) StableBlock() *types.Block                    { return c.genesis }
+func (c *testChain) InsertConfirms(height uint32, blockHash common.Hash, sigList []types.SignData) {
+}
+func (c *testChain) IsInBlackList(b *types.Block) bool { return false }
+func (c *testChain) InsertBlock(block *types.Block) error {
+	if c.hold != nil && block.Height() == 1 {
+		close(c.entered)
+		<-c.hold
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.inserted = append(c.inserted, block)
+	return nil
+}
+
+// makeTestBlocks create the linked blocks from genesis to height
+func makeTestBlocks(height uint32) []*types.Block {
+	blocks := make([]*types.Block, 0, height+1)
+	parent := common.Hash{}
+	for h := uint32(0); h <= height; h++ {
+		block := types.NewBlock(&types.Header{ParentHash: parent, Height: h}, nil, nil)
+		blocks = append(blocks, block)
+		parent = block.Hash()
+	}
+	return blocks
+}
+
+func TestDownloader_Run(t *testing.T) {
+	const wait = 2 * time.Second
+	tests := []struct {
+		name  string
+		peers int
+		to    uint32
+		// serve plays the peers. It delivers the blocks and checks the requests
+		serve func(t *testing.T, d *Downloader, peers []*peer, conns []*testConn, blocks []*types.Block)
+		err   error
+	}{
+		{
+			name:  "out of order",
+			peers: 2,
+			to:    200,
+			serve: func(t *testing.T, d *Downloader, peers []*peer, conns []*testConn, blocks []*types.Block) {
+				assert.Equal(t, [2]uint32{1, 128}, conns[0].nextRequest(wait))
+				assert.Equal(t, [2]uint32{129, 200}, conns[1].nextRequest(wait))
+				// the higher range is completed first, and the blocks in a message are reversed
+				assert.True(t, d.Deliver(peers[1], reversed(blocks[129:201])))
+				for from := 1; from <= 128; from += coreBatchSize {
+					end := from + coreBatchSize
+					if end > 129 {
+						end = 129
+					}
+					assert.True(t, d.Deliver(peers[0], blocks[from:end]))
+				}
+			},
+		},
+		{
+			name:  "partial reply is requested again at once",
+			peers: 1,
+			to:    25,
+			serve: func(t *testing.T, d *Downloader, peers []*peer, conns []*testConn, blocks []*types.Block) {
+				assert.Equal(t, [2]uint32{1, 25}, conns[0].nextRequest(wait))
+				assert.True(t, d.Deliver(peers[0], blocks[1:6]))
+				assert.Equal(t, [2]uint32{6, 25}, conns[0].nextRequest(50*time.Millisecond))
+				assert.True(t, d.Deliver(peers[0], blocks[6:16]))
+				assert.True(t, d.Deliver(peers[0], blocks[16:26]))
+			},
+		},
+		{
+			name:  "reply capped at batch size is requested again after idle",
+			peers: 1,
+			to:    25,
+			serve: func(t *testing.T, d *Downloader, peers []*peer, conns []*testConn, blocks []*types.Block) {
+				assert.Equal(t, [2]uint32{1, 25}, conns[0].nextRequest(wait))
+				assert.True(t, d.Deliver(peers[0], blocks[1:11]))
+				// the rest is requested from the same peer instead of dropping it
+				assert.Equal(t, [2]uint32{11, 25}, conns[0].nextRequest(wait))
+				assert.True(t, d.Deliver(peers[0], blocks[11:26]))
+			},
+		},
+		{
+			name:  "timeout peer is dropped and its range is reassigned",
+			peers: 2,
+			to:    200,
+			serve: func(t *testing.T, d *Downloader, peers []*peer, conns []*testConn, blocks []*types.Block) {
+				assert.Equal(t, [2]uint32{1, 128}, conns[0].nextRequest(wait))
+				assert.Equal(t, [2]uint32{129, 200}, conns[1].nextRequest(wait))
+				assert.True(t, d.Deliver(peers[1], blocks[129:201]))
+				// peer 0 doesn't reply
+				assert.Equal(t, [2]uint32{1, 128}, conns[1].nextRequest(wait))
+				assert.True(t, d.Deliver(peers[1], blocks[1:129]))
+				// the dropped peer is ignored
+				assert.Empty(t, conns[0].requests)
+			},
+		},
+		{
+			name:  "no peer",
+			peers: 1,
+			to:    25,
+			serve: func(t *testing.T, d *Downloader, peers []*peer, conns []*testConn, blocks []*types.Block) {
+				assert.Equal(t, [2]uint32{1, 25}, conns[0].nextRequest(wait))
+			},
+			err: ErrNoDownloadPeer,
+		},
+		{
+			name:  "backpressure",
+			peers: maxDownloadRanges + 2,
+			to:    downloadRangeSize * (maxDownloadRanges + 2),
+			serve: func(t *testing.T, d *Downloader, peers []*peer, conns []*testConn, blocks []*types.Block) {
+				for i := 0; i < maxDownloadRanges; i++ {
+					from := uint32(i*downloadRangeSize + 1)
+					assert.Equal(t, [2]uint32{from, from + downloadRangeSize - 1}, conns[i].nextRequest(wait))
+				}
+				// no more range is requested until a range is inserted
+				assert.Equal(t, [2]uint32{}, conns[maxDownloadRanges].nextRequest(50*time.Millisecond))
+				assert.True(t, d.Deliver(peers[0], blocks[1:downloadRangeSize+1]))
+				from := uint32(maxDownloadRanges*downloadRangeSize + 1)
+				assert.Equal(t, [2]uint32{from, from + downloadRangeSize - 1}, conns[maxDownloadRanges].nextRequest(wait))
+			},
+			err: ErrDownloadStopped,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			blocks := makeTestBlocks(test.to)
+			chain := &testChain{genesis: blocks[0]}
+			d := NewDownloader(chain)
+			d.timeout = 200 * time.Millisecond
+			d.idleTimeout = 100 * time.Millisecond
+			d.tick = 10 * time.Millisecond
+			peers := make([]*peer, test.peers)
+			conns := make([]*testConn, test.peers)
+			for i := range peers {
+				peers[i], conns[i] = newTestPeer(byte(i + 1))
+			}
+
+			quit := make(chan struct{})
+			result := make(chan error, 1)
+			go func() {
+				result <- d.Run(peers, 1, test.to, quit)
+			}()
+			test.serve(t, d, peers, conns, blocks)
+			if test.err == ErrDownloadStopped {
+				close(quit)
+			}
+			select {
+			case err := <-result:
+				assert.Equal(t, test.err, err)
+			case <-time.After(wait):
+				t.Fatal("download is not finished")
+			}
+			assert.False(t, d.Running())
+			if test.err == nil {
+				chain.lock.Lock()
+				assert.Equal(t, blocks[1:], chain.inserted)
+				chain.lock.Unlock()
+			}
+		})
+	}
+}
+
+func TestDownloader_StopWhileInserting(t *testing.T) {
+	blocks := makeTestBlocks(200)
+	chain := &testChain{genesis: blocks[0], entered: make(chan struct{}), hold: make(chan struct{})}
+	d := NewDownloader(chain)
+	peer1, conn1 := newTestPeer(1)
+	peer2, conn2 := newTestPeer(2)
+	quit := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- d.Run([]*peer{peer1, peer2}, 1, 200, quit)
+	}()
+
+	// the first range is inserting while the second one is waiting for insertion
+	assert.Equal(t, [2]uint32{1, 128}, conn1.nextRequest(time.Second))
+	assert.Equal(t, [2]uint32{129, 200}, conn2.nextRequest(time.Second))
+	assert.True(t, d.Deliver(peer1, blocks[1:129]))
+	<-chain.entered
+	assert.True(t, d.Deliver(peer2, blocks[129:201]))
+	close(quit)
+
+	// Run returns after the inserting range is done, and the waiting range is not inserted
+	select {
+	case <-result:
+		t.Fatal("download returns while inserting")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(chain.hold)
+	select {
+	case err := <-result:
+		assert.Equal(t, ErrDownloadStopped, err)
+	case <-time.After(time.Second):
+		t.Fatal("download is not finished")
+	}
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+	assert.Equal(t, blocks[1:129], chain.inserted)
+}
+
+func reversed(blocks []*types.Block) types.Blocks {
+	result := make(types.Blocks, len(blocks))
+	for i, block := range blocks {
+		result[len(blocks)-1-i] = block
+	}
+	return result
+}
@@ -10,11 +10,19 @@ import (
 	coreNetwork "github.com/LemoFoundationLtd/lemochain-core/network"
 	"github.com/LemoFoundationLtd/lemochain-core/network/p2p"
 	"github.com/LemoFoundationLtd/lemochain-distribution/chain/params"
+	"github.com/LemoFoundationLtd/lemochain-distribution/metrics"
 	"strconv"
 	"sync"
 	"time"
 )
 
+var (
+	ErrChainIDMismatch = errors.New("chain id of core node is different")
+	ErrGenesisMismatch = errors.New("genesis block of core node is different")
+	ErrStableMismatch  = errors.New("stable block of core node is not in our chain")
+	ErrHelperBehind    = errors.New("core node doesn't have the blocks to download")
+)
+
 const (
 	ForceSyncInterval = 10 * time.Second
 	ReconnectInterval = 5 * time.Second
@@ -29,6 +37,7 @@ type ProtocolManager struct {
 	rcvBlocksCh    chan types.Blocks
 	corePeer       *peer
 	dialManager    *DialManager
+	downloader     *Downloader
 	isStopping     bool
 	newPeerCh      chan p2p.IPeer
 	dialCh         chan struct{}
@@ -37,12 +46,13 @@ type ProtocolManager struct {
 	quitCh         chan struct{}
 }
 
-func NewProtocolManager(chainID uint16, coreNodeID *p2p.NodeID, coreNodeEndpoint string, chain coreNetwork.BlockChain) *ProtocolManager {
+func NewProtocolManager(chainID uint16, upstreams []*Upstream, chain coreNetwork.BlockChain) *ProtocolManager {
 	pm := &ProtocolManager{
 		chainID:     chainID,
 		nodeVersion: params.VersionUint(),
 		chain:       chain,
-		dialManager: NewDialManager(coreNodeID, coreNodeEndpoint),
+		dialManager: NewDialManager(upstreams),
+		downloader:  NewDownloader(chain),
 		blockCache:  coreNetwork.NewBlockCache(),
 		txCh:        make(chan *types.Transaction),
 		rcvBlocksCh: make(chan types.Blocks),
@@ -81,7 +91,7 @@ func (pm *ProtocolManager) Start() {
 
 // Stop
 func (pm *ProtocolManager) Stop() {
-	if !pm.isStopping {
+	if pm.forceSyncTimer == nil {
 		log.Infof("ProtocolManager not start")
 		return
 	}
@@ -107,6 +117,7 @@ func (pm *ProtocolManager) stopCoreNode() {
 func (pm *ProtocolManager) resetDialTask() {
 	if !pm.isStopping && GetConnectResult() {
 		log.Debug("start reconnect...")
+		metrics.CoreReconnects.Inc()
 		pm.corePeer = nil
 		pm.dialCh <- struct{}{}
 	}
@@ -168,9 +179,13 @@ func (pm *ProtocolManager) txLoop() {
 			log.Info("txConfirmLoop finished")
 			return
 		case tx := <-pm.txCh:
-			if pm.corePeer != nil {
+			if p := pm.corePeer; p != nil {
 				log.Infof("send txs to core peer,tx: %s", tx.String())
-				go pm.corePeer.SendTxs(types.Transactions{tx})
+				go func() {
+					if p.SendTxs(types.Transactions{tx}) == 0 {
+						subscribe.Send(TxForwarded, tx)
+					}
+				}()
 			}
 		}
 	}
@@ -203,8 +218,8 @@ func (pm *ProtocolManager) rcvBlockLoop() {
 			pLstHeight := pm.corePeer.LatestStatus().StaHeight
 
 			for _, b := range blocks {
-				// block is exist
-				if pm.chain.StableBlock() != nil && (b.Height() <= pm.chain.StableBlock().Height() || pm.chain.HasBlock(b.Hash())) {
+				// block is exist. a block not higher than stable block but not exist is on another fork
+				if pm.chain.HasBlock(b.Hash()) {
 					continue
 				}
 				// update latest status
@@ -220,15 +235,19 @@ func (pm *ProtocolManager) rcvBlockLoop() {
 				}
 			}
 		case <-queueTimer.C:
+			// Iterate removes the block if callback returns true, so don't call pm.insertBlock which removes it again
 			processBlock := func(block *types.Block) bool {
 				if pm.chain.HasBlock(block.ParentHash()) {
-					pm.insertBlock(block)
+					if err := pm.chain.InsertBlock(block); err != nil {
+						log.Errorf("insertBlock [%d] failed: %v", block.Height(), err)
+					}
 					return true
 				}
 				return false
 			}
 			pm.blockCache.Iterate(processBlock)
 			queueTimer.Reset(proInterval)
+			pm.updateMetrics()
 			// output cache size
 			cacheSize := pm.blockCache.Size()
 			if cacheSize > 0 && pm.corePeer != nil {
@@ -251,6 +270,10 @@ func (pm *ProtocolManager) reqStatusLoop() {
 		case <-pm.quitCh:
 			return
 		case <-pm.forceSyncTimer.C:
+			if pm.downloader.Running() {
+				pm.forceSyncTimer.Reset(ForceSyncInterval)
+				break
+			}
 			log.Info("reqStatusLoop: start forceSync block")
 			if pm.corePeer != nil {
 				if pm.chain.StableBlock() == nil || pm.corePeer.LatestStatus().StaHeight > pm.chain.StableBlock().Height() {
@@ -265,6 +288,24 @@ func (pm *ProtocolManager) reqStatusLoop() {
 	}
 }
 
+// updateMetrics record the sync progress
+func (pm *ProtocolManager) updateMetrics() {
+	metrics.BlockCacheSize.Set(float64(pm.blockCache.Size()))
+	p := pm.corePeer
+	if p == nil {
+		return
+	}
+	coreHeight := p.LatestStatus().StaHeight
+	metrics.CoreStableHeight.Set(float64(coreHeight))
+	var lag uint32
+	if stable := pm.chain.StableBlock(); stable == nil {
+		lag = coreHeight + 1
+	} else if coreHeight > stable.Height() {
+		lag = coreHeight - stable.Height()
+	}
+	metrics.SyncLag.Set(float64(lag))
+}
+
 // insertBlock insert block
 func (pm *ProtocolManager) insertBlock(b *types.Block) {
 	if err := pm.chain.InsertBlock(b); err != nil {
@@ -284,6 +325,15 @@ func (pm *ProtocolManager) handlePeer() {
 		SetConnectResult(false)
 		return
 	}
+	upstream := pm.dialManager.Current()
+	if err := pm.checkUpstream(upstream, rStatus); err != nil {
+		log.Warnf("reject core node %s: %v", upstream.Endpoint, err)
+		pm.dialManager.Reject(upstream)
+		p.HardForkClose()
+		SetConnectResult(false)
+		return
+	}
+	pm.dialManager.Accept(upstream)
 
 	// sync block
 	from, err := pm.findSyncFrom(&rStatus.LatestStatus)
@@ -292,13 +342,19 @@ func (pm *ProtocolManager) handlePeer() {
 		p.HardForkClose()
 		return
 	}
-	p.RequestBlocks(from, rStatus.LatestStatus.StaHeight)
+	if rStatus.LatestStatus.StaHeight >= from+DownloadThreshold {
+		go pm.download(p, from, rStatus.LatestStatus.StaHeight)
+	} else {
+		p.RequestBlocks(from, rStatus.LatestStatus.StaHeight)
+	}
 	log.Debugf("start handle msg")
 	// set first sync height
 	if pm.corePeer != nil {
 		pm.corePeer.SetFirstSyncHeight(rStatus.LatestStatus.StaHeight)
 	}
 	SetConnectResult(true)
+	// the pending txs are dropped while there is no core peer
+	go subscribe.Send(CorePeerReady, struct{}{})
 
 	for {
 		// handle peer net message
@@ -340,9 +396,109 @@ func (pm *ProtocolManager) handshake(p *peer) (*ProtocolHandshake, error) {
 	return remoteStatus, nil
 }
 
+// checkUpstream test if the upstream is on our chain. The stable block of a new upstream must be the same as ours
+func (pm *ProtocolManager) checkUpstream(upstream *Upstream, rStatus *ProtocolHandshake) error {
+	if rStatus.ChainID != pm.chainID {
+		return ErrChainIDMismatch
+	}
+	genesis := pm.chain.Genesis()
+	if genesis != nil && (rStatus.GenesisHash != common.Hash{}) && rStatus.GenesisHash != genesis.Hash() {
+		return ErrGenesisMismatch
+	}
+	// the same upstream may revert its unstable blocks, which is handled by findSyncFrom
+	if !pm.dialManager.IsSwitching(upstream) {
+		return nil
+	}
+	stable := pm.chain.StableBlock()
+	if stable == nil || rStatus.LatestStatus.StaHeight > stable.Height() {
+		return nil
+	}
+	local := pm.chain.GetBlockByHeight(rStatus.LatestStatus.StaHeight)
+	if local == nil || local.Hash() != rStatus.LatestStatus.StaHash {
+		return ErrStableMismatch
+	}
+	return nil
+}
+
+// download catch up the blocks in [from, to] from the core peer and the other upstreams in parallel
+func (pm *ProtocolManager) download(p *peer, from, to uint32) {
+	helpers := pm.connectHelpers(to)
+	defer func() {
+		for _, helper := range helpers {
+			helper.NormalClose()
+		}
+	}()
+	peers := append([]*peer{p}, helpers...)
+	if err := pm.downloader.Run(peers, from, to, pm.quitCh); err != nil {
+		// the rest blocks are synced by forceSyncBlock
+		log.Warnf("download blocks failed: %v", err)
+	}
+}
+
+// connectHelpers connect the other upstreams which have the blocks until height. They only send blocks to downloader
+func (pm *ProtocolManager) connectHelpers(height uint32) []*peer {
+	var helpers []*peer
+	for _, upstream := range pm.dialManager.Others() {
+		conn, err := pm.dialManager.Connect(upstream)
+		if err != nil {
+			log.Debugf("connect download helper %s failed: %v", upstream.Endpoint, err)
+			continue
+		}
+		go conn.Run()
+		helper := newPeer(conn)
+		rStatus, err := helper.Handshake((&ProtocolHandshake{ChainID: pm.chainID, NodeVersion: pm.nodeVersion}).Bytes())
+		if err == nil {
+			err = pm.checkHelper(rStatus, height)
+		}
+		if err != nil {
+			log.Debugf("download helper %s is not available: %v", upstream.Endpoint, err)
+			helper.NormalClose()
+			continue
+		}
+		go pm.helperLoop(helper)
+		helpers = append(helpers, helper)
+	}
+	return helpers
+}
+
+// checkHelper test if the helper is on our chain and has the blocks until height
+func (pm *ProtocolManager) checkHelper(rStatus *ProtocolHandshake, height uint32) error {
+	if rStatus.ChainID != pm.chainID {
+		return ErrChainIDMismatch
+	}
+	genesis := pm.chain.Genesis()
+	if genesis != nil && (rStatus.GenesisHash != common.Hash{}) && rStatus.GenesisHash != genesis.Hash() {
+		return ErrGenesisMismatch
+	}
+	if rStatus.LatestStatus.StaHeight < height {
+		return ErrHelperBehind
+	}
+	return nil
+}
+
+// helperLoop pass the blocks received from helper to downloader, and ignore the other messages
+func (pm *ProtocolManager) helperLoop(p *peer) {
+	for {
+		msg, err := p.ReadMsg()
+		if err != nil {
+			return
+		}
+		if msg.Code != p2p.BlocksMsg {
+			continue
+		}
+		var blocks types.Blocks
+		if err := msg.Decode(&blocks); err != nil {
+			log.Debugf("decode blocks from download helper failed: %v", err)
+			p.RcvBadDataClose()
+			return
+		}
+		pm.downloader.Deliver(p, blocks)
+	}
+}
+
 // forceSyncBlock force to sync block
 func (pm *ProtocolManager) forceSyncBlock(status *LatestStatus, p *peer) {
-	if pm.chain.StableBlock() != nil && status.StaHeight <= pm.chain.StableBlock().Height() {
+	if pm.chain.StableBlock() != nil && status.StaHeight <= pm.chain.StableBlock().Height() && pm.chain.HasBlock(status.StaHash) {
 		return
 	}
 	from, err := pm.findSyncFrom(status)
@@ -365,7 +521,10 @@ func (pm *ProtocolManager) findSyncFrom(rStatus *LatestStatus) (uint32, error) {
 
 	if curBlock.Height() >= rStatus.StaHeight {
 		if !pm.chain.HasBlock(rStatus.StaHash) {
-			return 0, errors.New("core peer is not on the same chain")
+			// core peer is on another fork. Request its stable block, then the parents are requested one by one
+			// from blockCache until the fork point is found
+			log.Warnf("core peer is not on the same chain. stable height: %d", rStatus.StaHeight)
+			return rStatus.StaHeight, nil
 		}
 	}
 	from = curBlock.Height() + 1
@@ -468,6 +627,9 @@ func (pm *ProtocolManager) handleBlocksMsg(msg *p2p.Msg, p *peer) error {
 	if err := msg.Decode(&blocks); err != nil {
 		return fmt.Errorf("handleBlocksMsg error: %v", err)
 	}
+	if pm.downloader.Deliver(p, blocks) {
+		return nil
+	}
 	pm.rcvBlocksCh <- blocks
 	return nil
 }